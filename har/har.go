@@ -0,0 +1,135 @@
+// Package har contains the types making up an HTTP Archive (HAR) 1.2 log,
+// as described at http://www.softwareishard.com/blog/har-12-spec/
+package har
+
+import (
+	"time"
+)
+
+// Version is the HAR specification version produced by this package.
+const Version = "1.2"
+
+// HAR is the top-level object of a .har file.
+type HAR struct {
+	Log *Log `json:"log"`
+}
+
+// Log is the root of the exported data.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator identifies the application that produced the log.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a single HTTP exchange.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total elapsed time of the exchange in milliseconds.
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    Cache    `json:"cache"`
+	Timings  Timings  `json:"timings"`
+	// ServerIPAddress is the IP address of the server, if known.
+	ServerIPAddress string `json:"serverIPAddress,omitempty"`
+	// Connection identifies the client side of the TCP connection.
+	Connection string `json:"connection,omitempty"`
+	// TLS is a custom field describing the TLS session, if there was one.
+	TLS *TLS `json:"_tls,omitempty"`
+	// ID is a custom field holding the exchange's correlation ID.
+	ID string `json:"_id,omitempty"`
+}
+
+// TLS describes the TLS session an exchange was made over.
+type TLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ALPN        string `json:"alpn,omitempty"`
+	SNI         string `json:"sni,omitempty"`
+}
+
+// Request describes a performed request.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+	// Trailers is a custom field holding headers sent after the body.
+	Trailers []NameValue `json:"_trailers,omitempty"`
+}
+
+// Response describes a received response.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+	// Trailers is a custom field holding headers sent after the body.
+	Trailers []NameValue `json:"_trailers,omitempty"`
+}
+
+// NameValue is used for headers and query string parameters.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cookie describes a cookie sent or set.
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// PostData describes a request body.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is "base64" for binary bodies.  It is not part of HAR 1.2,
+	// but mirrors Content.Encoding and is understood by common tools.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Content describes a response body.
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Cache holds information about cache usage.  httpwatch has no cache
+// information, but the object is required.
+type Cache struct{}
+
+// Timings breaks down the elapsed time of an exchange, in milliseconds.
+// Unknown optional phases are -1.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
@@ -0,0 +1,82 @@
+package httpsource
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// QueryParams returns the parsed query string of the request.  Repeated
+// parameters keep all of their values.  An empty url.Values is returned if
+// there is no request.
+func (p *RequestResponsePair) QueryParams() url.Values {
+	if p.Request == nil || p.Request.URL == nil {
+		return url.Values{}
+	}
+	return p.Request.URL.Query()
+}
+
+// HeaderValue returns the first value of the named request header, matching
+// the name case-insensitively, or an empty string if it is absent.
+func (p *RequestResponsePair) HeaderValue(name string) string {
+	if p.Request == nil {
+		return ""
+	}
+	return headerValue(p.Request.Header, name)
+}
+
+// ResponseHeaderValue is like HeaderValue, for the response headers.
+func (p *RequestResponsePair) ResponseHeaderValue(name string) string {
+	if p.Response == nil {
+		return ""
+	}
+	return headerValue(p.Response.Header, name)
+}
+
+// TrailerValue returns the first value of the named response trailer, or of
+// the request trailer if the response has none by that name, matching the
+// name case-insensitively.  Returns an empty string if neither was sent.
+func (p *RequestResponsePair) TrailerValue(name string) string {
+	if v := headerValue(p.ResponseTrailers, name); v != "" {
+		return v
+	}
+	return headerValue(p.RequestTrailers, name)
+}
+
+// DetectContentType returns the Content-Type of the response, or of the
+// request if there is no response.  If the header is absent, the type is
+// sniffed from the body using http.DetectContentType.  Returns an empty
+// string if there is neither a header nor a body.
+func (p *RequestResponsePair) DetectContentType() string {
+	if p.Response != nil {
+		return detectContentType(p.Response.Header, p.ResponseBody)
+	}
+	if p.Request != nil {
+		return detectContentType(p.Request.Header, p.RequestBody)
+	}
+	return ""
+}
+
+func detectContentType(h http.Header, body []byte) string {
+	if ct := headerValue(h, "Content-Type"); ct != "" {
+		return ct
+	}
+	if len(body) == 0 {
+		return ""
+	}
+	return http.DetectContentType(body)
+}
+
+// headerValue is http.Header.Get, but also finds headers whose names were
+// stored without being canonicalized, as decoded pairs may have.
+func headerValue(h http.Header, name string) string {
+	if v := h.Get(name); v != "" {
+		return v
+	}
+	for key, vals := range h {
+		if strings.EqualFold(key, name) && len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
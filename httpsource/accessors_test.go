@@ -0,0 +1,60 @@
+package httpsource
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestQueryParams(t *testing.T) {
+	pair := testPair(t, "GET /search?q=go&tag=a&tag=b HTTP/1.1\r\nHost: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n")
+	params := pair.QueryParams()
+	if params.Get("q") != "go" || !reflect.DeepEqual(params["tag"], []string{"a", "b"}) {
+		t.Errorf("Unexpected params: %v\n", params)
+	}
+	if params := (&RequestResponsePair{}).QueryParams(); params == nil || len(params) != 0 {
+		t.Errorf("Expected empty params without a request, got %v\n", params)
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	pair := &RequestResponsePair{
+		Request:  &http.Request{Header: http.Header{"X-Token": {"abc"}, "x-raw": {"raw"}}},
+		Response: &http.Response{Header: http.Header{"Content-Type": {"text/html"}}},
+	}
+	if v := pair.HeaderValue("x-token"); v != "abc" {
+		t.Errorf("Expected abc, got %q\n", v)
+	}
+	if v := pair.HeaderValue("X-Raw"); v != "raw" {
+		t.Errorf("Expected non-canonical header to be found, got %q\n", v)
+	}
+	if v := pair.ResponseHeaderValue("content-type"); v != "text/html" {
+		t.Errorf("Expected text/html, got %q\n", v)
+	}
+	if v := (&RequestResponsePair{}).HeaderValue("X-Token"); v != "" {
+		t.Errorf("Expected no value without a request, got %q\n", v)
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	html := &RequestResponsePair{
+		Response:     &http.Response{Header: http.Header{}},
+		ResponseBody: []byte("<html><body>hi</body></html>"),
+	}
+	if ct := html.DetectContentType(); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected sniffed HTML, got %s\n", ct)
+	}
+	html.Response.Header.Set("Content-Type", "application/xhtml+xml")
+	if ct := html.DetectContentType(); ct != "application/xhtml+xml" {
+		t.Errorf("Expected header to win, got %s\n", ct)
+	}
+	req := &RequestResponsePair{Request: &http.Request{Header: http.Header{"content-type": {"text/plain"}}}}
+	if ct := req.DetectContentType(); ct != "text/plain" {
+		t.Errorf("Expected request type without a response, got %s\n", ct)
+	}
+	empty := &RequestResponsePair{Response: &http.Response{}}
+	if ct := empty.DetectContentType(); ct != "" {
+		t.Errorf("Expected no type for an empty response, got %s\n", ct)
+	}
+}
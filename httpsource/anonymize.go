@@ -0,0 +1,76 @@
+package httpsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// AnonMode selects how AnonymizeIPs masks addresses.
+type AnonMode int
+
+const (
+	// AnonTruncate zeroes the last octet of IPv4 addresses and the last 80
+	// bits of IPv6 addresses
+	AnonTruncate AnonMode = iota
+	// AnonHash replaces addresses with a truncated SHA-256 hash, so that
+	// requests from the same client can still be grouped.  The hash is
+	// unsalted, so IPv4 addresses can be recovered by brute force.
+	AnonHash
+)
+
+// Headers that carry client addresses
+var clientIPHeaders = []string{"X-Forwarded-For", "X-Real-Ip"}
+
+// AnonymizeIPs returns a Clone of the pair with the client address in
+// ConnInfo.ClientAddr, Request.RemoteAddr and the X-Forwarded-For and
+// X-Real-IP headers masked.  Ports are kept.  Values that aren't IP
+// addresses are left as they are.
+func (p *RequestResponsePair) AnonymizeIPs(mode AnonMode) *RequestResponsePair {
+	c := p.Clone()
+	if c.ConnInfo.ClientAddr != "" {
+		c.ConnInfo.ClientAddr = anonymizeHostPort(c.ConnInfo.ClientAddr, mode)
+	}
+	if c.Request == nil {
+		return c
+	}
+	if c.Request.RemoteAddr != "" {
+		c.Request.RemoteAddr = anonymizeHostPort(c.Request.RemoteAddr, mode)
+	}
+	for _, name := range clientIPHeaders {
+		vals := c.Request.Header[name]
+		for i, val := range vals {
+			addrs := strings.Split(val, ",")
+			for j, addr := range addrs {
+				addrs[j] = anonymizeIP(strings.TrimSpace(addr), mode)
+			}
+			vals[i] = strings.Join(addrs, ", ")
+		}
+	}
+	return c
+}
+
+// anonymizeHostPort anonymizes the host of a host:port, or a bare host
+func anonymizeHostPort(addr string, mode AnonMode) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return anonymizeIP(addr, mode)
+	}
+	return net.JoinHostPort(anonymizeIP(host, mode), port)
+}
+
+func anonymizeIP(s string, mode AnonMode) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if mode == AnonHash {
+		sum := sha256.Sum256(ip.To16())
+		return hex.EncodeToString(sum[:8])
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
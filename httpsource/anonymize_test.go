@@ -0,0 +1,52 @@
+package httpsource
+
+import (
+	"testing"
+)
+
+func TestAnonymizeIPs(t *testing.T) {
+	pair := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n"+
+		"X-Forwarded-For: 203.0.113.45, 2001:db8:1234:5678:9abc::1\r\nX-Real-IP: unknown\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n")
+	pair.Request.RemoteAddr = "198.51.100.7:51234"
+
+	anon := pair.AnonymizeIPs(AnonTruncate)
+	if anon.Request.RemoteAddr != "198.51.100.0:51234" {
+		t.Errorf("Unexpected remote address: %s\n", anon.Request.RemoteAddr)
+	}
+	if xff := anon.Request.Header.Get("X-Forwarded-For"); xff != "203.0.113.0, 2001:db8:1234::" {
+		t.Errorf("Unexpected X-Forwarded-For: %s\n", xff)
+	}
+	if xri := anon.Request.Header.Get("X-Real-IP"); xri != "unknown" {
+		t.Errorf("Expected non-IP to be kept, got %s\n", xri)
+	}
+	if pair.Request.RemoteAddr != "198.51.100.7:51234" ||
+		pair.Request.Header.Get("X-Forwarded-For") != "203.0.113.45, 2001:db8:1234:5678:9abc::1" {
+		t.Error("Expected the original pair to be unchanged.\n")
+	}
+
+	hashed := pair.AnonymizeIPs(AnonHash)
+	again := pair.AnonymizeIPs(AnonHash)
+	if hashed.Request.RemoteAddr == pair.Request.RemoteAddr ||
+		hashed.Request.RemoteAddr != again.Request.RemoteAddr {
+		t.Errorf("Expected a stable hash, got %s and %s\n", hashed.Request.RemoteAddr, again.Request.RemoteAddr)
+	}
+}
+
+func TestAnonymizeConnInfo(t *testing.T) {
+	pair := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n")
+	pair.ConnInfo.ClientAddr = "198.51.100.7:51234"
+	pair.ConnInfo.ServerAddr = "192.0.2.1:80"
+	anon := pair.AnonymizeIPs(AnonTruncate)
+	if anon.ConnInfo.ClientAddr != "198.51.100.0:51234" || anon.ConnInfo.ServerAddr != "192.0.2.1:80" {
+		t.Errorf("Unexpected connection addresses: %+v\n", anon.ConnInfo)
+	}
+	// WebSocket frames and other pairs without a request are masked too
+	frame := &RequestResponsePair{ConnInfo: ConnInfo{ClientAddr: "[2001:db8:1234:5678:9abc::1]:443"}}
+	if addr := frame.AnonymizeIPs(AnonTruncate).ConnInfo.ClientAddr; addr != "[2001:db8:1234::]:443" {
+		t.Errorf("Unexpected address without a request: %s\n", addr)
+	}
+	if pair.ConnInfo.ClientAddr != "198.51.100.7:51234" {
+		t.Error("Expected the original pair to be unchanged.\n")
+	}
+}
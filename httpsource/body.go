@@ -0,0 +1,56 @@
+package httpsource
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// DecodeBody returns the response body with any Content-Encoding removed.
+// gzip, deflate and br are supported; an unsupported encoding is an error.
+// The stored ResponseBody is not modified.
+func (p *RequestResponsePair) DecodeBody() ([]byte, error) {
+	if p.Response == nil {
+		return p.ResponseBody, nil
+	}
+	body := p.ResponseBody
+	encodings := strings.Split(p.Response.Header.Get("Content-Encoding"), ",")
+	// Encodings are listed in the order applied, so undo them in reverse
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		if body, err = decodeContent(strings.TrimSpace(encodings[i]), body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func decodeContent(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// Should be zlib wrapped, but some servers send raw deflate
+		if r, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
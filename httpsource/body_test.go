@@ -0,0 +1,64 @@
+package httpsource
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func compressed(t *testing.T, newWriter func(io.Writer) io.WriteCloser, data string) []byte {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	_, err := w.Write([]byte(data))
+	fatalIfErr(t, err)
+	fatalIfErr(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	text := "It was the best of times, it was the worst of times"
+	rawFlate := func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(text)},
+		{"identity", []byte(text)},
+		{"gzip", compressed(t, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, text)},
+		{"deflate", compressed(t, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, text)},
+		{"deflate", compressed(t, rawFlate, text)},
+		{"br", compressed(t, func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }, text)},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: make(http.Header)}
+		if test.encoding != "" {
+			resp.Header.Set("Content-Encoding", test.encoding)
+		}
+		pair := &RequestResponsePair{Response: resp, ResponseBody: test.body}
+		decoded, err := pair.DecodeBody()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v\n", test.encoding, err)
+		} else if string(decoded) != text {
+			t.Errorf("%s: got %q\n", test.encoding, decoded)
+		}
+		if !bytes.Equal(pair.ResponseBody, test.body) {
+			t.Errorf("%s: stored body modified\n", test.encoding)
+		}
+	}
+}
+
+func TestDecodeBodyUnsupported(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"compress"}}}
+	pair := &RequestResponsePair{Response: resp, ResponseBody: []byte("x")}
+	if _, err := pair.DecodeBody(); err == nil {
+		t.Error("Expected error for unsupported encoding.\n")
+	}
+}
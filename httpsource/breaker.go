@@ -0,0 +1,90 @@
+package httpsource
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is the state of an output's circuit breaker.
+type BreakerState int32
+
+const (
+	// BreakerClosed delivers to the output as usual
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips the output until the cooldown has elapsed
+	BreakerOpen
+	// BreakerHalfOpen tries a single probe write to decide whether to close
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy configures the circuit breaker of AddBreakerOutput.
+type BreakerPolicy struct {
+	// Failures is the number of consecutive failed writes that opens the
+	// breaker, at least 1
+	Failures int
+	// Cooldown is how long the breaker stays open before a probe write
+	Cooldown time.Duration
+}
+
+// AddBreakerOutput adds an output guarded by a circuit breaker.  After
+// policy.Failures consecutive failed writes the mux skips the output,
+// counting each skipped pair only as BreakerSkipped, until policy.Cooldown
+// has elapsed.  Skipped pairs are not dropped writes, so they aren't logged
+// or passed to OnDrop or DeadLetters.  The next pair is then written as a
+// probe, which closes the breaker if it succeeds and reopens it otherwise.
+// The breaker state is reported in the output's stats, and each change of
+// state is logged.
+func (m *PairMux) AddBreakerOutput(name string, buf int, policy BreakerPolicy) (<-chan *RequestResponsePair, error) {
+	if policy.Failures < 1 {
+		policy.Failures = 1
+	}
+	// Writes to one output never overlap, so only the state, which Stats
+	// reads, needs to be atomic.
+	failures := 0
+	var openedAt time.Time
+	c := m.makeOutputChan(buf)
+	skip := func(m *PairMux, o output) bool {
+		state := (*int32)(&o.stats.Breaker)
+		if BreakerState(atomic.LoadInt32(state)) != BreakerOpen {
+			return false
+		}
+		if m.Clock.Now().Sub(openedAt) < policy.Cooldown {
+			atomic.AddUint64(&o.stats.BreakerSkipped, 1)
+			return true
+		}
+		atomic.StoreInt32(state, int32(BreakerHalfOpen))
+		m.log().Infof("PairMux half-opened circuit breaker for output %s.\n", o.name)
+		return false
+	}
+	writer := func(m *PairMux, o output, item *RequestResponsePair) bool {
+		state := (*int32)(&o.stats.Breaker)
+		if m.writer(m, o, item) {
+			failures = 0
+			if BreakerState(atomic.SwapInt32(state, int32(BreakerClosed))) != BreakerClosed {
+				m.log().Infof("PairMux closed circuit breaker for output %s.\n", o.name)
+			}
+			return true
+		}
+		failures++
+		if failures >= policy.Failures || BreakerState(atomic.LoadInt32(state)) == BreakerHalfOpen {
+			openedAt = m.Clock.Now()
+			atomic.StoreInt32(state, int32(BreakerOpen))
+			m.log().Warnf("PairMux opened circuit breaker for output %s.\n", o.name)
+		}
+		return false
+	}
+	err := m.addOutput(output{name: name, dst: c, skip: skip, writer: writer})
+	return c, err
+}
@@ -0,0 +1,57 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, 0)
+	l := &recordingLogger{}
+	m.Logger = l
+	drops := 0
+	m.OnDrop = func(string, *RequestResponsePair) {
+		drops++
+	}
+	clock := NewFakeClock(time.Unix(0, 0))
+	m.Clock = clock
+	out, err := m.AddBreakerOutput("out", 1, BreakerPolicy{Failures: 2, Cooldown: time.Minute})
+	fatalIfErr(t, err)
+	step := func() OutputStats {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+		return m.Stats()["out"]
+	}
+
+	// The first pair fills the buffer, the next two fail and open the breaker
+	step()
+	if s := step(); s.Breaker != BreakerClosed {
+		t.Errorf("Expected breaker closed after one failure, got %s\n", s.Breaker)
+	}
+	if s := step(); s.Breaker != BreakerOpen || s.Dropped != 2 {
+		t.Errorf("Expected breaker open after two failures, got %+v\n", s)
+	}
+	<-out
+	logged := len(l.lines)
+	if s := step(); s.BreakerSkipped != 1 || len(out) != 0 {
+		t.Errorf("Expected open breaker to skip the output, got %+v\n", s)
+	}
+	// Skips are not drops
+	if s := m.Stats()["out"]; s.Dropped != 2 || drops != 2 || len(l.lines) != logged {
+		t.Errorf("Expected skip not to count as a drop, got %+v, %d OnDrop calls and log %v\n", s, drops, l.lines[logged:])
+	}
+
+	clock.Advance(time.Minute)
+	if s := step(); s.Breaker != BreakerClosed || s.Written != 2 {
+		t.Errorf("Expected successful probe to close the breaker, got %+v\n", s)
+	}
+
+	// A failed probe reopens the breaker straight away
+	step()
+	step()
+	clock.Advance(time.Minute)
+	if s := step(); s.Breaker != BreakerOpen || s.BreakerSkipped != 1 {
+		t.Errorf("Expected failed probe to reopen the breaker, got %+v\n", s)
+	}
+}
@@ -0,0 +1,76 @@
+package httpsource
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+)
+
+// CacheHitHeader is added by DetectCacheHits to each response, holding "hit"
+// if the body matched the last one seen for the same URL, or "miss".
+const CacheHitHeader = "X-Httpwatch-Cache"
+
+// DefaultCacheHitEntries is how many URLs DetectCacheHits remembers.
+const DefaultCacheHitEntries = 1024
+
+type cacheEntry struct {
+	key  string
+	body [sha256.Size]byte
+}
+
+// DetectCacheHits annotates pairs using DetectCacheHitsSize with
+// DefaultCacheHitEntries.
+func DetectCacheHits(in <-chan *RequestResponsePair) <-chan *RequestResponsePair {
+	return DetectCacheHitsSize(in, DefaultCacheHitEntries)
+}
+
+// DetectCacheHitsSize forwards a Clone of each pair from in with a
+// CacheHitHeader on the response, marking whether its body is byte-identical
+// to the last response seen for the same method and normalized URL.  At most
+// entries URLs are remembered, forgetting the least recently seen.  Pairs
+// without a response are forwarded unchanged.  The returned channel is
+// closed once in is closed.
+func DetectCacheHitsSize(in <-chan *RequestResponsePair, entries int) <-chan *RequestResponsePair {
+	if entries < 1 {
+		entries = 1
+	}
+	out := make(chan *RequestResponsePair, cap(in))
+	go func() {
+		defer close(out)
+		lru := list.New()
+		seen := make(map[string]*list.Element)
+		for pair := range in {
+			if pair.Request == nil || pair.Response == nil {
+				out <- pair
+				continue
+			}
+			key := pair.Request.Method + " " + pair.NormalizedURL(NormalizeOptions{LowercaseHost: true, SortQuery: true})
+			sum := sha256.Sum256(pair.ResponseBody)
+			hit := false
+			if el, ok := seen[key]; ok {
+				entry := el.Value.(*cacheEntry)
+				hit = entry.body == sum
+				entry.body = sum
+				lru.MoveToFront(el)
+			} else {
+				seen[key] = lru.PushFront(&cacheEntry{key: key, body: sum})
+				if lru.Len() > entries {
+					oldest := lru.Back()
+					lru.Remove(oldest)
+					delete(seen, oldest.Value.(*cacheEntry).key)
+				}
+			}
+			c := pair.Clone()
+			if c.Response.Header == nil {
+				c.Response.Header = make(http.Header)
+			}
+			if hit {
+				c.Response.Header.Set(CacheHitHeader, "hit")
+			} else {
+				c.Response.Header.Set(CacheHitHeader, "miss")
+			}
+			out <- c
+		}
+	}()
+	return out
+}
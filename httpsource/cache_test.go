@@ -0,0 +1,56 @@
+package httpsource
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func cachePair(method, rawURL, body string) *RequestResponsePair {
+	u, _ := url.Parse(rawURL)
+	return &RequestResponsePair{
+		Request:      &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}},
+		Response:     &http.Response{StatusCode: 200, Header: http.Header{}},
+		ResponseBody: []byte(body),
+	}
+}
+
+func TestDetectCacheHits(t *testing.T) {
+	in := make(chan *RequestResponsePair, 10)
+	in <- cachePair("GET", "http://example.com/a?x=1&y=2", "one")
+	in <- cachePair("GET", "http://EXAMPLE.com/a?y=2&x=1", "one")
+	in <- cachePair("POST", "http://example.com/a?x=1&y=2", "one")
+	in <- cachePair("GET", "http://example.com/a?x=1&y=2", "two")
+	in <- &RequestResponsePair{Request: &http.Request{Method: "GET"}}
+	close(in)
+	expected := []string{"miss", "hit", "miss", "miss", ""}
+	i := 0
+	for pair := range DetectCacheHits(in) {
+		got := ""
+		if pair.Response != nil {
+			got = pair.Response.Header.Get(CacheHitHeader)
+		}
+		if i < len(expected) && got != expected[i] {
+			t.Errorf("Pair %d: expected %q, got %q\n", i, expected[i], got)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Expected %d pairs, got %d\n", len(expected), i)
+	}
+}
+
+func TestDetectCacheHitsEviction(t *testing.T) {
+	in := make(chan *RequestResponsePair, 10)
+	in <- cachePair("GET", "http://example.com/a", "body")
+	in <- cachePair("GET", "http://example.com/b", "body")
+	in <- cachePair("GET", "http://example.com/a", "body")
+	close(in)
+	var got []string
+	for pair := range DetectCacheHitsSize(in, 1) {
+		got = append(got, pair.Response.Header.Get(CacheHitHeader))
+	}
+	if len(got) != 3 || got[2] != "miss" {
+		t.Errorf("Expected /a to be evicted, got %v\n", got)
+	}
+}
@@ -0,0 +1,82 @@
+package httpsource
+
+import (
+	"sort"
+	"time"
+)
+
+// coalesced is the first pair seen for a key and how many have shared it
+type coalesced struct {
+	pair  *RequestResponsePair
+	count int
+	first time.Time
+}
+
+// Coalesce collapses pairs from in that share keyFn(pair) within window of
+// each other, such as a client repeating the same request.  The first pair
+// for a key stands for the group: window after it arrived, a Clone of it is
+// emitted with RepeatCount set to the number of pairs in the group, then
+// the key starts afresh.  A key that goes idle is therefore emitted within
+// window, and one that keeps repeating once per window.  Pairs with an
+// empty key, and every pair if window <= 0, are passed through as they are.
+// When in is closed, the remaining groups are emitted, oldest first, and
+// the returned channel is closed.
+func Coalesce(in <-chan *RequestResponsePair, window time.Duration, keyFn func(*RequestResponsePair) string) <-chan *RequestResponsePair {
+	out := make(chan *RequestResponsePair, cap(in))
+	if window <= 0 {
+		go func() {
+			defer close(out)
+			for pair := range in {
+				out <- pair
+			}
+		}()
+		return out
+	}
+	go func() {
+		defer close(out)
+		groups := make(map[string]*coalesced)
+		ticker := time.NewTicker(sweepInterval(window))
+		defer ticker.Stop()
+		for {
+			select {
+			case pair, ok := <-in:
+				if !ok {
+					emitCoalesced(groups, time.Now().Add(time.Hour), out)
+					return
+				}
+				key := keyFn(pair)
+				if key == "" {
+					out <- pair
+					continue
+				}
+				if g, ok := groups[key]; ok {
+					g.count++
+					continue
+				}
+				groups[key] = &coalesced{pair: pair, count: 1, first: time.Now()}
+			case now := <-ticker.C:
+				emitCoalesced(groups, now.Add(-window), out)
+			}
+		}
+	}()
+	return out
+}
+
+// emitCoalesced sends, oldest first, every group started before cutoff
+func emitCoalesced(groups map[string]*coalesced, cutoff time.Time, out chan<- *RequestResponsePair) {
+	var done []*coalesced
+	for key, g := range groups {
+		if g.first.Before(cutoff) {
+			done = append(done, g)
+			delete(groups, key)
+		}
+	}
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].first.Before(done[j].first)
+	})
+	for _, g := range done {
+		pair := g.pair.Clone()
+		pair.RepeatCount = g.count
+		out <- pair
+	}
+}
@@ -0,0 +1,79 @@
+package httpsource
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func pathKey(p *RequestResponsePair) string {
+	if p.Request == nil {
+		return ""
+	}
+	return p.Request.URL.Path
+}
+
+func TestCoalesce(t *testing.T) {
+	in := make(chan *RequestResponsePair, 5)
+	out := Coalesce(in, time.Hour, pathKey)
+	first := testPair(t, "GET /poll HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	in <- first
+	in <- testPair(t, "GET /poll HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	in <- testPair(t, "GET /other HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	in <- testPair(t, "GET /poll HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	unkeyed := &RequestResponsePair{}
+	in <- unkeyed
+	if p := <-out; p != unkeyed {
+		t.Errorf("Expected unkeyed pair to pass straight through, got %+v\n", p)
+	}
+	close(in)
+	poll, other := <-out, <-out
+	if pathKey(poll) != "/poll" || poll.RepeatCount != 3 {
+		t.Errorf("Expected /poll standing for 3 pairs, got %s with %d\n", pathKey(poll), poll.RepeatCount)
+	}
+	if poll == first || first.RepeatCount != 0 {
+		t.Error("Expected the representative to be a copy.\n")
+	}
+	if pathKey(other) != "/other" || other.RepeatCount != 1 {
+		t.Errorf("Expected /other standing for 1 pair, got %s with %d\n", pathKey(other), other.RepeatCount)
+	}
+	if _, ok := <-out; ok {
+		t.Error("Expected output to be closed.\n")
+	}
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	in := make(chan *RequestResponsePair)
+	out := Coalesce(in, 20*time.Millisecond, func(_ *RequestResponsePair) string { return "k" })
+	in <- &RequestResponsePair{Request: &http.Request{Method: "GET"}}
+	in <- &RequestResponsePair{Request: &http.Request{Method: "GET"}}
+	select {
+	case p := <-out:
+		if p.RepeatCount != 2 {
+			t.Errorf("Expected 2 coalesced pairs, got %d\n", p.RepeatCount)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the window to close.\n")
+	}
+	close(in)
+}
+
+func TestCoalesceShortWindow(t *testing.T) {
+	for _, window := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		in := make(chan *RequestResponsePair, 2)
+		out := Coalesce(in, window, pathKey)
+		in <- testPair(t, "GET /poll HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		in <- testPair(t, "GET /poll HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		close(in)
+		total := 0
+		for p := range out {
+			total += p.RepeatCount
+			if p.RepeatCount == 0 {
+				total++
+			}
+		}
+		if total != 2 {
+			t.Errorf("Window %v: expected 2 pairs accounted for, got %d\n", window, total)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec encodes pairs to a stream and decodes them back, so file sinks and
+// sources can use formats other than JSON.
+type Codec interface {
+	// Encode writes a single pair to w.
+	Encode(w io.Writer, p *RequestResponsePair) error
+	// Decode reads the next pair from r, returning io.EOF at the end of the
+	// stream.  Sources pass the same *bufio.Reader to every call.  If a
+	// record was read but could not be decoded, Decode returns a
+	// *RecordError and the next record may still be read.
+	Decode(r io.Reader) (*RequestResponsePair, error)
+}
+
+// RecordError reports a malformed record that a Codec has skipped over.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("Malformed record: %s", e.Err)
+}
+
+// JSONCodec reads and writes pairs as newline-delimited JSON, one pair per
+// line.  Lines longer than 64MB are skipped.
+type JSONCodec struct{}
+
+// Encode writes p as a line of JSON.
+func (JSONCodec) Encode(w io.Writer, p *RequestResponsePair) error {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
+
+// Decode reads the next non-blank line of JSON.  If r is not a
+// *bufio.Reader, Decode buffers it and so may read past the line.
+func (JSONCodec) Decode(r io.Reader) (*RequestResponsePair, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	for {
+		line, err := readLine(br, maxPairLine)
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		pair := &RequestResponsePair{}
+		if err := json.Unmarshal(line, pair); err != nil {
+			return nil, &RecordError{err}
+		}
+		return pair, nil
+	}
+}
+
+// readLine reads up to the next newline.  A line longer than max is
+// consumed and reported as a RecordError.  A final line without a newline
+// is returned as a line; io.EOF is only returned once there is no data.
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > max {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (len(line) > 0 || tooLong):
+			// Return the final unterminated line, then io.EOF next time
+		case err != nil:
+			return nil, err
+		}
+		if tooLong {
+			return nil, &RecordError{fmt.Errorf("Line longer than %d bytes", max)}
+		}
+		return line, nil
+	}
+}
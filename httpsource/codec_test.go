@@ -0,0 +1,54 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestJSONCodec(t *testing.T) {
+	pair := testPair(t, "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	var buf bytes.Buffer
+	codec := JSONCodec{}
+	fatalIfErr(t, codec.Encode(&buf, pair))
+	buf.WriteString("\nnot json\n")
+	fatalIfErr(t, codec.Encode(&buf, pair))
+
+	br := bufio.NewReader(&buf)
+	decoded, err := codec.Decode(br)
+	fatalIfErr(t, err)
+	if decoded.Request.URL.Path != "/a" || string(decoded.ResponseBody) != "ok" {
+		t.Errorf("Unexpected pair: %+v\n", decoded)
+	}
+	if _, err := codec.Decode(br); err == nil {
+		t.Error("Expected a RecordError for a malformed line.\n")
+	} else if _, ok := err.(*RecordError); !ok {
+		t.Errorf("Expected a RecordError, got %v\n", err)
+	}
+	if _, err := codec.Decode(br); err != nil {
+		t.Errorf("Expected decoding to continue after a bad line, got %v\n", err)
+	}
+	if _, err := codec.Decode(br); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v\n", err)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 40)+"\nshort\nlast"), 16)
+	if _, err := readLine(br, 32); err == nil {
+		t.Error("Expected error for a long line.\n")
+	}
+	for _, expected := range []string{"short\n", "last"} {
+		line, err := readLine(br, 32)
+		fatalIfErr(t, err)
+		if string(line) != expected {
+			t.Errorf("Expected %q, got %q\n", expected, line)
+		}
+	}
+	if _, err := readLine(br, 32); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v\n", err)
+	}
+}
@@ -0,0 +1,74 @@
+package httpsource
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// ConnInfo describes the connection a pair was exchanged over.  Fields the
+// source doesn't know are left zero; packet captures of plain HTTP only
+// have the addresses.
+type ConnInfo struct {
+	// ClientAddr and ServerAddr are host:port
+	ClientAddr string `json:"clientAddr,omitempty"`
+	ServerAddr string `json:"serverAddr,omitempty"`
+	// TLSVersion and CipherSuite are the crypto/tls constants, zero if the
+	// connection was not TLS
+	TLSVersion  uint16 `json:"tlsVersion,omitempty"`
+	CipherSuite uint16 `json:"cipherSuite,omitempty"`
+	// ALPN is the negotiated application protocol
+	ALPN string `json:"alpn,omitempty"`
+	// SNI is the server name sent by the client
+	SNI string `json:"sni,omitempty"`
+}
+
+// SetTLS fills in the TLS fields from the state of a TLS connection, for
+// sources that terminate TLS themselves.
+func (c *ConnInfo) SetTLS(state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	c.TLSVersion = state.Version
+	c.CipherSuite = state.CipherSuite
+	c.ALPN = state.NegotiatedProtocol
+	c.SNI = state.ServerName
+}
+
+// IsZero reports whether nothing is known about the connection.
+func (c ConnInfo) IsZero() bool {
+	return c == ConnInfo{}
+}
+
+// IsTLS reports whether the connection is known to have used TLS.
+func (c ConnInfo) IsTLS() bool {
+	return c.TLSVersion != 0
+}
+
+// TLSVersionName returns the name of the TLS version, such as "TLS 1.3", or
+// an empty string if the connection was not TLS.
+func (c ConnInfo) TLSVersionName() string {
+	switch c.TLSVersion {
+	case 0:
+		return ""
+	case 0x0300:
+		return "SSL 3.0"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", c.TLSVersion)
+}
+
+// CipherSuiteName returns the name of the cipher suite, or an empty string
+// if the connection was not TLS.
+func (c ConnInfo) CipherSuiteName() string {
+	if !c.IsTLS() {
+		return ""
+	}
+	return tls.CipherSuiteName(c.CipherSuite)
+}
@@ -0,0 +1,67 @@
+package httpsource
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConnInfo(t *testing.T) {
+	var c ConnInfo
+	if !c.IsZero() || c.IsTLS() || c.TLSVersionName() != "" || c.CipherSuiteName() != "" {
+		t.Errorf("Expected empty ConnInfo: %+v\n", c)
+	}
+	c.SetTLS(&tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "h2",
+		ServerName:         "example.com",
+	})
+	if !c.IsTLS() || c.TLSVersionName() != "TLS 1.3" || c.ALPN != "h2" || c.SNI != "example.com" {
+		t.Errorf("Unexpected TLS info: %+v\n", c)
+	}
+	if name := c.CipherSuiteName(); name != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("Unexpected cipher suite: %s\n", name)
+	}
+}
+
+func TestConnInfoSerialization(t *testing.T) {
+	pair := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 204 No Content\r\n\r\n")
+	buf, err := json.Marshal(pair)
+	fatalIfErr(t, err)
+	if strings.Contains(string(buf), `"conn"`) {
+		t.Errorf("Expected no conn info for an unknown connection: %s\n", buf)
+	}
+	entry, err := pair.ToHAREntry()
+	fatalIfErr(t, err)
+	if entry.ServerIPAddress != "" || entry.TLS != nil {
+		t.Errorf("Expected no connection fields in HAR: %+v\n", entry)
+	}
+
+	pair.ConnInfo = ConnInfo{
+		ClientAddr:  "10.0.0.1:51234",
+		ServerAddr:  "10.0.0.2:443",
+		TLSVersion:  tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+	buf, err = json.Marshal(pair)
+	fatalIfErr(t, err)
+	decoded := &RequestResponsePair{}
+	fatalIfErr(t, json.Unmarshal(buf, decoded))
+	if decoded.ConnInfo != pair.ConnInfo {
+		t.Errorf("Conn info not round tripped: %+v\n", decoded.ConnInfo)
+	}
+	if c := pair.Clone(); c.ConnInfo != pair.ConnInfo {
+		t.Errorf("Conn info not cloned: %+v\n", c.ConnInfo)
+	}
+	entry, err = pair.ToHAREntry()
+	fatalIfErr(t, err)
+	if entry.ServerIPAddress != "10.0.0.2" || entry.Connection != "10.0.0.1:51234" {
+		t.Errorf("Unexpected HAR connection fields: %s %s\n", entry.ServerIPAddress, entry.Connection)
+	}
+	if entry.TLS == nil || entry.TLS.Version != "TLS 1.2" {
+		t.Errorf("Unexpected HAR TLS: %+v\n", entry.TLS)
+	}
+}
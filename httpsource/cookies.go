@@ -0,0 +1,133 @@
+package httpsource
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jarCookie is a cookie in a host's jar and when it expires, if ever
+type jarCookie struct {
+	cookie  http.Cookie
+	expires time.Time
+}
+
+// cookieJar holds the cookies of each host, by name
+type cookieJar struct {
+	lock  sync.Mutex
+	hosts map[string]map[string]jarCookie
+	// latest is the time of the latest pair tracked
+	latest time.Time
+}
+
+// TrackCookies follows the cookies of each host through the pairs from in,
+// annotating a Clone of each pair with JarCookies, the cookies in effect
+// when its request was sent.  Cookies sent by a request are added to the
+// host's jar, then those set by its response are added, replaced or, once
+// expired, removed.  Jars are kept per host name, ignoring the Domain and
+// Path attributes, and expiry is judged by each pair's Timestamp, or the
+// current time if it has none.  Pairs without a request pass through
+// unchanged.  snapshot returns a copy of every host's cookies, sorted by
+// name, leaving out those expired as of the latest pair.  The returned
+// channel is closed once in is.
+func TrackCookies(in <-chan *RequestResponsePair) (<-chan *RequestResponsePair, func() map[string][]*http.Cookie) {
+	out := make(chan *RequestResponsePair, cap(in))
+	jar := &cookieJar{hosts: make(map[string]map[string]jarCookie)}
+	go func() {
+		defer close(out)
+		for pair := range in {
+			if pair.Request == nil {
+				out <- pair
+				continue
+			}
+			out <- jar.track(pair)
+		}
+	}()
+	return out, jar.snapshot
+}
+
+// track updates the jar from a pair, returning an annotated copy of it
+func (j *cookieJar) track(pair *RequestResponsePair) *RequestResponsePair {
+	now := pair.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	host := cookieHost(pair.Request)
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if now.After(j.latest) {
+		j.latest = now
+	}
+	cookies := j.hosts[host]
+	if cookies == nil {
+		cookies = make(map[string]jarCookie)
+		j.hosts[host] = cookies
+	}
+	for _, c := range pair.Request.Cookies() {
+		// The request doesn't say when it expires, so keep any known expiry
+		jc := cookies[c.Name]
+		jc.cookie.Name = c.Name
+		jc.cookie.Value = c.Value
+		cookies[c.Name] = jc
+	}
+	c := pair.Clone()
+	c.JarCookies = jarContents(cookies, now)
+	if pair.Response != nil {
+		for _, set := range pair.Response.Cookies() {
+			expires := set.Expires
+			if set.MaxAge > 0 {
+				expires = now.Add(time.Duration(set.MaxAge) * time.Second)
+			}
+			if set.MaxAge < 0 || (!expires.IsZero() && !expires.After(now)) {
+				delete(cookies, set.Name)
+				continue
+			}
+			cookies[set.Name] = jarCookie{cookie: *set, expires: expires}
+		}
+	}
+	return c
+}
+
+func (j *cookieJar) snapshot() map[string][]*http.Cookie {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	snap := make(map[string][]*http.Cookie, len(j.hosts))
+	for host, cookies := range j.hosts {
+		if list := jarContents(cookies, j.latest); len(list) > 0 {
+			snap[host] = list
+		}
+	}
+	return snap
+}
+
+// jarContents returns copies of the cookies not expired at now, sorted by
+// name
+func jarContents(cookies map[string]jarCookie, now time.Time) []*http.Cookie {
+	var list []*http.Cookie
+	for _, jc := range cookies {
+		if !jc.expires.IsZero() && !jc.expires.After(now) {
+			continue
+		}
+		c := jc.cookie
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// cookieHost is the lowercased host name a request was sent to
+func cookieHost(req *http.Request) string {
+	host := req.Host
+	if host == "" && req.URL != nil {
+		host = req.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,67 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func cookieNames(p *RequestResponsePair) map[string]string {
+	names := make(map[string]string)
+	for _, c := range p.JarCookies {
+		names[c.Name] = c.Value
+	}
+	return names
+}
+
+func TestTrackCookies(t *testing.T) {
+	start := time.Unix(1000, 0)
+	login := testPair(t, "POST /login HTTP/1.1\r\nHost: example.com\r\nCookie: pref=dark\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nSet-Cookie: sid=abc; Path=/; HttpOnly\r\n"+
+			"Set-Cookie: short=1; Max-Age=60\r\nContent-Length: 0\r\n\r\n")
+	login.Timestamp = start
+	page := testPair(t, "GET /home HTTP/1.1\r\nHost: example.com:8080\r\nCookie: sid=abc\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	page.Timestamp = start.Add(2 * time.Minute)
+	logout := testPair(t, "GET /logout HTTP/1.1\r\nHost: EXAMPLE.com\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nSet-Cookie: sid=; Max-Age=0\r\nContent-Length: 0\r\n\r\n")
+	logout.Timestamp = start.Add(3 * time.Minute)
+	other := testPair(t, "GET / HTTP/1.1\r\nHost: other.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	in := make(chan *RequestResponsePair, 5)
+	out, snapshot := TrackCookies(in)
+	unparsed := &RequestResponsePair{}
+	for _, p := range []*RequestResponsePair{login, page, logout, other, unparsed} {
+		in <- p
+	}
+	close(in)
+	var got []*RequestResponsePair
+	for p := range out {
+		got = append(got, p)
+	}
+	if len(got) != 5 || got[4] != unparsed {
+		t.Fatalf("Expected 5 pairs, with the unparsed one untouched, got %d\n", len(got))
+	}
+	if c := cookieNames(got[0]); len(c) != 1 || c["pref"] != "dark" {
+		t.Errorf("Expected only the sent cookie on the first request, got %v\n", c)
+	}
+	if login.JarCookies != nil {
+		t.Error("Expected the input pair to be left alone.\n")
+	}
+	// short has expired by the time of the second request
+	if c := cookieNames(got[1]); len(c) != 2 || c["sid"] != "abc" || c["pref"] != "dark" {
+		t.Errorf("Unexpected cookies on the second request: %v\n", c)
+	}
+	if c := cookieNames(got[2]); c["sid"] != "abc" {
+		t.Errorf("Expected sid still in effect for logout, got %v\n", c)
+	}
+	if len(got[3].JarCookies) != 0 {
+		t.Errorf("Expected hosts to have separate jars, got %v\n", got[3].JarCookies)
+	}
+	jar := snapshot()
+	if cookies := jar["example.com"]; len(cookies) != 1 || cookies[0].Name != "pref" {
+		t.Errorf("Unexpected final jar: %v\n", cookies)
+	}
+	if _, ok := jar["other.com"]; ok {
+		t.Error("Expected hosts without cookies to be left out.\n")
+	}
+}
@@ -0,0 +1,50 @@
+package httpsource
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Headers curl sets itself, or which only apply to a single hop
+var curlSkipHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// CurlCommand returns a curl command line reproducing the request, quoted
+// for a POSIX shell.  Returns an empty string if the pair has no request.
+func (p *RequestResponsePair) CurlCommand() string {
+	if p.Request == nil {
+		return ""
+	}
+	args := []string{"curl", "-X", shellQuote(p.Request.Method)}
+	names := make([]string, 0, len(p.Request.Header))
+	for name := range p.Request.Header {
+		if !curlSkipHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, val := range p.Request.Header[name] {
+			args = append(args, "-H", shellQuote(name+": "+val))
+		}
+	}
+	if len(p.RequestBody) > 0 {
+		args = append(args, "--data-binary", shellQuote(string(p.RequestBody)))
+	}
+	args = append(args, shellQuote(absoluteURL(p.Request).String()))
+	return strings.Join(args, " ")
+}
+
+// shellQuote single quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,20 @@
+package httpsource
+
+import (
+	"testing"
+)
+
+func TestCurlCommand(t *testing.T) {
+	pair := testPair(t,
+		"POST /api?q=1 HTTP/1.1\r\nHost: example.com\r\nContent-Length: 8\r\n"+
+			"Connection: keep-alive\r\nX-Quote: it's\r\nAccept: */*\r\n\r\n{\"a\":1}\n",
+		"HTTP/1.1 204 No Content\r\n\r\n")
+	expected := `curl -X 'POST' -H 'Accept: */*' -H 'X-Quote: it'\''s' ` +
+		`--data-binary '{"a":1}` + "\n" + `' 'http://example.com/api?q=1'`
+	if cmd := pair.CurlCommand(); cmd != expected {
+		t.Errorf("Unexpected curl command:\n%s\nexpected:\n%s\n", cmd, expected)
+	}
+	if cmd := (&RequestResponsePair{}).CurlCommand(); cmd != "" {
+		t.Errorf("Expected no command without a request, got %s\n", cmd)
+	}
+}
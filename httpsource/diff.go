@@ -0,0 +1,255 @@
+package httpsource
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// VolatileHeaders are headers that usually differ between otherwise
+// identical responses, for use with DiffOptions.IgnoreHeaders.
+var VolatileHeaders = []string{"Age", "Date", "Expires", "Last-Modified", "Set-Cookie", "X-Request-Id"}
+
+// maxDiffCells bounds the line diff table; larger bodies are reported as
+// replaced outright.
+const maxDiffCells = 1 << 20
+
+// DiffOptions controls what Diff compares.
+type DiffOptions struct {
+	// IgnoreHeaders are left out of the header comparison
+	IgnoreHeaders []string
+}
+
+// HeaderDiff is a header whose values differ between two pairs.  A is nil
+// for an added header and B is nil for a removed one.
+type HeaderDiff struct {
+	Name string
+	A, B []string
+}
+
+// HeaderChanges lists the differences between two sets of headers, each
+// sorted by name.
+type HeaderChanges struct {
+	Added   []HeaderDiff
+	Removed []HeaderDiff
+	Changed []HeaderDiff
+}
+
+// Empty reports whether the headers were the same.
+func (c HeaderChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// PairDiff describes how one pair differs from another.  The body diffs are
+// line based, with each line prefixed by "-" (only in a), "+" (only in b) or
+// " " (in both), and are nil when the bodies are equal.  Bodies that are not
+// valid UTF-8 are not diffed line by line.
+type PairDiff struct {
+	StatusA, StatusB int
+	RequestHeaders   HeaderChanges
+	ResponseHeaders  HeaderChanges
+	RequestBody      []string
+	ResponseBody     []string
+}
+
+// Diff compares two pairs using the zero DiffOptions.
+func Diff(a, b *RequestResponsePair) PairDiff {
+	return DiffWithOptions(a, b, DiffOptions{})
+}
+
+// DiffWithOptions compares the status codes, headers and bodies of two
+// pairs.  A missing response has status 0 and no headers.
+func DiffWithOptions(a, b *RequestResponsePair, opts DiffOptions) PairDiff {
+	ignore := make(map[string]bool, len(opts.IgnoreHeaders))
+	for _, name := range opts.IgnoreHeaders {
+		ignore[http.CanonicalHeaderKey(name)] = true
+	}
+	var d PairDiff
+	var reqA, reqB, respA, respB http.Header
+	if a.Request != nil {
+		reqA = a.Request.Header
+	}
+	if b.Request != nil {
+		reqB = b.Request.Header
+	}
+	if a.Response != nil {
+		d.StatusA = a.Response.StatusCode
+		respA = a.Response.Header
+	}
+	if b.Response != nil {
+		d.StatusB = b.Response.StatusCode
+		respB = b.Response.Header
+	}
+	d.RequestHeaders = diffHeaders(reqA, reqB, ignore)
+	d.ResponseHeaders = diffHeaders(respA, respB, ignore)
+	d.RequestBody = diffBodies(a.RequestBody, b.RequestBody)
+	d.ResponseBody = diffBodies(a.ResponseBody, b.ResponseBody)
+	return d
+}
+
+// Empty reports whether no differences were found.
+func (d PairDiff) Empty() bool {
+	return d.StatusA == d.StatusB && d.RequestHeaders.Empty() &&
+		d.ResponseHeaders.Empty() && d.RequestBody == nil && d.ResponseBody == nil
+}
+
+// String renders the differences for display, or "no differences".
+func (d PairDiff) String() string {
+	if d.Empty() {
+		return "no differences\n"
+	}
+	var buf bytes.Buffer
+	if d.StatusA != d.StatusB {
+		fmt.Fprintf(&buf, "status: %d -> %d\n", d.StatusA, d.StatusB)
+	}
+	writeHeaderChanges(&buf, "request", d.RequestHeaders)
+	writeHeaderChanges(&buf, "response", d.ResponseHeaders)
+	writeBodyDiff(&buf, "request", d.RequestBody)
+	writeBodyDiff(&buf, "response", d.ResponseBody)
+	return buf.String()
+}
+
+func writeHeaderChanges(buf *bytes.Buffer, kind string, c HeaderChanges) {
+	if c.Empty() {
+		return
+	}
+	fmt.Fprintf(buf, "%s headers:\n", kind)
+	for _, h := range c.Added {
+		fmt.Fprintf(buf, "+ %s: %s\n", h.Name, strings.Join(h.B, ", "))
+	}
+	for _, h := range c.Removed {
+		fmt.Fprintf(buf, "- %s: %s\n", h.Name, strings.Join(h.A, ", "))
+	}
+	for _, h := range c.Changed {
+		fmt.Fprintf(buf, "~ %s: %s -> %s\n", h.Name, strings.Join(h.A, ", "), strings.Join(h.B, ", "))
+	}
+}
+
+func writeBodyDiff(buf *bytes.Buffer, kind string, lines []string) {
+	if lines == nil {
+		return
+	}
+	fmt.Fprintf(buf, "%s body:\n", kind)
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+}
+
+func diffHeaders(a, b http.Header, ignore map[string]bool) HeaderChanges {
+	ca := canonicalHeader(a)
+	cb := canonicalHeader(b)
+	var c HeaderChanges
+	for name, va := range ca {
+		if ignore[name] {
+			continue
+		}
+		vb, ok := cb[name]
+		if !ok {
+			c.Removed = append(c.Removed, HeaderDiff{Name: name, A: va})
+		} else if !equalValues(va, vb) {
+			c.Changed = append(c.Changed, HeaderDiff{Name: name, A: va, B: vb})
+		}
+	}
+	for name, vb := range cb {
+		if _, ok := ca[name]; !ok && !ignore[name] {
+			c.Added = append(c.Added, HeaderDiff{Name: name, B: vb})
+		}
+	}
+	sortHeaderDiffs(c.Added)
+	sortHeaderDiffs(c.Removed)
+	sortHeaderDiffs(c.Changed)
+	return c
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortHeaderDiffs(diffs []HeaderDiff) {
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+}
+
+// diffBodies returns a line diff of two bodies, or nil if they are equal
+func diffBodies(a, b []byte) []string {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if !utf8.Valid(a) || !utf8.Valid(b) {
+		return []string{fmt.Sprintf("~ binary bodies differ (%d -> %d bytes)", len(a), len(b))}
+	}
+	return diffLines(splitLines(a), splitLines(b))
+}
+
+func splitLines(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+}
+
+// diffLines builds a diff from the longest common subsequence of lines
+func diffLines(a, b []string) []string {
+	if len(a)*len(b) > maxDiffCells {
+		diff := make([]string, 0, len(a)+len(b))
+		for _, line := range a {
+			diff = append(diff, "-"+line)
+		}
+		for _, line := range b {
+			diff = append(diff, "+"+line)
+		}
+		return diff
+	}
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
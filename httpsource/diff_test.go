@@ -0,0 +1,75 @@
+package httpsource
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func diffPair(status int, header http.Header, body string) *RequestResponsePair {
+	return &RequestResponsePair{
+		Request:      &http.Request{Method: "GET", Header: http.Header{}},
+		Response:     &http.Response{StatusCode: status, Header: header},
+		ResponseBody: []byte(body),
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := diffPair(200, http.Header{
+		"Date":         {"Mon"},
+		"Content-Type": {"text/plain"},
+		"X-Old":        {"1"},
+	}, "one\ntwo\nthree\n")
+	b := diffPair(404, http.Header{
+		"Date":         {"Tue"},
+		"Content-Type": {"text/html"},
+		"X-New":        {"2"},
+	}, "one\nthree\nfour\n")
+	d := DiffWithOptions(a, b, DiffOptions{IgnoreHeaders: VolatileHeaders})
+	if d.StatusA != 200 || d.StatusB != 404 {
+		t.Errorf("Unexpected status: %d %d\n", d.StatusA, d.StatusB)
+	}
+	h := d.ResponseHeaders
+	if len(h.Added) != 1 || h.Added[0].Name != "X-New" {
+		t.Errorf("Unexpected added headers: %v\n", h.Added)
+	}
+	if len(h.Removed) != 1 || h.Removed[0].Name != "X-Old" {
+		t.Errorf("Unexpected removed headers: %v\n", h.Removed)
+	}
+	if len(h.Changed) != 1 || h.Changed[0].Name != "Content-Type" {
+		t.Errorf("Unexpected changed headers: %v\n", h.Changed)
+	}
+	expected := []string{" one", "-two", " three", "+four"}
+	if strings.Join(d.ResponseBody, "|") != strings.Join(expected, "|") {
+		t.Errorf("Unexpected body diff: %q\n", d.ResponseBody)
+	}
+	if d.RequestBody != nil || !d.RequestHeaders.Empty() {
+		t.Errorf("Expected no request differences: %+v\n", d)
+	}
+	s := d.String()
+	for _, want := range []string{"status: 200 -> 404", "~ Content-Type: text/plain -> text/html", "+four"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Expected %q in:\n%s\n", want, s)
+		}
+	}
+
+	if len(Diff(a, b).ResponseHeaders.Changed) != 2 {
+		t.Error("Expected Date to be compared by default.\n")
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	a := diffPair(200, http.Header{"Date": {"Mon"}}, "same")
+	b := diffPair(200, http.Header{"date": {"Tue"}}, "same")
+	d := DiffWithOptions(a, b, DiffOptions{IgnoreHeaders: []string{"date"}})
+	if !d.Empty() || d.String() != "no differences\n" {
+		t.Errorf("Expected no differences, got %s\n", d)
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	d := Diff(diffPair(200, nil, "\xff"), diffPair(200, nil, "\xfe\xfe"))
+	if len(d.ResponseBody) != 1 || !strings.Contains(d.ResponseBody[0], "binary") {
+		t.Errorf("Unexpected binary diff: %v\n", d.ResponseBody)
+	}
+}
@@ -0,0 +1,41 @@
+package httpsource
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarLock serializes the check-then-publish in PublishExpvar
+var expvarLock sync.Mutex
+
+// expvarOutput is the JSON form of one output under PublishExpvar
+type expvarOutput struct {
+	OutputStats
+	Len int
+	Cap int
+}
+
+// PublishExpvar publishes the per-output stats and depths of the mux as an
+// expvar under name, so they appear under /debug/vars.  It returns an error
+// if name is already published.
+func (m *PairMux) PublishExpvar(name string) error {
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("Expvar %s is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(m.expvarOutputs))
+	return nil
+}
+
+func (m *PairMux) expvarOutputs() interface{} {
+	stats := m.Stats()
+	depths := m.OutputDepths()
+	outputs := make(map[string]expvarOutput, len(stats))
+	for name, s := range stats {
+		d := depths[name]
+		outputs[name] = expvarOutput{OutputStats: s, Len: d.Len, Cap: d.Cap}
+	}
+	return outputs
+}
@@ -0,0 +1,26 @@
+package httpsource
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.AddOutput("out", 2)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+
+	fatalIfErr(t, m.PublishExpvar("test_mux"))
+	if err := m.PublishExpvar("test_mux"); err == nil {
+		t.Error("Expected error publishing the same name twice.\n")
+	}
+	var outputs map[string]expvarOutput
+	fatalIfErr(t, json.Unmarshal([]byte(expvar.Get("test_mux").String()), &outputs))
+	out := outputs["out"]
+	if out.Written != 1 || out.Len != 1 || out.Cap != 2 {
+		t.Errorf("Unexpected expvar output: %+v\n", out)
+	}
+}
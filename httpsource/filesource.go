@@ -0,0 +1,89 @@
+package httpsource
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+// Longest line NewFileSource will accept, bodies included
+const maxPairLine = 64 * 1024 * 1024
+
+// sleep pauses replay; tests may replace it to avoid real delays
+var sleep = time.Sleep
+
+// NewFileSource reads newline-delimited JSON pairs, as written by the json
+// output, from r.  The returned channel is closed at EOF.  Malformed lines
+// are skipped, and the number skipped is logged when done.
+func NewFileSource(r io.Reader) (<-chan *RequestResponsePair, error) {
+	return NewFileSourceWithCodec(r, JSONCodec{})
+}
+
+// NewFileSourceWithCodec reads pairs encoded with codec from r.  The
+// returned channel is closed at EOF or on a read error.  Malformed records
+// are skipped, and the number skipped is logged when done.
+func NewFileSourceWithCodec(r io.Reader, codec Codec) (<-chan *RequestResponsePair, error) {
+	if r == nil {
+		return nil, errors.New("NewFileSource needs a reader")
+	}
+	pairs := make(chan *RequestResponsePair, 100)
+	go func() {
+		defer close(pairs)
+		br := bufio.NewReader(r)
+		skipped := 0
+		for {
+			pair, err := codec.Decode(br)
+			if _, ok := err.(*RecordError); ok {
+				skipped++
+				continue
+			}
+			if err != nil {
+				if err != io.EOF {
+					logger.Printf("Error reading pairs: %v\n", err)
+				}
+				break
+			}
+			// Files written before pairs had IDs get them as they are read
+			if pair.ID == "" && pair.Request != nil {
+				pair.ID = requestID(pair.Request)
+			}
+			pairs <- pair
+		}
+		if skipped > 0 {
+			logger.Printf("Skipped %d malformed pairs.\n", skipped)
+		}
+	}()
+	return pairs, nil
+}
+
+// NewTimedFileSource is like NewFileSource, but replays pairs at the pace
+// they were captured, using the gaps between their Timestamps scaled by
+// speed (2.0 is twice as fast).  A speed of 0, or pairs without timestamps,
+// are emitted immediately.  Delays are measured with time.Now and waited
+// out with the package's sleep function.
+func NewTimedFileSource(r io.Reader, speed float64) (<-chan *RequestResponsePair, error) {
+	src, err := NewFileSource(r)
+	if err != nil || speed <= 0 {
+		return src, err
+	}
+	pairs := make(chan *RequestResponsePair, cap(src))
+	go func() {
+		defer close(pairs)
+		var first, start time.Time
+		for pair := range src {
+			if !pair.Timestamp.IsZero() {
+				if first.IsZero() {
+					first, start = pair.Timestamp, time.Now()
+				}
+				// Schedule relative to the first pair so delays don't accumulate
+				offset := time.Duration(float64(pair.Timestamp.Sub(first)) / speed)
+				if wait := offset - time.Since(start); wait > 0 {
+					sleep(wait)
+				}
+			}
+			pairs <- pair
+		}
+	}()
+	return pairs, nil
+}
@@ -0,0 +1,47 @@
+package httpsource
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	input := `{"version":1,"request":{"method":"GET","url":"/a"}}
+not json
+
+{"version":1,"request":{"method":"POST","url":"/b"}}
+`
+	pairs, err := NewFileSource(strings.NewReader(input))
+	fatalIfErr(t, err)
+	var methods []string
+	for pair := range pairs {
+		methods = append(methods, pair.Request.Method)
+	}
+	if len(methods) != 2 || methods[0] != "GET" || methods[1] != "POST" {
+		t.Errorf("Expected GET, POST, got %v\n", methods)
+	}
+}
+
+func TestTimedFileSource(t *testing.T) {
+	input := `{"version":1,"timestamp":"2020-01-01T00:00:00Z","request":{"method":"GET","url":"/a"}}
+{"version":1,"timestamp":"2020-01-01T00:00:10Z","request":{"method":"GET","url":"/b"}}
+{"version":1,"request":{"method":"GET","url":"/c"}}
+`
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	pairs, err := NewTimedFileSource(strings.NewReader(input), 2.0)
+	fatalIfErr(t, err)
+	count := 0
+	for _ = range pairs {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 pairs, got %d.\n", count)
+	}
+	if len(slept) != 1 || slept[0] > 5*time.Second || slept[0] < 4*time.Second {
+		t.Errorf("Expected a single ~5s sleep, got %v\n", slept)
+	}
+}
@@ -0,0 +1,135 @@
+package httpsource
+
+import (
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilterFunc reports whether a pair should be accepted, for use with
+// AddFilteredOutput.  Filters run on every pair, so should be cheap.
+type FilterFunc func(*RequestResponsePair) bool
+
+// And accepts pairs accepted by all of filters.
+func And(filters ...FilterFunc) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		for _, f := range filters {
+			if !f(p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or accepts pairs accepted by any of filters.
+func Or(filters ...FilterFunc) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		for _, f := range filters {
+			if f(p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not accepts pairs rejected by filter.
+func Not(filter FilterFunc) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return !filter(p)
+	}
+}
+
+// MethodIs accepts requests using method.
+func MethodIs(method string) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.Request != nil && p.Request.Method == method
+	}
+}
+
+// StatusInRange accepts responses with a status code from lo to hi
+// inclusive.
+func StatusInRange(lo, hi int) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.Response != nil && p.Response.StatusCode >= lo && p.Response.StatusCode <= hi
+	}
+}
+
+// HostMatches accepts requests whose Host matches re.
+func HostMatches(re *regexp.Regexp) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.Request != nil && re.MatchString(p.Request.Host)
+	}
+}
+
+// URLMatches accepts requests whose full URL, including scheme and host,
+// matches re.
+func URLMatches(re *regexp.Regexp) FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.Request != nil && p.Request.URL != nil && re.MatchString(absoluteURL(p.Request).String())
+	}
+}
+
+// ContentTypeMatches accepts pairs whose response or request media type
+// matches pattern, a path.Match glob such as "application/*" or
+// "image/png".  Parameters such as charset are ignored, and a response
+// without a Content-Type header is matched on its sniffed type.  An invalid
+// pattern matches nothing.
+func ContentTypeMatches(pattern string) FilterFunc {
+	pattern = strings.ToLower(pattern)
+	matches := func(ct string) bool {
+		if ct == "" {
+			return false
+		}
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return false
+		}
+		ok, _ := path.Match(pattern, mediaType)
+		return ok
+	}
+	return func(p *RequestResponsePair) bool {
+		if p.Response != nil && matches(detectContentType(p.Response.Header, p.ResponseBody)) {
+			return true
+		}
+		return p.Request != nil && matches(headerValue(p.Request.Header, "Content-Type"))
+	}
+}
+
+// Dedup rejects a pair if a pair with the same keyFn(pair) was accepted
+// within window.  Duplicates don't extend the window, so a steady stream of
+// duplicates is let through once per window.  The filter is safe to share
+// between outputs.
+func Dedup(window time.Duration, keyFn func(*RequestResponsePair) string) FilterFunc {
+	return dedup(window, keyFn, RealClock{})
+}
+
+func dedup(window time.Duration, keyFn func(*RequestResponsePair) string, clock Clock) FilterFunc {
+	var lock sync.Mutex
+	seen := make(map[string]time.Time)
+	lastSweep := clock.Now()
+	return func(p *RequestResponsePair) bool {
+		key := keyFn(p)
+		now := clock.Now()
+		lock.Lock()
+		defer lock.Unlock()
+		if now.Sub(lastSweep) >= window {
+			// Forget expired keys so the map doesn't grow without bound
+			for k, t := range seen {
+				if now.Sub(t) >= window {
+					delete(seen, k)
+				}
+			}
+			lastSweep = now
+		}
+		if t, ok := seen[key]; ok && now.Sub(t) < window {
+			return false
+		}
+		seen[key] = now
+		return true
+	}
+}
@@ -0,0 +1,90 @@
+package httpsource
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFilters(t *testing.T) {
+	pair := testPair(t, "POST /api/users HTTP/1.1\r\nHost: api.example.com\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n")
+	yes := func(_ *RequestResponsePair) bool { return true }
+	no := Not(yes)
+	tests := []struct {
+		name     string
+		filter   FilterFunc
+		expected bool
+	}{
+		{"MethodIs POST", MethodIs("POST"), true},
+		{"MethodIs GET", MethodIs("GET"), false},
+		{"StatusInRange 5xx", StatusInRange(500, 599), true},
+		{"StatusInRange 2xx", StatusInRange(200, 299), false},
+		{"HostMatches", HostMatches(regexp.MustCompile(`^api\.`)), true},
+		{"URLMatches", URLMatches(regexp.MustCompile(`^http://api\.example\.com/api/`)), true},
+		{"And", And(yes, MethodIs("POST")), true},
+		{"And false", And(yes, no), false},
+		{"Or", Or(no, MethodIs("POST")), true},
+		{"Or false", Or(no, no), false},
+		{"Not", Not(MethodIs("GET")), true},
+	}
+	for _, test := range tests {
+		if v := test.filter(pair); v != test.expected {
+			t.Errorf("%s: expected %v, got %v\n", test.name, test.expected, v)
+		}
+	}
+
+	empty := &RequestResponsePair{}
+	for _, f := range []FilterFunc{MethodIs("GET"), StatusInRange(0, 999),
+		HostMatches(regexp.MustCompile("")), URLMatches(regexp.MustCompile(""))} {
+		if f(empty) {
+			t.Error("Expected filters to reject an empty pair.\n")
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	filter := dedup(time.Minute, func(p *RequestResponsePair) string {
+		return p.Request.URL.Path
+	}, clock)
+	a := testPair(t, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n")
+	b := testPair(t, "GET /b HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n")
+	if !filter(a) || !filter(b) {
+		t.Error("Expected first pairs to be accepted.\n")
+	}
+	clock.Advance(30 * time.Second)
+	if filter(a) {
+		t.Error("Expected duplicate within window to be rejected.\n")
+	}
+	clock.Advance(30 * time.Second)
+	if !filter(a) {
+		t.Error("Expected pair to be accepted once the window has passed.\n")
+	}
+}
+
+func TestContentTypeMatches(t *testing.T) {
+	jsonPair := testPair(t, "GET /data HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/json; charset=utf-8\r\nContent-Length: 2\r\n\r\n{}")
+	pngPair := testPair(t, "GET /logo HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\n\x89PNG\r\n\x1a\n")
+	upload := testPair(t, "POST /up HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/csv\r\nContent-Length: 3\r\n\r\na,b",
+		"HTTP/1.1 204 No Content\r\n\r\n")
+	tests := []struct {
+		pattern string
+		pair    *RequestResponsePair
+		want    bool
+	}{
+		{"application/*", jsonPair, true},
+		{"Application/JSON", jsonPair, true},
+		{"image/*", jsonPair, false},
+		{"image/*", pngPair, true},
+		{"text/csv", upload, true},
+		{"[", jsonPair, false},
+	}
+	for _, test := range tests {
+		if got := ContentTypeMatches(test.pattern)(test.pair); got != test.want {
+			t.Errorf("%s: expected %v, got %v\n", test.pattern, test.want, got)
+		}
+	}
+}
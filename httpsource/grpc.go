@@ -0,0 +1,115 @@
+package httpsource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// gRPC frames start with a compressed flag and a 4-byte message length
+const grpcFrameHeader = 5
+
+// GRPCCompressionError reports compressed gRPC messages that couldn't be
+// inflated, because the encoding isn't supported or the data is corrupt.
+// Those messages are returned still compressed.
+type GRPCCompressionError struct {
+	// Encoding is the grpc-encoding of the messages
+	Encoding string
+	// Messages are the indexes of the messages left compressed
+	Messages []int
+}
+
+func (e *GRPCCompressionError) Error() string {
+	return fmt.Sprintf("Unable to inflate %d gRPC messages with encoding %q", len(e.Messages), e.Encoding)
+}
+
+// DecodeGRPCFrames splits the response body of a gRPC call into its
+// messages, inflating compressed ones.  The messages are returned as raw
+// protobuf bytes for the caller to decode.  If some compressed messages
+// couldn't be inflated, every message is returned along with a
+// *GRPCCompressionError flagging them.  Returns an error if the response is
+// not application/grpc, or with the messages before it if the body ends in
+// a partial frame.
+func (p *RequestResponsePair) DecodeGRPCFrames() ([][]byte, error) {
+	if p.Response == nil {
+		return nil, errors.New("No response to decode")
+	}
+	return decodeGRPCFrames(p.Response.Header, p.ResponseBody)
+}
+
+// DecodeGRPCRequestFrames is like DecodeGRPCFrames, for the request body.
+func (p *RequestResponsePair) DecodeGRPCRequestFrames() ([][]byte, error) {
+	if p.Request == nil {
+		return nil, errors.New("No request to decode")
+	}
+	return decodeGRPCFrames(p.Request.Header, p.RequestBody)
+}
+
+// isGRPC reports whether the Content-Type in h is a gRPC one
+func isGRPC(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && (mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+"))
+}
+
+func decodeGRPCFrames(h http.Header, body []byte) ([][]byte, error) {
+	if !isGRPC(h) {
+		return nil, fmt.Errorf("Not a gRPC body: %s", h.Get("Content-Type"))
+	}
+	encoding := h.Get("Grpc-Encoding")
+	var msgs [][]byte
+	var compErr *GRPCCompressionError
+	for len(body) > 0 {
+		if len(body) < grpcFrameHeader {
+			return msgs, fmt.Errorf("Truncated gRPC frame header")
+		}
+		compressed := body[0] == 1
+		length := binary.BigEndian.Uint32(body[1:grpcFrameHeader])
+		body = body[grpcFrameHeader:]
+		if uint64(length) > uint64(len(body)) {
+			return msgs, fmt.Errorf("Truncated gRPC message: %d of %d bytes", len(body), length)
+		}
+		msg := body[:length]
+		body = body[length:]
+		if compressed {
+			if inflated, err := inflateGRPC(encoding, msg); err == nil {
+				msg = inflated
+			} else {
+				if compErr == nil {
+					compErr = &GRPCCompressionError{Encoding: encoding}
+				}
+				compErr.Messages = append(compErr.Messages, len(msgs))
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	if compErr != nil {
+		return msgs, compErr
+	}
+	return msgs, nil
+}
+
+// inflateGRPC decompresses a message with one of the standard encodings
+func inflateGRPC(encoding string, msg []byte) ([]byte, error) {
+	switch encoding {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case "deflate":
+		r, err := zlib.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("Unsupported gRPC encoding %q", encoding)
+}
@@ -0,0 +1,81 @@
+package httpsource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net/http"
+	"testing"
+)
+
+func grpcFrame(compressed bool, msg []byte) []byte {
+	frame := make([]byte, grpcFrameHeader, grpcFrameHeader+len(msg))
+	if compressed {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func grpcPair(encoding string, body []byte) *RequestResponsePair {
+	h := http.Header{"Content-Type": {"application/grpc+proto"}}
+	if encoding != "" {
+		h.Set("Grpc-Encoding", encoding)
+	}
+	return &RequestResponsePair{
+		Request:      &http.Request{Header: http.Header{"Content-Type": {"application/grpc"}}},
+		RequestBody:  grpcFrame(false, []byte("req")),
+		Response:     &http.Response{Header: h},
+		ResponseBody: body,
+	}
+}
+
+func TestDecodeGRPCFrames(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write([]byte("second"))
+	zw.Close()
+	body := append(grpcFrame(false, []byte("first")), grpcFrame(true, zipped.Bytes())...)
+	body = append(body, grpcFrame(false, nil)...)
+	msgs, err := grpcPair("gzip", body).DecodeGRPCFrames()
+	fatalIfErr(t, err)
+	if len(msgs) != 3 || string(msgs[0]) != "first" || string(msgs[1]) != "second" || len(msgs[2]) != 0 {
+		t.Errorf("Unexpected messages: %q\n", msgs)
+	}
+	msgs, err = grpcPair("", nil).DecodeGRPCRequestFrames()
+	fatalIfErr(t, err)
+	if len(msgs) != 1 || string(msgs[0]) != "req" {
+		t.Errorf("Unexpected request messages: %q\n", msgs)
+	}
+}
+
+func TestDecodeGRPCFramesCompressed(t *testing.T) {
+	body := append(grpcFrame(false, []byte("plain")), grpcFrame(true, []byte("snappy?"))...)
+	msgs, err := grpcPair("snappy", body).DecodeGRPCFrames()
+	compErr, ok := err.(*GRPCCompressionError)
+	if !ok {
+		t.Fatalf("Expected a compression error, got %v\n", err)
+	}
+	if compErr.Encoding != "snappy" || len(compErr.Messages) != 1 || compErr.Messages[0] != 1 {
+		t.Errorf("Unexpected compression error: %+v\n", compErr)
+	}
+	if len(msgs) != 2 || string(msgs[1]) != "snappy?" {
+		t.Errorf("Expected compressed message to be kept, got %q\n", msgs)
+	}
+}
+
+func TestDecodeGRPCFramesErrors(t *testing.T) {
+	pair := grpcPair("", grpcFrame(false, []byte("whole")))
+	pair.ResponseBody = append(pair.ResponseBody, grpcFrame(false, []byte("partial"))[:8]...)
+	msgs, err := pair.DecodeGRPCFrames()
+	if err == nil || len(msgs) != 1 {
+		t.Errorf("Expected truncation error after 1 message, got %q, %v\n", msgs, err)
+	}
+	pair.Response.Header.Set("Content-Type", "application/json")
+	if _, err := pair.DecodeGRPCFrames(); err == nil {
+		t.Error("Expected error for a non-gRPC body.\n")
+	}
+	if _, err := (&RequestResponsePair{}).DecodeGRPCFrames(); err == nil {
+		t.Error("Expected error without a response.\n")
+	}
+}
@@ -0,0 +1,177 @@
+package httpsource
+
+import (
+	"errors"
+	"github.com/Matir/httpwatch/har"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// ToHAREntry converts the pair into a HAR 1.2 entry.  Bodies that are not
+// valid UTF-8 are base64 encoded.
+func (p *RequestResponsePair) ToHAREntry() (har.Entry, error) {
+	if p.Request == nil || p.Response == nil {
+		return har.Entry{}, errors.New("HAR entries need both a request and a response")
+	}
+	req := p.Request
+	resp := p.Response
+	entry := har.Entry{
+		StartedDateTime: p.Timestamp,
+		Request: har.Request{
+			Method:      req.Method,
+			URL:         absoluteURL(req).String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: harQueryString(req.URL),
+			HeadersSize: -1,
+			BodySize:    len(p.RequestBody),
+		},
+		Response: har.Response{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Cookies:     harCookies(resp.Cookies()),
+			Headers:     harHeaders(resp.Header),
+			Content: har.Content{
+				Size:     len(p.ResponseBody),
+				MimeType: resp.Header.Get("Content-Type"),
+			},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(p.ResponseBody),
+		},
+		Timings: har.Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+	entry.ID = p.ID
+	entry.Connection = p.ConnInfo.ClientAddr
+	if p.ConnInfo.ServerAddr != "" {
+		if host, _, err := net.SplitHostPort(p.ConnInfo.ServerAddr); err == nil {
+			entry.ServerIPAddress = host
+		}
+	}
+	if p.ConnInfo.IsTLS() {
+		entry.TLS = &har.TLS{
+			Version:     p.ConnInfo.TLSVersionName(),
+			CipherSuite: p.ConnInfo.CipherSuiteName(),
+			ALPN:        p.ConnInfo.ALPN,
+			SNI:         p.ConnInfo.SNI,
+		}
+	}
+	if len(p.RequestTrailers) > 0 {
+		entry.Request.Trailers = harHeaders(p.RequestTrailers)
+	}
+	if len(p.ResponseTrailers) > 0 {
+		entry.Response.Trailers = harHeaders(p.ResponseTrailers)
+	}
+	if len(p.RequestBody) > 0 {
+		text, encoding := encodeBody(p.RequestBody)
+		entry.Request.PostData = &har.PostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		}
+	}
+	entry.Response.Content.Text, entry.Response.Content.Encoding = encodeBody(p.ResponseBody)
+	if latency := p.Latency(); latency >= 0 {
+		// Only the total is known, so attribute it all to waiting
+		entry.Time = float64(latency) / float64(time.Millisecond)
+		entry.Timings.Wait = entry.Time
+	}
+	return entry, nil
+}
+
+// PairsToHAR builds a HAR log containing an entry for each pair.  HAR only
+// holds complete exchanges, so WebSocket frames and pairs missing a request
+// or response, such as malformed ones, are skipped.
+func PairsToHAR(pairs []*RequestResponsePair) (*har.Log, error) {
+	log := &har.Log{
+		Version: har.Version,
+		Creator: har.Creator{Name: "httpwatch", Version: "0.1"},
+		Entries: make([]har.Entry, 0, len(pairs)),
+	}
+	for _, p := range pairs {
+		if p.Request == nil || p.Response == nil || p.WSFrame != nil {
+			continue
+		}
+		entry, err := p.ToHAREntry()
+		if err != nil {
+			return nil, err
+		}
+		log.Entries = append(log.Entries, entry)
+	}
+	return log, nil
+}
+
+// absoluteURL returns the full URL of a request.  Requests read off the wire
+// only carry the path, so fill in the scheme and host.
+func absoluteURL(req *http.Request) *url.URL {
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return &u
+}
+
+func harHeaders(h http.Header) []har.NameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hdrs := make([]har.NameValue, 0, len(h))
+	for _, name := range names {
+		for _, val := range h[name] {
+			hdrs = append(hdrs, har.NameValue{Name: name, Value: val})
+		}
+	}
+	return hdrs
+}
+
+func harQueryString(u *url.URL) []har.NameValue {
+	qs := make([]har.NameValue, 0)
+	if u == nil {
+		return qs
+	}
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, val := range query[name] {
+			qs = append(qs, har.NameValue{Name: name, Value: val})
+		}
+	}
+	return qs
+}
+
+func harCookies(cookies []*http.Cookie) []har.Cookie {
+	hc := make([]har.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		cookie := har.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			cookie.Expires = &expires
+		}
+		hc = append(hc, cookie)
+	}
+	return hc
+}
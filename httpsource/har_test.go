@@ -0,0 +1,82 @@
+package httpsource
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testPair(t *testing.T, rawReq, rawResp string) *RequestResponsePair {
+	conn := HTTPConnection{}
+	conn.readConnection(bufio.NewReader(strings.NewReader(rawReq)),
+		bufio.NewReader(strings.NewReader(rawResp)))
+	if len(conn.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair, got %d: %v\n", len(conn.Pairs), conn.err)
+	}
+	return conn.Pairs[0]
+}
+
+func TestToHAREntry(t *testing.T) {
+	pair := testPair(t,
+		"POST /submit?b=2&a=1&a=3 HTTP/1.1\r\nHost: example.com\r\nCookie: s=1\r\n"+
+			"Content-Type: text/plain\r\nContent-Length: 4\r\n\r\ndata",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n"+
+			"Content-Length: 2\r\n\r\n\xff\xfe")
+	entry, err := pair.ToHAREntry()
+	fatalIfErr(t, err)
+
+	if entry.Request.URL != "http://example.com/submit?b=2&a=1&a=3" {
+		t.Errorf("Unexpected URL: %s\n", entry.Request.URL)
+	}
+	qs := entry.Request.QueryString
+	if len(qs) != 3 || qs[0].Name != "a" || qs[0].Value != "1" || qs[2].Name != "b" {
+		t.Errorf("Unexpected query string: %v\n", qs)
+	}
+	if len(entry.Request.Cookies) != 1 || entry.Request.Cookies[0].Name != "s" {
+		t.Errorf("Unexpected cookies: %v\n", entry.Request.Cookies)
+	}
+	if pd := entry.Request.PostData; pd == nil || pd.Text != "data" || pd.Encoding != "" {
+		t.Errorf("Unexpected postData: %+v\n", pd)
+	}
+	content := entry.Response.Content
+	if content.Encoding != "base64" || content.Text != base64.StdEncoding.EncodeToString([]byte("\xff\xfe")) {
+		t.Errorf("Expected base64 binary content, got %+v\n", content)
+	}
+	if entry.Response.Status != 200 || entry.Response.StatusText != "OK" {
+		t.Errorf("Unexpected status: %d %s\n", entry.Response.Status, entry.Response.StatusText)
+	}
+}
+
+func TestPairsToHAR(t *testing.T) {
+	pair := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 204 No Content\r\n\r\n")
+	log, err := PairsToHAR([]*RequestResponsePair{pair})
+	fatalIfErr(t, err)
+	if log.Version != "1.2" || len(log.Entries) != 1 {
+		t.Errorf("Unexpected log: %+v\n", log)
+	}
+	if log.Entries[0].Request.PostData != nil {
+		t.Error("Expected no postData for an empty body.\n")
+	}
+
+	if _, err := (&RequestResponsePair{Request: &http.Request{}}).ToHAREntry(); err == nil {
+		t.Error("Expected error converting pair without response.\n")
+	}
+}
+
+func TestPairsToHARMixed(t *testing.T) {
+	ok := testPair(t, "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 204 No Content\r\n\r\n")
+	missing := testPair(t, "GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 204 No Content\r\n\r\n")
+	missing.Response = nil
+	missing.markMalformed("Missing response")
+	frame := &RequestResponsePair{Request: ok.Request, WSFrame: &WSFrame{Opcode: 1, Payload: []byte("hi")}}
+	last := testPair(t, "GET /c HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 204 No Content\r\n\r\n")
+	log, err := PairsToHAR([]*RequestResponsePair{ok, missing, frame, {}, last})
+	fatalIfErr(t, err)
+	if len(log.Entries) != 2 || log.Entries[0].Request.URL != "http://example.com/a" ||
+		log.Entries[1].Request.URL != "http://example.com/c" {
+		t.Errorf("Expected only the complete exchanges, got %+v\n", log.Entries)
+	}
+}
@@ -0,0 +1,123 @@
+package httpsource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Hash returns a SHA-256 over the method, URL with a lowercased host,
+// headers, bodies, status code and any WebSocket frame of the pair, in hex.
+// Header names are canonicalized and sorted, so header order doesn't affect
+// the hash; the order of a header's values does.  Timestamps and Seq are not
+// included.
+func (p *RequestResponsePair) Hash() string {
+	h := sha256.New()
+	if p.Request != nil {
+		writeHashField(h, []byte("request"))
+		writeHashField(h, []byte(p.Request.Method))
+		writeHashField(h, []byte(p.NormalizedURL(NormalizeOptions{LowercaseHost: true})))
+		writeHashHeaders(h, p.Request.Header)
+		writeHashField(h, p.RequestBody)
+	}
+	if p.Response != nil {
+		writeHashField(h, []byte("response"))
+		writeHashField(h, []byte(strconv.Itoa(p.Response.StatusCode)))
+		writeHashHeaders(h, p.Response.Header)
+		writeHashField(h, p.ResponseBody)
+	}
+	if f := p.WSFrame; f != nil {
+		writeHashField(h, []byte("wsframe"))
+		writeHashField(h, []byte{boolByte(f.FromClient), f.Opcode, boolByte(f.Fin)})
+		writeHashField(h, f.Payload)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Equal reports whether two pairs have the same Hash inputs: method, URL,
+// headers, bodies, status code and WebSocket frame.
+func (p *RequestResponsePair) Equal(other *RequestResponsePair) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if (p.Request == nil) != (other.Request == nil) || (p.Response == nil) != (other.Response == nil) {
+		return false
+	}
+	if p.Request != nil {
+		opts := NormalizeOptions{LowercaseHost: true}
+		if p.Request.Method != other.Request.Method ||
+			p.NormalizedURL(opts) != other.NormalizedURL(opts) ||
+			!reflect.DeepEqual(canonicalHeader(p.Request.Header), canonicalHeader(other.Request.Header)) ||
+			!bytes.Equal(p.RequestBody, other.RequestBody) {
+			return false
+		}
+	}
+	if p.Response != nil {
+		if p.Response.StatusCode != other.Response.StatusCode ||
+			!reflect.DeepEqual(canonicalHeader(p.Response.Header), canonicalHeader(other.Response.Header)) ||
+			!bytes.Equal(p.ResponseBody, other.ResponseBody) {
+			return false
+		}
+	}
+	if (p.WSFrame == nil) != (other.WSFrame == nil) {
+		return false
+	}
+	if f, o := p.WSFrame, other.WSFrame; f != nil {
+		if f.FromClient != o.FromClient || f.Opcode != o.Opcode || f.Fin != o.Fin ||
+			!bytes.Equal(f.Payload, o.Payload) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeHashField writes b with its length, so fields can't run together
+func writeHashField(h hash.Hash, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
+
+func writeHashHeaders(h hash.Hash, header http.Header) {
+	canon := canonicalHeader(header)
+	names := make([]string, 0, len(canon))
+	for name := range canon {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeHashField(h, []byte(strconv.Itoa(len(names))))
+	for _, name := range names {
+		writeHashField(h, []byte(name))
+		writeHashField(h, []byte(strconv.Itoa(len(canon[name]))))
+		for _, val := range canon[name] {
+			writeHashField(h, []byte(val))
+		}
+	}
+}
+
+// canonicalHeader returns h with canonical names, merging any names that
+// differ only in case.  Empty headers are dropped.
+func canonicalHeader(h http.Header) http.Header {
+	canon := make(http.Header, len(h))
+	for name, vals := range h {
+		if len(vals) > 0 {
+			key := http.CanonicalHeaderKey(name)
+			canon[key] = append(canon[key], vals...)
+		}
+	}
+	return canon
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package httpsource
+
+import (
+	"testing"
+)
+
+func TestHashAndEqual(t *testing.T) {
+	a := testPair(t, "POST /x HTTP/1.1\r\nHost: Example.com\r\nX-A: 1\r\nX-B: 2\r\nContent-Length: 1\r\n\r\nz",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	b := testPair(t, "POST /x HTTP/1.1\r\nHost: example.com\r\nx-b: 2\r\nX-A: 1\r\nContent-Length: 1\r\n\r\nz",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	b.Seq = 5
+	if a.Hash() != b.Hash() || !a.Equal(b) {
+		t.Error("Expected header order, host case and seq not to matter.\n")
+	}
+
+	c := a.Clone()
+	c.ResponseBody = []byte("no")
+	if a.Hash() == c.Hash() || a.Equal(c) {
+		t.Error("Expected a different body to change the hash.\n")
+	}
+	d := a.Clone()
+	d.Request.Header["X-A"] = []string{"1", "1"}
+	if a.Hash() == d.Hash() || a.Equal(d) {
+		t.Error("Expected a repeated header value to change the hash.\n")
+	}
+	if a.Equal(&RequestResponsePair{}) || a.Equal(nil) {
+		t.Error("Expected pairs with differing halves to be unequal.\n")
+	}
+	if a.Fingerprint() != a.Hash() {
+		t.Error("Expected Fingerprint to be the Hash.\n")
+	}
+}
@@ -0,0 +1,49 @@
+package httpsource
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Points placed on the ring for each output, to even out the distribution
+const hashRingReplicas = 64
+
+// hashRing maps keys onto output names by consistent hashing, so adding or
+// removing an output only remaps the keys near its points on the ring.
+type hashRing struct {
+	points []uint64
+	names  map[uint64]string
+}
+
+func newHashRing(names []string) *hashRing {
+	r := &hashRing{names: make(map[uint64]string, len(names)*hashRingReplicas)}
+	for _, name := range names {
+		for i := 0; i < hashRingReplicas; i++ {
+			h := hashKey(name + "#" + strconv.Itoa(i))
+			r.points = append(r.points, h)
+			r.names[h] = name
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// lookup returns the name owning key, or "" for an empty ring
+func (r *hashRing) lookup(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.names[r.points[i]]
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,214 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The largest frame HTTP/2 allows a peer to ask for
+const maxHTTP2FrameSize = 1<<24 - 1
+
+// h2Stream collects the frames of a single HTTP/2 stream
+type h2Stream struct {
+	id       uint32
+	req      *http.Request
+	reqBody  bytes.Buffer
+	reqSeen  time.Time
+	resp     *http.Response
+	respBody bytes.Buffer
+	respEnd  time.Time
+}
+
+// hasHTTP2Preface reports whether r starts with the HTTP/2 client preface,
+// sent by clients speaking HTTP/2 with prior knowledge
+func hasHTTP2Preface(r *bufio.Reader) bool {
+	peek, _ := r.Peek(len(http2.ClientPreface))
+	return string(peek) == http2.ClientPreface
+}
+
+// IsHTTP2 reports whether the pair was exchanged over HTTP/2.
+func (p *RequestResponsePair) IsHTTP2() bool {
+	return (p.Request != nil && p.Request.ProtoMajor == 2) ||
+		(p.Response != nil && p.Response.ProtoMajor == 2)
+}
+
+// readHTTP2 reads an HTTP/2 connection, matching requests and responses by
+// stream id rather than by order, since streams are interleaved.  Pairs are
+// added in stream order, which is the order the requests were started.
+// Streams without both a request and a response, such as server pushes or
+// reset streams, are left out.
+func (conn *HTTPConnection) readHTTP2(request, response *bufio.Reader) {
+	streams := make(map[uint32]*h2Stream)
+	getStream := func(id uint32) *h2Stream {
+		s, ok := streams[id]
+		if !ok {
+			s = &h2Stream{id: id}
+			streams[id] = s
+		}
+		return s
+	}
+	request.Discard(len(http2.ClientPreface))
+	if err := readHTTP2Frames(request, conn.reqClock, func(f http2.Frame, seen, _ time.Time) error {
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			s := getStream(f.StreamID)
+			if s.req != nil {
+				// Request trailers
+				if s.req.Trailer == nil {
+					s.req.Trailer = make(http.Header)
+				}
+				addHTTP2Fields(s.req.Trailer, f.RegularFields())
+				return nil
+			}
+			req, err := http2Request(f)
+			if err != nil {
+				return err
+			}
+			s.req = req
+			s.reqSeen = seen
+		case *http2.DataFrame:
+			getStream(f.StreamID).reqBody.Write(f.Data())
+		}
+		return nil
+	}); err != nil {
+		logger.Printf("Error reading HTTP/2 requests: %v\n", err)
+		conn.err = err
+	}
+	if err := readHTTP2Frames(response, conn.respClock, func(f http2.Frame, _, last time.Time) error {
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			s := getStream(f.StreamID)
+			// Headers after a final response are trailers, while a final
+			// response replaces any informational ones
+			if s.resp != nil && s.resp.StatusCode >= 200 {
+				if s.resp.Trailer == nil {
+					s.resp.Trailer = make(http.Header)
+				}
+				addHTTP2Fields(s.resp.Trailer, f.RegularFields())
+			} else {
+				resp, err := http2Response(f)
+				if err != nil {
+					return err
+				}
+				s.resp = resp
+			}
+			if f.StreamEnded() {
+				s.respEnd = last
+			}
+		case *http2.DataFrame:
+			s := getStream(f.StreamID)
+			s.respBody.Write(f.Data())
+			if f.StreamEnded() {
+				s.respEnd = last
+			}
+		}
+		return nil
+	}); err != nil {
+		logger.Printf("Error reading HTTP/2 responses: %v\n", err)
+		conn.err = err
+	}
+
+	ordered := make([]*h2Stream, 0, len(streams))
+	for _, s := range streams {
+		if s.req != nil && s.resp != nil {
+			ordered = append(ordered, s)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].id < ordered[j].id
+	})
+	for _, s := range ordered {
+		reqbuf := s.reqBody.Bytes()
+		respbuf := s.respBody.Bytes()
+		s.req.Body = newBodyBuffer(reqbuf)
+		s.req.ContentLength = int64(len(reqbuf))
+		s.req.RemoteAddr = conn.clientAddr
+		s.resp.Body = newBodyBuffer(respbuf)
+		s.resp.ContentLength = int64(len(respbuf))
+		s.resp.Request = s.req
+		conn.Pairs = append(conn.Pairs, &RequestResponsePair{Request: s.req,
+			RequestBody: reqbuf, Response: s.resp, ResponseBody: respbuf,
+			RequestTrailers: sentTrailers(s.req.Trailer), ResponseTrailers: sentTrailers(s.resp.Trailer),
+			Timestamp: s.reqSeen, ResponseEnd: s.respEnd, StreamID: s.id, ID: requestID(s.req),
+			ConnInfo: ConnInfo{ClientAddr: conn.clientAddr, ServerAddr: conn.serverAddr}})
+	}
+}
+
+// readHTTP2Frames calls fn with each frame read from r, and the capture
+// times of its first and last bytes, until r is exhausted.
+func readHTTP2Frames(r *bufio.Reader, clock *streamClock, fn func(f http2.Frame, seen, last time.Time) error) error {
+	framer := http2.NewFramer(nil, r)
+	framer.SetMaxReadFrameSize(maxHTTP2FrameSize)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	for {
+		seen := clock.next()
+		f, err := framer.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(f, seen, clock.last()); err != nil {
+			return err
+		}
+	}
+}
+
+func addHTTP2Fields(h http.Header, fields []hpack.HeaderField) {
+	for _, hf := range fields {
+		h.Add(http.CanonicalHeaderKey(hf.Name), hf.Value)
+	}
+}
+
+// http2Request builds a request from the header block starting a stream
+func http2Request(f *http2.MetaHeadersFrame) (*http.Request, error) {
+	method := f.PseudoValue("method")
+	path := f.PseudoValue("path")
+	if method == "" || path == "" {
+		return nil, fmt.Errorf("HTTP/2 stream %d has no method or path", f.StreamID)
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Host:       f.PseudoValue("authority"),
+		Header:     make(http.Header),
+	}
+	addHTTP2Fields(req.Header, f.RegularFields())
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	return req, nil
+}
+
+// http2Response builds a response from the header block starting a stream
+func http2Response(f *http2.MetaHeadersFrame) (*http.Response, error) {
+	code, err := strconv.Atoi(f.PseudoValue("status"))
+	if err != nil {
+		return nil, fmt.Errorf("HTTP/2 stream %d has a bad status: %v", f.StreamID, err)
+	}
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+	}
+	addHTTP2Fields(resp.Header, f.RegularFields())
+	return resp, nil
+}
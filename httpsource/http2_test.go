@@ -0,0 +1,135 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"testing"
+)
+
+// h2Writer writes the frames of one side of an HTTP/2 connection
+type h2Writer struct {
+	t      *testing.T
+	buf    bytes.Buffer
+	hbuf   bytes.Buffer
+	framer *http2.Framer
+	enc    *hpack.Encoder
+}
+
+func newH2Writer(t *testing.T, preface bool) *h2Writer {
+	w := &h2Writer{t: t}
+	if preface {
+		w.buf.WriteString(http2.ClientPreface)
+	}
+	w.framer = http2.NewFramer(&w.buf, nil)
+	w.enc = hpack.NewEncoder(&w.hbuf)
+	fatalIfErr(t, w.framer.WriteSettings())
+	return w
+}
+
+func (w *h2Writer) headers(stream uint32, end bool, fields ...string) {
+	w.hbuf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		fatalIfErr(w.t, w.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}))
+	}
+	fatalIfErr(w.t, w.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      stream,
+		BlockFragment: w.hbuf.Bytes(),
+		EndStream:     end,
+		EndHeaders:    true,
+	}))
+}
+
+func (w *h2Writer) data(stream uint32, end bool, data string) {
+	fatalIfErr(w.t, w.framer.WriteData(stream, end, []byte(data)))
+}
+
+func TestHTTP2InterleavedStreams(t *testing.T) {
+	client := newH2Writer(t, true)
+	client.headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/slow")
+	client.headers(3, false, ":method", "POST", ":scheme", "http", ":authority", "example.com", ":path", "/fast",
+		"content-type", "text/plain")
+	client.data(3, false, "up")
+	client.data(3, true, "load")
+
+	// The second stream's response finishes first, and the data interleaves
+	server := newH2Writer(t, false)
+	server.headers(3, false, ":status", "201", "content-type", "text/plain")
+	server.headers(1, false, ":status", "200")
+	server.data(3, false, "fa")
+	server.data(1, false, "sl")
+	server.data(3, true, "st")
+	server.data(1, true, "ow")
+
+	conn := HTTPConnection{
+		data: [2][]byte{server.buf.Bytes(), client.buf.Bytes()},
+	}
+	request, response, err := conn.sortStreams()
+	fatalIfErr(t, err)
+	conn.readConnection(request, response)
+	if len(conn.Pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %d: %v\n", len(conn.Pairs), conn.err)
+	}
+	slow, fast := conn.Pairs[0], conn.Pairs[1]
+	if slow.StreamID != 1 || slow.Request.URL.Path != "/slow" || slow.Response.StatusCode != 200 ||
+		string(slow.ResponseBody) != "slow" {
+		t.Errorf("Unexpected stream 1 pair: %d %v %d %q\n", slow.StreamID, slow.Request.URL,
+			slow.Response.StatusCode, slow.ResponseBody)
+	}
+	if fast.StreamID != 3 || fast.Request.Method != "POST" || string(fast.RequestBody) != "upload" ||
+		fast.Response.StatusCode != 201 || string(fast.ResponseBody) != "fast" {
+		t.Errorf("Unexpected stream 3 pair: %d %s %q %d %q\n", fast.StreamID, fast.Request.Method,
+			fast.RequestBody, fast.Response.StatusCode, fast.ResponseBody)
+	}
+	if !fast.IsHTTP2() || fast.Request.Host != "example.com" || fast.Request.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected request: %+v\n", fast.Request)
+	}
+	if fast.Response.Status != "201 Created" {
+		t.Errorf("Unexpected status: %s\n", fast.Response.Status)
+	}
+}
+
+func TestHTTP2ResetStream(t *testing.T) {
+	client := newH2Writer(t, true)
+	client.headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/gone")
+	client.headers(3, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/ok")
+	server := newH2Writer(t, false)
+	fatalIfErr(t, server.framer.WriteRSTStream(1, http2.ErrCodeCancel))
+	server.headers(3, true, ":status", "204")
+
+	conn := HTTPConnection{}
+	conn.readConnection(bufio.NewReader(&client.buf), bufio.NewReader(&server.buf))
+	if len(conn.Pairs) != 1 || conn.Pairs[0].StreamID != 3 {
+		t.Fatalf("Expected only stream 3, got %d pairs\n", len(conn.Pairs))
+	}
+}
+
+func TestHTTP2Trailers(t *testing.T) {
+	client := newH2Writer(t, true)
+	client.headers(1, false, ":method", "POST", ":scheme", "http", ":authority", "example.com",
+		":path", "/pkg.Service/Call", "content-type", "application/grpc")
+	client.data(1, true, "req")
+
+	server := newH2Writer(t, false)
+	server.headers(1, false, ":status", "200", "content-type", "application/grpc")
+	server.data(1, false, "resp")
+	server.headers(1, true, "grpc-status", "5", "grpc-message", "not found")
+
+	conn := HTTPConnection{
+		data: [2][]byte{client.buf.Bytes(), server.buf.Bytes()},
+	}
+	request, response, err := conn.sortStreams()
+	fatalIfErr(t, err)
+	conn.readConnection(request, response)
+	if len(conn.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair, got %d: %v\n", len(conn.Pairs), conn.err)
+	}
+	pair := conn.Pairs[0]
+	if pair.TrailerValue("grpc-status") != "5" || pair.TrailerValue("Grpc-Message") != "not found" {
+		t.Errorf("Unexpected trailers: %v\n", pair.ResponseTrailers)
+	}
+	if pair.Response.Header.Get("Grpc-Status") != "" || pair.RequestTrailers != nil {
+		t.Errorf("Expected trailers only on the response: %v, %v\n", pair.Response.Header, pair.RequestTrailers)
+	}
+}
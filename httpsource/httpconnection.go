@@ -7,13 +7,14 @@ package httpsource
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"github.com/google/gopacket/tcpassembly"
 	"github.com/google/gopacket/tcpassembly/tcpreader"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"strings"
+	"sort"
+	"time"
 )
 
 // RequestResponsePair is a container for an associated
@@ -24,20 +25,102 @@ type RequestResponsePair struct {
 	RequestBody  []byte
 	Response     *http.Response
 	ResponseBody []byte
-	fingerprint  *string
+	// RequestTrailers and ResponseTrailers are the headers sent after a
+	// chunked body, or in a trailing HTTP/2 header block.  They are nil if
+	// there were none.
+	RequestTrailers  http.Header
+	ResponseTrailers http.Header
+	// Timestamp is when the start of the request was captured, and
+	// ResponseEnd when the end of the response was.  Either may be zero if
+	// unknown.
+	Timestamp   time.Time
+	ResponseEnd time.Time
+	// ID identifies the exchange for cross referencing with application
+	// logs.  Sources set it from the request's X-Request-ID header, or to a
+	// random UUID if there is none.
+	ID string
+	// Seq is assigned by a PairMux as the pair enters it, counting up from
+	// 1, so consumers can spot pairs dropped upstream of them by gaps.  It
+	// is zero for pairs that have not been through a mux.
+	Seq uint64
+	// ConnInfo describes the connection the pair was captured on
+	ConnInfo ConnInfo
+	// StreamID is the HTTP/2 stream the pair was exchanged on, or zero for
+	// earlier versions of HTTP
+	StreamID uint32
+	// Malformed is set if the request or response could only be partly
+	// parsed, with MalformedReason saying why.  Whatever was parsed is kept.
+	Malformed       bool
+	MalformedReason string
+	// WSFrame is set if the pair is a WebSocket frame rather than an HTTP
+	// exchange
+	WSFrame *WSFrame
+	// ResolvedHost, when set by ResolveHosts, is the name the server's IP
+	// address reverse resolves to
+	ResolvedHost string
+	// RepeatCount is the number of pairs this one stands for, when set by
+	// Coalesce, and zero otherwise
+	RepeatCount int
+	// JarCookies, when set by TrackCookies, are the cookies the host had set
+	// when the request was sent
+	JarCookies []*http.Cookie
+	// Sentinel is set if the pair marks the end of a mux output's stream
+	// rather than being a captured exchange
+	Sentinel    *Sentinel
+	fingerprint *string
 }
 
 // HTTPConnection represents the HTTP transactions within a single
 // TCP session.  It may contain 1 or more RequestResponsePairs.
 // Multiple pairs will be included in a keep-alive connection.
 type HTTPConnection struct {
-	Pairs    []*RequestResponsePair
-	key      connKey
-	data     [2][]byte
-	cdata    int
-	fin      chan bool
-	Finished func(*HTTPConnection)
-	err      error
+	Pairs     []*RequestResponsePair
+	key       connKey
+	data      [2][]byte
+	cdata     int
+	timing    [2]*timedStream
+	reqClock  *streamClock
+	respClock *streamClock
+	// clientAddr is the host:port requests were sent from, and serverAddr
+	// the host:port responses were sent from, if known
+	clientAddr string
+	serverAddr string
+	fin        chan bool
+	Finished   func(*HTTPConnection)
+	err        error
+}
+
+// timedStream is a ReaderStream that remembers when each part of the stream
+// was captured.
+type timedStream struct {
+	tcpreader.ReaderStream
+	marks []streamMark
+	total int
+	// flow is the network and transport flow of the stream's packets
+	flow connKey
+}
+
+// srcAddr returns the host:port the stream was sent from, or an empty
+// string if unknown.
+func (s *timedStream) srcAddr() string {
+	if s == nil || s.flow == (connKey{}) {
+		return ""
+	}
+	return net.JoinHostPort(s.flow[0].Src().String(), s.flow[1].Src().String())
+}
+
+// streamMark records that the byte at offset was captured at seen
+type streamMark struct {
+	offset int
+	seen   time.Time
+}
+
+// streamClock tracks the capture time of a timedStream's data as it is
+// read through br, which wraps r.  A nil streamClock returns zero times.
+type streamClock struct {
+	stream *timedStream
+	r      *bytes.Reader
+	br     *bufio.Reader
 }
 
 // bodyBuffer implements ReaderCloser by wrapping a bytes.Reader.
@@ -54,6 +137,16 @@ func NewHTTPConnection(key connKey, finished func(*HTTPConnection)) *HTTPConnect
 
 // AddStream adds a ReaderStream to the connection conn.
 func (conn *HTTPConnection) AddStream(s *tcpreader.ReaderStream) {
+	conn.readStream(s)
+}
+
+// addStream adds a stream that also provides capture timestamps
+func (conn *HTTPConnection) addStream(s *timedStream) {
+	conn.timing[conn.cdata] = s
+	conn.readStream(&s.ReaderStream)
+}
+
+func (conn *HTTPConnection) readStream(s *tcpreader.ReaderStream) {
 	// launch a goroutine to read everything
 	choice := conn.cdata
 	conn.cdata++
@@ -87,6 +180,10 @@ func (conn *HTTPConnection) startReadConnection() {
 
 // Implementation of reading connection, should be more testable
 func (conn *HTTPConnection) readConnection(request, response *bufio.Reader) {
+	if hasHTTP2Preface(request) {
+		conn.readHTTP2(request, response)
+		return
+	}
 	eof := false
 
 	handleErr := func(err error) bool {
@@ -103,39 +200,61 @@ func (conn *HTTPConnection) readConnection(request, response *bufio.Reader) {
 	}
 
 	for {
+		timestamp := conn.reqClock.next()
 		req, err := http.ReadRequest(request)
-		if handleErr(err) {
+		if handleErr(err) || req == nil {
 			return
 		}
+		req.RemoteAddr = conn.clientAddr
 		// Replace the body
 		reqbuf, err := ioutil.ReadAll(req.Body)
 		req.Body.Close()
 		req.Body = &bodyBuffer{bytes.NewReader(reqbuf)}
+		pair := &RequestResponsePair{Request: req, RequestBody: reqbuf,
+			RequestTrailers: sentTrailers(req.Trailer), Timestamp: timestamp,
+			ConnInfo: ConnInfo{ClientAddr: conn.clientAddr, ServerAddr: conn.serverAddr},
+			ID:       requestID(req)}
+		// Keep what was parsed of a broken exchange, flagged as malformed
 		if handleErr(err) {
+			conn.Pairs = append(conn.Pairs, pair.markMalformed("Incomplete request body: %v", err))
 			return
 		}
 		err = consumeWhitespace(request)
 		handleErr(err)
 
 		// Try to read a matching response
+		if _, err := response.Peek(1); err == io.EOF {
+			conn.Pairs = append(conn.Pairs, pair.markMalformed("Missing response"))
+			return
+		}
 		resp, err := http.ReadResponse(response, req)
-		if handleErr(err) {
+		if err != nil {
+			handleErr(err)
+			conn.Pairs = append(conn.Pairs, pair.markMalformed("Bad response: %v", err))
 			return
 		}
 
 		// Replace the body
 		// TODO: figure out a lower memory version of this
 		respbuf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = &bodyBuffer{bytes.NewReader(respbuf)}
+		pair.Response = resp
+		pair.ResponseBody = respbuf
+		pair.ResponseTrailers = sentTrailers(resp.Trailer)
+		pair.ResponseEnd = conn.respClock.last()
 		if handleErr(err) {
+			conn.Pairs = append(conn.Pairs, pair.markMalformed("Incomplete response body: %v", err))
 			return
 		}
-		resp.Body.Close()
-		resp.Body = &bodyBuffer{bytes.NewReader(respbuf)}
-
-		pair := &RequestResponsePair{Request: req,
-			RequestBody: reqbuf, Response: resp, ResponseBody: respbuf}
 		conn.Pairs = append(conn.Pairs, pair)
 
+		// The rest of the connection is WebSocket frames, not HTTP
+		if isWebSocketUpgrade(resp) {
+			conn.Pairs = append(conn.Pairs, conn.readWebSocket(pair, request, response)...)
+			return
+		}
+
 		err = consumeWhitespace(response)
 		handleErr(err)
 
@@ -152,16 +271,24 @@ func (conn *HTTPConnection) Success() bool {
 
 // Who is the request & response?
 func (conn *HTTPConnection) sortStreams() (*bufio.Reader, *bufio.Reader, error) {
-	a := bufio.NewReader(bytes.NewReader(conn.data[0]))
-	b := bufio.NewReader(bytes.NewReader(conn.data[1]))
+	ra, rb := bytes.NewReader(conn.data[0]), bytes.NewReader(conn.data[1])
+	a, b := bufio.NewReader(ra), bufio.NewReader(rb)
 	peek, err := a.Peek(5)
 	if err != nil {
 		return nil, nil, err
 	}
-	if string(peek) == "HTTP/" {
+	if string(peek) == "HTTP/" || hasHTTP2Preface(b) {
 		// a is a response
+		conn.reqClock = conn.timing[1].clock(rb, b)
+		conn.respClock = conn.timing[0].clock(ra, a)
+		conn.clientAddr = conn.timing[1].srcAddr()
+		conn.serverAddr = conn.timing[0].srcAddr()
 		return b, a, nil
 	}
+	conn.reqClock = conn.timing[0].clock(ra, a)
+	conn.respClock = conn.timing[1].clock(rb, b)
+	conn.clientAddr = conn.timing[0].srcAddr()
+	conn.serverAddr = conn.timing[1].srcAddr()
 	return a, b, nil
 }
 
@@ -184,32 +311,159 @@ func consumeWhitespace(r *bufio.Reader) error {
 	}
 }
 
-// Fingerprint computes a fingerprint over the request and response data.
-// Potentially very slow for large requests or responses.
+// Fingerprint returns the pair's Hash, computing it on first use.  Changes
+// to the pair after the first call are not reflected.
 func (p *RequestResponsePair) Fingerprint() string {
 	if p.fingerprint != nil {
 		return *p.fingerprint
 	}
+	s := p.Hash()
+	p.fingerprint = &s
+	return *p.fingerprint
+}
+
+// Latency returns the time from the start of the request to the end of the
+// response, or -1 if either time is unknown.
+func (p *RequestResponsePair) Latency() time.Duration {
+	if p.Timestamp.IsZero() || p.ResponseEnd.IsZero() {
+		return -1
+	}
+	return p.ResponseEnd.Sub(p.Timestamp)
+}
 
-	h := sha256.New()
+// Clone returns a deep copy of the pair, including the request and response
+// headers and bodies.  The copies' Body readers read from the cloned bodies.
+func (p *RequestResponsePair) Clone() *RequestResponsePair {
+	c := &RequestResponsePair{
+		RequestBody:      cloneBytes(p.RequestBody),
+		ResponseBody:     cloneBytes(p.ResponseBody),
+		RequestTrailers:  p.RequestTrailers.Clone(),
+		ResponseTrailers: p.ResponseTrailers.Clone(),
+		Timestamp:        p.Timestamp,
+		ResponseEnd:      p.ResponseEnd,
+		ID:               p.ID,
+		Seq:              p.Seq,
+		ConnInfo:         p.ConnInfo,
+		StreamID:         p.StreamID,
+		Malformed:        p.Malformed,
+		MalformedReason:  p.MalformedReason,
+		RepeatCount:      p.RepeatCount,
+		ResolvedHost:     p.ResolvedHost,
+	}
+	for _, cookie := range p.JarCookies {
+		cookie := *cookie
+		c.JarCookies = append(c.JarCookies, &cookie)
+	}
+	if p.Sentinel != nil {
+		sentinel := *p.Sentinel
+		c.Sentinel = &sentinel
+	}
+	if p.WSFrame != nil {
+		frame := *p.WSFrame
+		frame.Payload = cloneBytes(p.WSFrame.Payload)
+		c.WSFrame = &frame
+	}
 	if p.Request != nil {
-		h.Write([]byte(p.Request.Method))
-		h.Write([]byte(p.Request.URL.String()))
-		for hdr, vals := range p.Request.Header {
-			h.Write([]byte(hdr + strings.Join(vals, "|")))
+		c.Request = p.Request.Clone(p.Request.Context())
+		if p.Request.Body != nil {
+			c.Request.Body = &bodyBuffer{bytes.NewReader(c.RequestBody)}
 		}
-		h.Write(p.RequestBody)
 	}
 	if p.Response != nil {
-		h.Write([]byte(p.Response.Status))
-		for hdr, vals := range p.Response.Header {
-			h.Write([]byte(hdr + strings.Join(vals, "|")))
+		resp := *p.Response
+		resp.Header = p.Response.Header.Clone()
+		resp.Trailer = p.Response.Trailer.Clone()
+		resp.TransferEncoding = append([]string(nil), p.Response.TransferEncoding...)
+		resp.Request = c.Request
+		if p.Response.Body != nil {
+			resp.Body = &bodyBuffer{bytes.NewReader(c.ResponseBody)}
 		}
-		h.Write(p.ResponseBody)
+		c.Response = &resp
 	}
-	s := hex.EncodeToString(h.Sum(nil))
-	p.fingerprint = &s
-	return *p.fingerprint
+	return c
+}
+
+// sentTrailers returns the trailers that were actually sent.  net/http
+// lists trailers announced in the Trailer header with no values until they
+// arrive, so those are left out.  Returns nil if none were sent.
+func sentTrailers(trailer http.Header) http.Header {
+	var sent http.Header
+	for name, vals := range trailer {
+		if len(vals) == 0 {
+			continue
+		}
+		if sent == nil {
+			sent = make(http.Header)
+		}
+		sent[name] = append([]string(nil), vals...)
+	}
+	return sent
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+// Reassembled records the capture time of the new data before passing it on
+// to the ReaderStream.  The marks are complete by the time the reader sees
+// EOF, so no locking is needed to read them afterwards.
+func (s *timedStream) Reassembled(reassembly []tcpassembly.Reassembly) {
+	for _, r := range reassembly {
+		if len(r.Bytes) == 0 {
+			continue
+		}
+		s.marks = append(s.marks, streamMark{s.total, r.Seen})
+		s.total += len(r.Bytes)
+	}
+	s.ReaderStream.Reassembled(reassembly)
+}
+
+// timeAt returns the capture time of the byte at offset
+func (s *timedStream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.marks), func(i int) bool {
+		return s.marks[i].offset > offset
+	})
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.marks[i-1].seen
+}
+
+// clock builds a streamClock for reading the stream's data through br,
+// which wraps r.  Returns nil if s is nil.
+func (s *timedStream) clock(r *bytes.Reader, br *bufio.Reader) *streamClock {
+	if s == nil {
+		return nil
+	}
+	return &streamClock{s, r, br}
+}
+
+// offset of the next unread byte
+func (c *streamClock) offset() int {
+	return int(c.r.Size()) - c.r.Len() - c.br.Buffered()
+}
+
+// next returns the capture time of the next unread byte
+func (c *streamClock) next() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return c.stream.timeAt(c.offset())
+}
+
+// last returns the capture time of the last byte read
+func (c *streamClock) last() time.Time {
+	if c == nil || c.offset() == 0 {
+		return time.Time{}
+	}
+	return c.stream.timeAt(c.offset() - 1)
+}
+
+func newBodyBuffer(b []byte) *bodyBuffer {
+	return &bodyBuffer{bytes.NewReader(b)}
 }
 
 func (b *bodyBuffer) Close() error { return nil }
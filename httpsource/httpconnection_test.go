@@ -2,10 +2,17 @@ package httpsource
 
 import (
 	"bufio"
+	"bytes"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func fatalIfErr(t *testing.T, err error) {
@@ -46,3 +53,87 @@ func TestReadConnection(t *testing.T) {
 		t.Fatalf("Got an error: %v\n", conn.err)
 	}
 }
+
+func TestPairClone(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/path", nil)
+	fatalIfErr(t, err)
+	req.Header.Set("X-Test", "original")
+	resp := &http.Response{Status: "200 OK", StatusCode: 200, Header: make(http.Header)}
+	resp.Header.Set("Content-Type", "text/plain")
+	pair := &RequestResponsePair{Request: req, RequestBody: []byte("req"),
+		Response: resp, ResponseBody: []byte("resp")}
+
+	c := pair.Clone()
+	c.Request.Header.Set("X-Test", "changed")
+	c.Request.URL.Path = "/other"
+	c.Response.Header.Set("Content-Type", "text/html")
+	c.RequestBody[0] = 'X'
+	c.ResponseBody[0] = 'X'
+
+	if v := pair.Request.Header.Get("X-Test"); v != "original" {
+		t.Errorf("Request header modified through clone: %s\n", v)
+	}
+	if pair.Request.URL.Path != "/path" {
+		t.Errorf("Request URL modified through clone: %s\n", pair.Request.URL.Path)
+	}
+	if v := pair.Response.Header.Get("Content-Type"); v != "text/plain" {
+		t.Errorf("Response header modified through clone: %s\n", v)
+	}
+	if string(pair.RequestBody) != "req" || string(pair.ResponseBody) != "resp" {
+		t.Errorf("Bodies modified through clone: %s %s\n", pair.RequestBody, pair.ResponseBody)
+	}
+	if pair.Fingerprint() == c.Fingerprint() {
+		t.Error("Expected modified clone to have a different fingerprint.\n")
+	}
+}
+
+func TestTimedStreamClock(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	s := &timedStream{ReaderStream: tcpreader.NewReaderStream()}
+	s.marks = []streamMark{{0, t0}, {10, t0.Add(time.Second)}}
+	data := bytes.NewReader(make([]byte, 20))
+	br := bufio.NewReader(data)
+	clock := s.clock(data, br)
+	if ts := clock.next(); !ts.Equal(t0) {
+		t.Errorf("Expected %v at offset 0, got %v\n", t0, ts)
+	}
+	if ts := clock.last(); !ts.IsZero() {
+		t.Errorf("Expected zero time before reading, got %v\n", ts)
+	}
+	br.Discard(10)
+	if ts := clock.last(); !ts.Equal(t0) {
+		t.Errorf("Expected %v at offset 9, got %v\n", t0, ts)
+	}
+	if ts := clock.next(); !ts.Equal(t0.Add(time.Second)) {
+		t.Errorf("Expected %v at offset 10, got %v\n", t0.Add(time.Second), ts)
+	}
+	var none *streamClock
+	if !none.next().IsZero() || !none.last().IsZero() {
+		t.Error("Expected zero times from nil clock.\n")
+	}
+}
+
+func TestLatency(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pair := &RequestResponsePair{Timestamp: t0, ResponseEnd: t0.Add(time.Second)}
+	if l := pair.Latency(); l != time.Second {
+		t.Errorf("Expected 1s latency, got %v\n", l)
+	}
+	pair.ResponseEnd = time.Time{}
+	if l := pair.Latency(); l != -1 {
+		t.Errorf("Expected unknown latency, got %v\n", l)
+	}
+}
+
+func TestTimedStreamSrcAddr(t *testing.T) {
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4())
+	tcpFlow, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(51234), layers.NewTCPPortEndpoint(80))
+	s := &timedStream{flow: connKey{netFlow, tcpFlow}}
+	if addr := s.srcAddr(); addr != "10.0.0.1:51234" {
+		t.Errorf("Unexpected source address: %s\n", addr)
+	}
+	var unknown *timedStream
+	if addr := unknown.srcAddr(); addr != "" {
+		t.Errorf("Expected no address for an unknown stream, got %s\n", addr)
+	}
+}
@@ -45,7 +45,8 @@ func NewHTTPSource() *HTTPSource {
 
 // New creates a new stream for a given flow
 func (src *HTTPSource) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
-	stream := tcpreader.NewReaderStream()
+	stream := &timedStream{ReaderStream: tcpreader.NewReaderStream(),
+		flow: connKey{netFlow, tcpFlow}}
 	// Add to mappings
 	key := connKey{netFlow, tcpFlow}
 	logger.Printf("Using key: %v\n", key)
@@ -59,8 +60,8 @@ func (src *HTTPSource) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
 		conn = NewHTTPConnection(key, src.connectionFinished)
 		src.pending[key] = conn
 	}
-	conn.AddStream(&stream)
-	return &stream
+	conn.addStream(stream)
+	return stream
 }
 
 // Callback for each connection
@@ -0,0 +1,41 @@
+package httpsource
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader is the header sources take a pair's ID from.
+const RequestIDHeader = "X-Request-ID"
+
+// CorrelationID returns the ID that ties the pair to application logs: its
+// ID if set, or else the request's X-Request-ID header.  It is empty if the
+// pair has neither.
+func (p *RequestResponsePair) CorrelationID() string {
+	if p.ID != "" || p.Request == nil {
+		return p.ID
+	}
+	return p.Request.Header.Get(RequestIDHeader)
+}
+
+// requestID returns the X-Request-ID of req, or a new random UUID if it has
+// none
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return newUUID()
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		logger.Printf("Unable to generate UUID: %s\n", err)
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
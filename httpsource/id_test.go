@@ -0,0 +1,47 @@
+package httpsource
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestPairID(t *testing.T) {
+	tagged, err := ParsePair(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\nX-Request-ID: abc-123\r\n\r\n"),
+		strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	fatalIfErr(t, err)
+	if tagged.ID != "abc-123" || tagged.CorrelationID() != "abc-123" {
+		t.Errorf("Expected ID from X-Request-ID, got %q\n", tagged.ID)
+	}
+	first := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	second := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if !uuidPattern.MatchString(first.ID) {
+		t.Errorf("Expected a UUID, got %q\n", first.ID)
+	}
+	if first.ID == second.ID {
+		t.Errorf("Expected distinct IDs, both were %q\n", first.ID)
+	}
+	if first.Clone().ID != first.ID {
+		t.Errorf("Clone didn't copy the ID\n")
+	}
+
+	data, err := first.MarshalJSON()
+	fatalIfErr(t, err)
+	decoded := &RequestResponsePair{}
+	fatalIfErr(t, decoded.UnmarshalJSON(data))
+	if decoded.ID != first.ID {
+		t.Errorf("Expected ID %q after JSON round trip, got %q\n", first.ID, decoded.ID)
+	}
+	entry, err := first.ToHAREntry()
+	fatalIfErr(t, err)
+	if entry.ID != first.ID {
+		t.Errorf("Expected HAR _id %q, got %q\n", first.ID, entry.ID)
+	}
+
+	bare := &RequestResponsePair{Request: tagged.Request}
+	if bare.CorrelationID() != "abc-123" {
+		t.Errorf("Expected CorrelationID to fall back to the header, got %q\n", bare.CorrelationID())
+	}
+}
@@ -0,0 +1,231 @@
+package httpsource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode/utf8"
+)
+
+// pairJSONVersion is the current version of the JSON pair format.  Bump it
+// when making incompatible changes.
+const pairJSONVersion = 1
+
+type pairJSON struct {
+	Version     int           `json:"version"`
+	Timestamp   *time.Time    `json:"timestamp,omitempty"`
+	ResponseEnd *time.Time    `json:"responseEnd,omitempty"`
+	ID          string        `json:"id,omitempty"`
+	Seq         uint64        `json:"seq,omitempty"`
+	StreamID    uint32        `json:"streamId,omitempty"`
+	Malformed   string        `json:"malformed,omitempty"`
+	RepeatCount int           `json:"repeatCount,omitempty"`
+	Resolved    string        `json:"resolvedHost,omitempty"`
+	Conn        *ConnInfo     `json:"conn,omitempty"`
+	WSFrame     *wsFrameJSON  `json:"wsFrame,omitempty"`
+	Request     *requestJSON  `json:"request,omitempty"`
+	Response    *responseJSON `json:"response,omitempty"`
+}
+
+type requestJSON struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Host         string      `json:"host,omitempty"`
+	RemoteAddr   string      `json:"remoteAddr,omitempty"`
+	Proto        string      `json:"proto,omitempty"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+	Trailer      http.Header `json:"trailer,omitempty"`
+}
+
+type wsFrameJSON struct {
+	FromClient      bool   `json:"fromClient,omitempty"`
+	Opcode          byte   `json:"opcode"`
+	Fin             bool   `json:"fin,omitempty"`
+	Payload         string `json:"payload,omitempty"`
+	PayloadEncoding string `json:"payloadEncoding,omitempty"`
+}
+
+type responseJSON struct {
+	Status       string      `json:"status"`
+	StatusCode   int         `json:"statusCode"`
+	Proto        string      `json:"proto,omitempty"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+	Trailer      http.Header `json:"trailer,omitempty"`
+}
+
+// MarshalJSON encodes the pair as JSON.  Bodies that are not valid UTF-8 are
+// base64 encoded.
+func (p *RequestResponsePair) MarshalJSON() ([]byte, error) {
+	pj := pairJSON{Version: pairJSONVersion, ID: p.ID, Seq: p.Seq, StreamID: p.StreamID, RepeatCount: p.RepeatCount, Resolved: p.ResolvedHost}
+	if !p.Timestamp.IsZero() {
+		pj.Timestamp = &p.Timestamp
+	}
+	if !p.ResponseEnd.IsZero() {
+		pj.ResponseEnd = &p.ResponseEnd
+	}
+	if !p.ConnInfo.IsZero() {
+		pj.Conn = &p.ConnInfo
+	}
+	if p.Malformed {
+		pj.Malformed = p.MalformedReason
+		if pj.Malformed == "" {
+			pj.Malformed = "malformed"
+		}
+	}
+	if f := p.WSFrame; f != nil {
+		pj.WSFrame = &wsFrameJSON{FromClient: f.FromClient, Opcode: f.Opcode, Fin: f.Fin}
+		pj.WSFrame.Payload, pj.WSFrame.PayloadEncoding = encodeBody(f.Payload)
+	}
+	if req := p.Request; req != nil {
+		pj.Request = &requestJSON{
+			Method:     req.Method,
+			Host:       req.Host,
+			RemoteAddr: req.RemoteAddr,
+			Proto:      req.Proto,
+			Header:     req.Header,
+			Trailer:    p.RequestTrailers,
+		}
+		if req.URL != nil {
+			pj.Request.URL = req.URL.String()
+		}
+		pj.Request.Body, pj.Request.BodyEncoding = encodeBody(p.RequestBody)
+	}
+	if resp := p.Response; resp != nil {
+		pj.Response = &responseJSON{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Proto:      resp.Proto,
+			Header:     resp.Header,
+			Trailer:    p.ResponseTrailers,
+		}
+		pj.Response.Body, pj.Response.BodyEncoding = encodeBody(p.ResponseBody)
+	}
+	return json.Marshal(&pj)
+}
+
+// UnmarshalJSON decodes a pair encoded by MarshalJSON.
+func (p *RequestResponsePair) UnmarshalJSON(data []byte) error {
+	var pj pairJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	if pj.Version > pairJSONVersion {
+		return fmt.Errorf("Unsupported pair JSON version %d", pj.Version)
+	}
+	*p = RequestResponsePair{ID: pj.ID, Seq: pj.Seq, StreamID: pj.StreamID, RepeatCount: pj.RepeatCount, ResolvedHost: pj.Resolved}
+	if pj.Timestamp != nil {
+		p.Timestamp = *pj.Timestamp
+	}
+	if pj.ResponseEnd != nil {
+		p.ResponseEnd = *pj.ResponseEnd
+	}
+	if pj.Conn != nil {
+		p.ConnInfo = *pj.Conn
+	}
+	if pj.Malformed != "" {
+		p.markMalformed("%s", pj.Malformed)
+	}
+	if fj := pj.WSFrame; fj != nil {
+		payload, err := decodeBody(fj.Payload, fj.PayloadEncoding)
+		if err != nil {
+			return err
+		}
+		p.WSFrame = &WSFrame{FromClient: fj.FromClient, Opcode: fj.Opcode, Fin: fj.Fin, Payload: payload}
+	}
+	if rj := pj.Request; rj != nil {
+		body, err := decodeBody(rj.Body, rj.BodyEncoding)
+		if err != nil {
+			return err
+		}
+		p.Request, err = buildRequest(rj.Method, rj.URL, rj.Host, rj.RemoteAddr, rj.Proto, rj.Header, body)
+		if err != nil {
+			return err
+		}
+		p.RequestBody = body
+		p.RequestTrailers = rj.Trailer
+		p.Request.Trailer = rj.Trailer
+	}
+	if rj := pj.Response; rj != nil {
+		body, err := decodeBody(rj.Body, rj.BodyEncoding)
+		if err != nil {
+			return err
+		}
+		p.Response = buildResponse(rj.Status, rj.StatusCode, rj.Proto, rj.Header, body, p.Request)
+		p.ResponseBody = body
+		p.ResponseTrailers = rj.Trailer
+		p.Response.Trailer = rj.Trailer
+	}
+	return nil
+}
+
+// buildRequest makes a request from its decoded parts, as if it had been
+// read off the wire
+func buildRequest(method, rawURL, host, remoteAddr, proto string, header http.Header, body []byte) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method:     method,
+		URL:        u,
+		Host:       host,
+		RemoteAddr: remoteAddr,
+		Proto:      proto,
+		Header:     header,
+		Body:       newBodyBuffer(body),
+	}
+	req.ProtoMajor, req.ProtoMinor, _ = http.ParseHTTPVersion(proto)
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// buildResponse makes a response to req from its decoded parts
+func buildResponse(status string, code int, proto string, header http.Header, body []byte, req *http.Request) *http.Response {
+	resp := &http.Response{
+		Status:     status,
+		StatusCode: code,
+		Proto:      proto,
+		Header:     header,
+		Body:       newBodyBuffer(body),
+		Request:    req,
+	}
+	resp.ProtoMajor, resp.ProtoMinor, _ = http.ParseHTTPVersion(proto)
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.ContentLength = int64(len(body))
+	return resp
+}
+
+// encodeBody returns the body as text, base64 encoding it if it is not
+// valid UTF-8, and the encoding used
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// decodeBody reverses encodeBody
+func decodeBody(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		if text == "" {
+			return nil, nil
+		}
+		return []byte(text), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return nil, fmt.Errorf("Unknown body encoding: %s", encoding)
+}
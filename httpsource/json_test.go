@@ -0,0 +1,55 @@
+package httpsource
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPairJSONRoundTrip(t *testing.T) {
+	pair := testPair(t,
+		"POST /submit?a=1 HTTP/1.1\r\nHost: example.com\r\nX-Test: a\r\nX-Test: b\r\n"+
+			"Content-Length: 4\r\n\r\ndata",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n"+
+			"Content-Length: 2\r\n\r\n\xff\xfe")
+	pair.Seq = 42
+	pair.Request.RemoteAddr = "10.0.0.1:51234"
+	buf, err := json.Marshal(pair)
+	fatalIfErr(t, err)
+	if !strings.Contains(string(buf), `"bodyEncoding":"base64"`) {
+		t.Errorf("Expected base64 response body in %s\n", buf)
+	}
+
+	var decoded RequestResponsePair
+	fatalIfErr(t, json.Unmarshal(buf, &decoded))
+	if decoded.Request.Method != "POST" || decoded.Request.URL.String() != "/submit?a=1" ||
+		decoded.Request.Host != "example.com" || decoded.Request.ProtoMinor != 1 ||
+		decoded.Request.RemoteAddr != "10.0.0.1:51234" {
+		t.Errorf("Request line mismatch: %+v\n", decoded.Request)
+	}
+	if !reflect.DeepEqual(decoded.Request.Header, pair.Request.Header) {
+		t.Errorf("Request headers mismatch: %v vs %v\n", decoded.Request.Header, pair.Request.Header)
+	}
+	if !reflect.DeepEqual(decoded.Response.Header, pair.Response.Header) {
+		t.Errorf("Response headers mismatch: %v vs %v\n", decoded.Response.Header, pair.Response.Header)
+	}
+	if decoded.Response.StatusCode != 200 || decoded.Response.Status != "200 OK" {
+		t.Errorf("Status mismatch: %d %s\n", decoded.Response.StatusCode, decoded.Response.Status)
+	}
+	if !bytes.Equal(decoded.RequestBody, pair.RequestBody) ||
+		!bytes.Equal(decoded.ResponseBody, pair.ResponseBody) {
+		t.Errorf("Body mismatch: %q %q\n", decoded.RequestBody, decoded.ResponseBody)
+	}
+	if decoded.Seq != 42 {
+		t.Errorf("Expected seq 42, got %d\n", decoded.Seq)
+	}
+}
+
+func TestPairJSONVersion(t *testing.T) {
+	var p RequestResponsePair
+	if err := json.Unmarshal([]byte(`{"version": 99}`), &p); err == nil {
+		t.Error("Expected error for unknown version.\n")
+	}
+}
@@ -0,0 +1,68 @@
+package httpsource
+
+import (
+	"fmt"
+	"log"
+)
+
+// LevelLogger is a logging interface that knows how severe each message is,
+// for adapting to leveled loggers such as slog or zap.  The mux logs drops
+// as warnings and its lifecycle, including shutdown, as info.
+type LevelLogger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// DropLogger may be implemented by a LevelLogger to log drops as structured
+// events, with the output's name, its channel's depth and capacity, and the
+// Seq of the dropped pair, rather than through Warnf.
+type DropLogger interface {
+	LogDrop(output string, depth, capacity int, seq uint64)
+}
+
+// printfLevelLogger logs every level through a Printf logger
+type printfLevelLogger struct {
+	Logger
+}
+
+func (l printfLevelLogger) Debugf(format string, v ...interface{}) { l.printf(format, v...) }
+func (l printfLevelLogger) Infof(format string, v ...interface{})  { l.printf(format, v...) }
+func (l printfLevelLogger) Warnf(format string, v ...interface{})  { l.printf(format, v...) }
+func (l printfLevelLogger) Errorf(format string, v ...interface{}) { l.printf(format, v...) }
+
+// printf logs a message, crediting a *log.Logger's file and line to the
+// caller of the level method rather than to this file
+func (l printfLevelLogger) printf(format string, v ...interface{}) {
+	if std, ok := l.Logger.(*log.Logger); ok {
+		std.Output(3, fmt.Sprintf(format, v...))
+		return
+	}
+	l.Printf(format, v...)
+}
+
+// log returns the mux's LevelLogger, or else its Logger, used as a
+// LevelLogger if it is one and wrapped if not
+func (m *PairMux) log() LevelLogger {
+	if m.LevelLogger != nil {
+		return m.LevelLogger
+	}
+	if ll, ok := m.Logger.(LevelLogger); ok {
+		return ll
+	}
+	if m.Logger == nil {
+		return printfLevelLogger{logger}
+	}
+	return printfLevelLogger{m.Logger}
+}
+
+// logDrop logs that item could not be delivered to o
+func (m *PairMux) logDrop(o output, item *RequestResponsePair) {
+	l := m.log()
+	if dl, ok := l.(DropLogger); ok {
+		dl.LogDrop(o.name, len(o.dst), cap(o.dst), item.Seq)
+		return
+	}
+	l.Warnf("PairMux dropped pair for output %s, %d of %d buffered.\n", o.name, len(o.dst), cap(o.dst))
+}
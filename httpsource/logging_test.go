@@ -0,0 +1,91 @@
+package httpsource
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type levelLogger struct {
+	lines map[string][]string
+}
+
+func (l *levelLogger) log(level, format string, v ...interface{}) {
+	l.lines[level] = append(l.lines[level], fmt.Sprintf(format, v...))
+}
+
+func (l *levelLogger) Debugf(format string, v ...interface{}) { l.log("debug", format, v...) }
+func (l *levelLogger) Infof(format string, v ...interface{})  { l.log("info", format, v...) }
+func (l *levelLogger) Warnf(format string, v ...interface{})  { l.log("warn", format, v...) }
+func (l *levelLogger) Errorf(format string, v ...interface{}) { l.log("error", format, v...) }
+
+func TestMuxLevelLogger(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	plain := &recordingLogger{}
+	l := &levelLogger{lines: make(map[string][]string)}
+	m := NewPairMux(src, WithNonBlocking(), WithLogger(plain), WithLevelLogger(l))
+	m.AddOutput("full", 1)
+	src <- &RequestResponsePair{}
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	m.RunStep()
+	close(src)
+	m.Start()
+	<-m.Finished
+	if len(l.lines["warn"]) != 1 || !strings.Contains(l.lines["warn"][0], "full, 1 of 1 buffered") {
+		t.Errorf("Expected a drop warning with the depth, got %v\n", l.lines["warn"])
+	}
+	found := false
+	for _, line := range l.lines["info"] {
+		if strings.Contains(line, "2 pairs processed, 1 written, 1 dropped") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected shutdown counts at info, got %v\n", l.lines["info"])
+	}
+	if len(plain.lines) != 0 {
+		t.Errorf("Expected nothing logged to the Printf logger, got %v\n", plain.lines)
+	}
+}
+
+func TestMuxLevelLoggerFallback(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, 0)
+	l := &levelLogger{lines: make(map[string][]string)}
+	m.Logger = &struct {
+		*recordingLogger
+		*levelLogger
+	}{&recordingLogger{}, l}
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if len(l.lines["warn"]) != 1 {
+		t.Errorf("Expected a Logger that is also a LevelLogger to be used as one, got %v\n", l.lines)
+	}
+}
+
+// dropLogger is a LevelLogger that also logs drops as events
+type dropLogger struct {
+	levelLogger
+	drops []string
+}
+
+func (l *dropLogger) LogDrop(output string, depth, capacity int, seq uint64) {
+	l.drops = append(l.drops, fmt.Sprintf("%s %d/%d #%d", output, depth, capacity, seq))
+}
+
+func TestMuxDropLogger(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	l := &dropLogger{levelLogger: levelLogger{lines: make(map[string][]string)}}
+	m := NewPairMux(src, WithNonBlocking(), WithLevelLogger(l))
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if len(l.drops) != 1 || l.drops[0] != "full 0/0 #1" {
+		t.Errorf("Expected a structured drop, got %v\n", l.drops)
+	}
+	if len(l.lines["warn"]) != 0 {
+		t.Errorf("Expected no formatted drop warning, got %v\n", l.lines["warn"])
+	}
+}
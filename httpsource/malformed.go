@@ -0,0 +1,19 @@
+package httpsource
+
+import (
+	"fmt"
+)
+
+// IsMalformed accepts pairs that could only be partly parsed.
+func IsMalformed() FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.Malformed
+	}
+}
+
+// markMalformed flags the pair as malformed, returning it
+func (p *RequestResponsePair) markMalformed(format string, args ...interface{}) *RequestResponsePair {
+	p.Malformed = true
+	p.MalformedReason = fmt.Sprintf(format, args...)
+	return p
+}
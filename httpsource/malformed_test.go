@@ -0,0 +1,72 @@
+package httpsource
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func readTestConnection(rawReq, rawResp string) *HTTPConnection {
+	conn := &HTTPConnection{}
+	conn.readConnection(bufio.NewReader(strings.NewReader(rawReq)),
+		bufio.NewReader(strings.NewReader(rawResp)))
+	return conn
+}
+
+func TestMalformedPairs(t *testing.T) {
+	tests := []struct {
+		name, req, resp, reason string
+		body                    string
+	}{
+		{"bad chunking", "GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\nzz\r\n", "Incomplete response body", "hello"},
+		{"short body", "GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhalf", "Incomplete response body", "half"},
+		{"missing status line", "GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+			"garbage\r\n\r\n", "Bad response", ""},
+		{"no response", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "", "Missing response", ""},
+		{"short request body", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nab",
+			"", "Incomplete request body", ""},
+	}
+	for _, test := range tests {
+		conn := readTestConnection(test.req, test.resp)
+		if len(conn.Pairs) != 1 {
+			t.Errorf("%s: expected 1 pair, got %d\n", test.name, len(conn.Pairs))
+			continue
+		}
+		p := conn.Pairs[0]
+		if !IsMalformed()(p) || !strings.HasPrefix(p.MalformedReason, test.reason) {
+			t.Errorf("%s: expected reason %q, got %v %q\n", test.name, test.reason, p.Malformed, p.MalformedReason)
+		}
+		if p.Request == nil || p.Request.Host != "a" {
+			t.Errorf("%s: expected parsed request to be kept\n", test.name)
+		}
+		if string(p.ResponseBody) != test.body {
+			t.Errorf("%s: expected partial body %q, got %q\n", test.name, test.body, p.ResponseBody)
+		}
+	}
+
+	conn := readTestConnection("GET / HTTP/1.1\r\nHost: a\r\n\r\n", "HTTP/1.1 204 No Content\r\n\r\n")
+	if len(conn.Pairs) != 1 || IsMalformed()(conn.Pairs[0]) {
+		t.Error("Expected a well formed pair.\n")
+	}
+}
+
+func TestMalformedPairJSON(t *testing.T) {
+	pair, err := ParsePair(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"),
+		strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhalf"))
+	if err == nil || pair == nil || !pair.Malformed || string(pair.ResponseBody) != "half" {
+		t.Fatalf("Expected partial malformed pair with error, got %v %v\n", pair, err)
+	}
+	buf, err := json.Marshal(pair)
+	fatalIfErr(t, err)
+	decoded := &RequestResponsePair{}
+	fatalIfErr(t, json.Unmarshal(buf, decoded))
+	if !decoded.Malformed || decoded.MalformedReason != pair.MalformedReason {
+		t.Errorf("Malformed flag not round tripped: %s\n", buf)
+	}
+	if c := pair.Clone(); !c.Malformed || c.MalformedReason != pair.MalformedReason {
+		t.Error("Expected Clone to keep the malformed flag.\n")
+	}
+}
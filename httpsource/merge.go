@@ -0,0 +1,84 @@
+package httpsource
+
+import (
+	"sync"
+)
+
+// Merge combines several pair sources into a single channel, which is closed
+// once all of the sources have been closed and drained.  No ordering is
+// guaranteed between sources.
+func Merge(srcs ...<-chan *RequestResponsePair) <-chan *RequestResponsePair {
+	size := 0
+	for _, src := range srcs {
+		size += cap(src)
+	}
+	out := make(chan *RequestResponsePair, size)
+	var wg sync.WaitGroup
+	wg.Add(len(srcs))
+	for _, src := range srcs {
+		go func(src <-chan *RequestResponsePair) {
+			defer wg.Done()
+			for pair := range src {
+				out <- pair
+			}
+		}(src)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// MergeOrdered combines several pair sources, each of which must already be
+// sorted by Timestamp, into a single channel in global timestamp order.
+// Since the next pair can't be chosen until every open source has one ready
+// or has been closed, a source that stalls holds up the merge.  Pairs with
+// no Timestamp are taken to come after any with one, so they end up at the
+// end of the output.  Ties go to the earlier source in srcs.  The output is
+// closed once all of the sources have been closed and drained.
+func MergeOrdered(srcs ...<-chan *RequestResponsePair) <-chan *RequestResponsePair {
+	size := 0
+	for _, src := range srcs {
+		size += cap(src)
+	}
+	out := make(chan *RequestResponsePair, size)
+	go func() {
+		defer close(out)
+		open := append([]<-chan *RequestResponsePair(nil), srcs...)
+		heads := make([]*RequestResponsePair, len(srcs))
+		for {
+			for i, src := range open {
+				if src == nil || heads[i] != nil {
+					continue
+				}
+				if pair, ok := <-src; ok {
+					heads[i] = pair
+				} else {
+					open[i] = nil
+				}
+			}
+			next := -1
+			for i, pair := range heads {
+				if pair != nil && (next < 0 || pairBefore(pair, heads[next])) {
+					next = i
+				}
+			}
+			if next < 0 {
+				return
+			}
+			out <- heads[next]
+			heads[next] = nil
+		}
+	}()
+	return out
+}
+
+// pairBefore reports whether a was strictly earlier than b, with pairs that
+// have no timestamp coming last
+func pairBefore(a, b *RequestResponsePair) bool {
+	if a.Timestamp.IsZero() {
+		return false
+	}
+	return b.Timestamp.IsZero() || a.Timestamp.Before(b.Timestamp)
+}
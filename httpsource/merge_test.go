@@ -0,0 +1,72 @@
+package httpsource
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	a := make(chan *RequestResponsePair, 2)
+	b := make(chan *RequestResponsePair, 1)
+	a <- &RequestResponsePair{}
+	a <- &RequestResponsePair{}
+	b <- &RequestResponsePair{}
+	close(a)
+	close(b)
+	count := 0
+	for _ = range Merge(a, b) {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 merged pairs, got %d.\n", count)
+	}
+}
+
+func TestMergeNoSources(t *testing.T) {
+	if _, ok := <-Merge(); ok {
+		t.Error("Expected closed channel from empty Merge.\n")
+	}
+}
+
+func TestMergeOrdered(t *testing.T) {
+	base := time.Unix(1000, 0)
+	at := func(sec int, name string) *RequestResponsePair {
+		p := &RequestResponsePair{Request: &http.Request{Method: name}}
+		if sec >= 0 {
+			p.Timestamp = base.Add(time.Duration(sec) * time.Second)
+		}
+		return p
+	}
+	a := make(chan *RequestResponsePair, 4)
+	a <- at(1, "a1")
+	a <- at(4, "a4")
+	a <- at(5, "a5")
+	a <- at(-1, "a-none")
+	close(a)
+	b := make(chan *RequestResponsePair)
+	go func() {
+		b <- at(2, "b2")
+		b <- at(4, "b4")
+		// Closed well after a has been drained
+		time.Sleep(10 * time.Millisecond)
+		close(b)
+	}()
+	c := make(chan *RequestResponsePair, 1)
+	c <- at(3, "c3")
+	close(c)
+
+	var got []string
+	for pair := range MergeOrdered(a, b, c) {
+		got = append(got, pair.Request.Method)
+	}
+	want := []string{"a1", "b2", "c3", "a4", "b4", "a5", "a-none"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v\n", want, got)
+	}
+
+	if _, ok := <-MergeOrdered(); ok {
+		t.Error("Expected closed channel from empty MergeOrdered.\n")
+	}
+}
@@ -1,57 +1,619 @@
 package httpsource
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type output struct {
-	name string
-	dst  chan<- *RequestResponsePair
+	name  string
+	dst   chan *RequestResponsePair
+	stats *OutputStats
+	// lastWrite is the UnixNano time of the last successful write, or zero
+	lastWrite *int64
+	removed   chan struct{}
+	stopped   <-chan struct{}
+	// filter, if not nil, restricts which pairs are written to this output
+	filter FilterFunc
+	// mapper, if not nil, transforms a private copy of each pair before it is
+	// written to this output
+	mapper func(*RequestResponsePair) *RequestResponsePair
+	// writer, if not nil, overrides the mux's writer for this output
+	writer outputWriter
+	// skip, if not nil, reports whether to pass over a pair without writing
+	// it, which isn't counted as a drop
+	skip func(m *PairMux, o output) bool
+	// observer outputs see every pair, even on muxes that route each pair to
+	// a single output, and are never chosen as that output
+	observer bool
+}
+
+// OutputStats counts the pairs delivered to and dropped for a single output.
+type OutputStats struct {
+	Written uint64
+	Dropped uint64
+	// Evicted counts buffered pairs discarded to make room for newer ones
+	Evicted uint64
+	// RateLimited counts the drops caused by an output's rate limit
+	RateLimited uint64
+	// BreakerSkipped counts the pairs an open circuit breaker kept from the
+	// output, which aren't counted as dropped
+	BreakerSkipped uint64
+	// Retries counts the extra write attempts made after timeouts
+	Retries uint64
+	// Breaker is the state of the output's circuit breaker, if it has one
+	Breaker BreakerState
+}
+
+// How often shutdown checks whether outputs have drained
+const drainPollInterval = 10 * time.Millisecond
+
+// The most fan-out workers started when FanoutWorkers is not set
+const maxFanoutWorkers = 64
+
+// fanoutJob is a single output write handed to a fan-out worker
+type fanoutJob struct {
+	o    output
+	item *RequestResponsePair
+}
+
+// outputWriter attempts to write item to o, returning false if it was dropped
+type outputWriter func(m *PairMux, o output, item *RequestResponsePair) bool
+
+// OutputDepth is the number of pairs buffered in an output channel, and the
+// channel's capacity.
+type OutputDepth struct {
+	Len, Cap int
+}
+
+// DroppedPair is a pair that could not be delivered to the named output.
+type DroppedPair struct {
+	Output string
+	Pair   *RequestResponsePair
+}
+
+// Logger is the minimal logging interface used by PairMux.  *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
 }
 
 // PairMux reads from a single channel and distributes it to
 // many child channels in parallel.
 type PairMux struct {
 	Finished chan bool
+	Logger   Logger
+	// LevelLogger, if set, is used instead of Logger, so that messages are
+	// logged at their level
+	LevelLogger LevelLogger
+	// Clock is used for write timeouts
+	Clock Clock
+	// OnDrop, if set, is called whenever a pair could not be delivered to an
+	// output.  It is called from the mux's writer goroutines, possibly
+	// concurrently, and must not block.
+	OnDrop func(outputName string, pair *RequestResponsePair)
+	// CopyPerOutput gives every output after the first its own Clone of each
+	// pair, so consumers may safely modify the pairs they receive.
+	CopyPerOutput bool
+	// DrainTimeout, if set, makes shutdown wait up to this long for
+	// consumers to empty the output channels before closing them.
+	DrainTimeout time.Duration
+	// FairDelivery writes to blocking outputs from a single goroutine,
+	// serving whichever output is ready first until all have the pair,
+	// rather than using the worker pool.
+	FairDelivery bool
+	// MaxPairs, if > 0, stops the mux once it has processed this many pairs
+	// in total.  Later pairs are left in the source, unless
+	// DrainAfterMaxPairs is set, in which case they are read and discarded
+	// until the source is closed so upstream never blocks.
+	MaxPairs           int
+	DrainAfterMaxPairs bool
+	// FanoutWorkers is the number of goroutines writing to outputs in
+	// parallel.  If zero, one worker per output is used, up to 64.  Set it
+	// before the mux is started.
+	FanoutWorkers int
+	// TimeoutRetries is how many more times a write that timed out is
+	// attempted before the pair is dropped, waiting TimeoutBackoff between
+	// attempts.  It applies to outputs with a write timeout.
+	TimeoutRetries int
+	TimeoutBackoff time.Duration
+	// SendSentinelOnClose sends a pair carrying a Sentinel to each output
+	// just before it is closed on shutdown, so consumers get the final
+	// stats and the reason for stopping.  Sentinels bypass output filters
+	// and mappers.
+	SendSentinelOnClose bool
+
 	outputs  []output
 	lock     sync.Mutex
+	stepLock sync.Mutex
 	src      <-chan *RequestResponsePair
-	blocking bool
-	timeout  time.Duration
-	writer   func(output, *RequestResponsePair)
+	policy   DropPolicy
+	// writer implements policy
+	writer   outputWriter
 	started  bool
+	finished bool
+	// minBuf is the smallest channel buffer given to new outputs
+	minBuf int
+	// roundRobin delivers each pair to a single output, rotating through
+	// them, rather than broadcasting to all outputs.
+	roundRobin bool
+	next       int
+	// hashKey, if set, routes each pair to a single output chosen by
+	// consistent hashing of its key.  ring is rebuilt when outputs change.
+	hashKey  func(*RequestResponsePair) string
+	ring     *hashRing
+	stop     chan struct{}
+	stopOnce sync.Once
+	// drain is closed by Drain, stopping the mux reading src while letting
+	// writes complete
+	drain     chan struct{}
+	drainOnce sync.Once
+	// drainLeft and drainWritten are the pairs left in the outputs, and the
+	// total written to them, when shutdown finished waiting and before any
+	// sentinels were sent
+	drainLeft    int
+	drainWritten uint64
+	// exited is closed on shutdown
+	exited chan struct{}
+	// flush carries Flush requests to the running mux, which closes the
+	// given channel once it has processed the items then in the source
+	flush chan chan struct{}
+	// paused stops the mux reading from src.  pauseChanged is closed and
+	// replaced whenever paused changes, waking a waiting runStep.
+	paused       bool
+	pauseChanged chan struct{}
+	// taps are called with every pair before it is delivered
+	taps []func(*RequestResponsePair)
+	// seq is the Seq of the last pair to enter the mux.  It is only changed
+	// holding stepLock, atomically, so it may also be loaded without it.
+	seq uint64
+	// limited is set once MaxPairs has stopped the mux
+	limited bool
+	// throughput counts the pairs processed, for Throughput
+	throughput *throughputCounter
+	// deadLetters, if set, receives every dropped or evicted pair
+	deadLetters chan DroppedPair
+	// work feeds the fan-out worker pool, which is started on first use
+	work     chan fanoutJob
+	workers  int
+	fanoutWG sync.WaitGroup
+}
+
+// NewPairMux creates a new PairMux reading from src, configured by opts.
+// Without options the mux blocks on writes to full channels.
+func NewPairMux(src <-chan *RequestResponsePair, opts ...Option) PairMux {
+	m := PairMux{src: src, policy: Block, Finished: make(chan bool, 1), Logger: logger, Clock: RealClock{}}
+	m.stop = make(chan struct{})
+	m.drain = make(chan struct{})
+	m.exited = make(chan struct{})
+	m.flush = make(chan chan struct{})
+	m.throughput = newThroughputCounter()
+	for _, opt := range opts {
+		opt(&m)
+	}
+	m.writer = m.policy.writer()
+	if m.policy == DropOldest && m.minBuf < 1 {
+		// There is nothing to discard from an unbuffered channel
+		m.minBuf = 1
+	}
+	return m
 }
 
 // NewBlockingPairMux creates a new PairMux that blocks on writes to full
 // channels.
 func NewBlockingPairMux(src <-chan *RequestResponsePair) PairMux {
-	m := PairMux{src: src, blocking: true, writer: blockingOutputWriter, Finished: make(chan bool, 1)}
-	return m
+	return NewPairMux(src, WithBlocking())
+}
+
+// NewRoundRobinMux creates a new PairMux that delivers each pair to exactly
+// one output, rotating through the outputs.  Writes block on full channels.
+func NewRoundRobinMux(src <-chan *RequestResponsePair) PairMux {
+	return NewPairMux(src, WithRoundRobin())
+}
+
+// NewHashMux creates a new PairMux that delivers each pair to exactly one
+// output, chosen by consistent hashing of keyFn(pair).  Pairs with the same
+// key always go to the same output while the set of outputs is unchanged,
+// and adding or removing an output only remaps a fraction of the keys.
+// Writes block on full channels.
+func NewHashMux(src <-chan *RequestResponsePair, keyFn func(*RequestResponsePair) string) PairMux {
+	return NewPairMux(src, WithHashKey(keyFn))
+}
+
+// NewDropOldestPairMux creates a new PairMux that never blocks, instead
+// discarding the oldest buffered pair of a full output to make room for the
+// newest.  Outputs are given a buffer of at least bufHint (minimum 1), as
+// there is nothing to discard from an unbuffered channel.
+func NewDropOldestPairMux(src <-chan *RequestResponsePair, bufHint int) PairMux {
+	return NewPairMux(src, WithDropOldest(bufHint))
 }
 
 // NewNonBlockingPairMux creates new PairMux that doesn't block on writes.
 func NewNonBlockingPairMux(src <-chan *RequestResponsePair, timeout time.Duration) PairMux {
-	m := PairMux{src: src, blocking: false, timeout: timeout, Finished: make(chan bool, 1)}
-	if timeout != 0 {
-		m.writer = makeTimeoutOutputWriter(timeout)
-	} else {
-		m.writer = nonBlockingOutputWriter
+	return NewPairMux(src, WithTimeout(timeout))
+}
+
+// AddOutput adds an output with name 'name' and channel buffer size 'buf'.
+// Returns an error if an output called 'name' already exists.
+func (m *PairMux) AddOutput(name string, buf int) (<-chan *RequestResponsePair, error) {
+	return m.AddFilteredOutput(name, buf, nil)
+}
+
+// MustAddOutput is like AddOutput, but panics if the name is already in use.
+func (m *PairMux) MustAddOutput(name string, buf int) <-chan *RequestResponsePair {
+	c, err := m.AddOutput(name, buf)
+	if err != nil {
+		panic(err)
 	}
-	return m
+	return c
 }
 
-// AddOutput adds an output with name 'name' and channel buffer size 'buf'
-func (m *PairMux) AddOutput(name string, buf int) <-chan *RequestResponsePair {
-	c := make(chan *RequestResponsePair, buf)
+// AddFilteredOutput adds an output that only receives pairs for which pred
+// returns true.  A nil pred accepts every pair.
+func (m *PairMux) AddFilteredOutput(name string, buf int, pred FilterFunc) (<-chan *RequestResponsePair, error) {
+	c := m.makeOutputChan(buf)
+	return c, m.addOutput(output{name: name, dst: c, filter: pred})
+}
+
+// AddOutputWithTimeout adds an output whose writes time out after timeout,
+// regardless of the mux's own write strategy.
+func (m *PairMux) AddOutputWithTimeout(name string, buf int, timeout time.Duration) (<-chan *RequestResponsePair, error) {
+	c := m.makeOutputChan(buf)
+	return c, m.addOutput(output{name: name, dst: c, writer: makeTimeoutOutputWriter(timeout)})
+}
+
+// AddRateLimitedOutput adds an output that receives at most perSecond pairs
+// per second.  Pairs over the limit wait for the limiter on a blocking mux,
+// and are dropped otherwise.  Returns an error if perSecond isn't positive.
+func (m *PairMux) AddRateLimitedOutput(name string, buf int, perSecond float64) (<-chan *RequestResponsePair, error) {
+	if perSecond <= 0 {
+		return nil, fmt.Errorf("PairMux output %s needs a positive rate, got %v", name, perSecond)
+	}
+	limiter := rate.NewLimiter(rate.Limit(perSecond), 1)
+	c := m.makeOutputChan(buf)
+	err := m.addOutput(output{name: name, dst: c, writer: func(m *PairMux, o output, item *RequestResponsePair) bool {
+		if m.policy != Block {
+			if !limiter.Allow() {
+				atomic.AddUint64(&o.stats.RateLimited, 1)
+				return false
+			}
+			return m.writer(m, o, item)
+		}
+		r := limiter.Reserve()
+		wait := time.NewTimer(r.Delay())
+		defer wait.Stop()
+		select {
+		case <-wait.C:
+		case <-o.removed:
+			r.Cancel()
+			return false
+		case <-o.stopped:
+			r.Cancel()
+			return false
+		}
+		return m.writer(m, o, item)
+	}})
+	return c, err
+}
+
+// AddSampledOutput adds an output that receives every nth pair, starting
+// with the first.  Sampling is deterministic, not random.  Panics if n < 1.
+func (m *PairMux) AddSampledOutput(name string, buf int, n int) (<-chan *RequestResponsePair, error) {
+	if n < 1 {
+		panic("AddSampledOutput requires n >= 1")
+	}
+	// Filters are only called from RunStep under stepLock, so the counter
+	// needs no further synchronization.
+	seen := 0
+	return m.AddFilteredOutput(name, buf, func(_ *RequestResponsePair) bool {
+		sample := seen%n == 0
+		seen++
+		return sample
+	})
+}
+
+// AddMappedOutput adds an output that receives the result of fn applied to
+// each pair.  fn is given its own Clone of the pair, so it may modify it in
+// place.  If fn returns nil, nothing is written for that pair.
+func (m *PairMux) AddMappedOutput(name string, buf int, fn func(*RequestResponsePair) *RequestResponsePair) (<-chan *RequestResponsePair, error) {
+	c := m.makeOutputChan(buf)
+	return c, m.addOutput(output{name: name, dst: c, mapper: fn})
+}
+
+// AddCounter adds an output that counts the pairs for which pred returns
+// true, rather than delivering them anywhere.  A nil pred counts every
+// pair.  The returned counter must be read with atomic.LoadInt64.  Unlike
+// other outputs, a counter sees every pair on round-robin and hash muxes
+// too.  It can be detached with RemoveOutput.
+func (m *PairMux) AddCounter(name string, pred FilterFunc) (*int64, error) {
+	count := new(int64)
+	err := m.addOutput(output{name: name, dst: make(chan *RequestResponsePair), filter: pred, observer: true,
+		writer: func(_ *PairMux, _ output, _ *RequestResponsePair) bool {
+			atomic.AddInt64(count, 1)
+			return true
+		}})
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// makeOutputChan creates an output channel, respecting minBuf
+func (m *PairMux) makeOutputChan(buf int) chan *RequestResponsePair {
+	if buf < m.minBuf {
+		buf = m.minBuf
+	}
+	return make(chan *RequestResponsePair, buf)
+}
+
+// addOutput fills in the bookkeeping fields of o and registers it, unless
+// the name is taken
+func (m *PairMux) addOutput(o output) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.outputs = append(m.outputs, output{name, c})
-	return c
+	for _, existing := range m.outputs {
+		if existing.name == o.name {
+			return fmt.Errorf("PairMux already has an output named %s", o.name)
+		}
+	}
+	o.stats = &OutputStats{}
+	o.lastWrite = new(int64)
+	o.removed = make(chan struct{})
+	o.stopped = m.stop
+	m.outputs = append(m.outputs, o)
+	m.ring = nil
+	return nil
+}
+
+// RemoveOutput detaches the output named 'name' and closes its channel.
+// Returns false if no such output exists.
+func (m *PairMux) RemoveOutput(name string) bool {
+	found, _ := m.detachOutput(name)
+	return found
+}
+
+// CloseOutput detaches the output named 'name' and closes its channel, for
+// a consumer that is done reading while the rest of the mux keeps running.
+// Returns an error if there is no such output, as when it was already
+// closed, or if the mux has finished and so closed it already.
+func (m *PairMux) CloseOutput(name string) error {
+	found, closed := m.detachOutput(name)
+	if !found {
+		return fmt.Errorf("PairMux has no output named %s", name)
+	}
+	if !closed {
+		return fmt.Errorf("PairMux output %s was already closed", name)
+	}
+	return nil
+}
+
+// detachOutput removes the named output, closing its channel unless the
+// mux has finished and closed it already.  Reports whether the output was
+// found and whether it was closed here.
+func (m *PairMux) detachOutput(name string) (found, closed bool) {
+	var removed output
+	m.lock.Lock()
+	for i, o := range m.outputs {
+		if o.name == name {
+			removed = o
+			found = true
+			m.outputs = append(m.outputs[:i], m.outputs[i+1:]...)
+			m.ring = nil
+			break
+		}
+	}
+	finished := m.finished
+	m.lock.Unlock()
+	if !found || finished {
+		return found, false
+	}
+	// Abort any write in progress, then wait for the step to finish before
+	// closing so RunStep never writes to a closed channel.
+	close(removed.removed)
+	m.stepLock.Lock()
+	defer m.stepLock.Unlock()
+	close(removed.dst)
+	return true, true
+}
+
+// Output returns the channel for the output named 'name', or nil if there is
+// no such output.
+func (m *PairMux) Output(name string) <-chan *RequestResponsePair {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, o := range m.outputs {
+		if o.name == name {
+			return o.dst
+		}
+	}
+	return nil
+}
+
+// Reset prepares a finished PairMux to be started again reading from src.
+// The configured outputs are kept, but as their channels were closed when
+// the mux finished, each gets a new channel which must be retrieved with
+// Output.  Pair sequence numbers, the MaxPairs count and Throughput start
+// again from zero.  Returns an error if the mux is still running.
+func (m *PairMux) Reset(src <-chan *RequestResponsePair) error {
+	m.stepLock.Lock()
+	defer m.stepLock.Unlock()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.started && !m.finished {
+		return errors.New("PairMux is still running")
+	}
+	m.src = src
+	if !m.finished {
+		// Never started, so outputs are still open
+		return nil
+	}
+	m.started = false
+	m.finished = false
+	m.Finished = make(chan bool, 1)
+	m.stop = make(chan struct{})
+	m.drain = make(chan struct{})
+	m.exited = make(chan struct{})
+	m.stopOnce = sync.Once{}
+	m.drainOnce = sync.Once{}
+	// Start counting afresh, so MaxPairs applies to each run
+	atomic.StoreUint64(&m.seq, 0)
+	m.limited = false
+	m.throughput = newThroughputCounter()
+	for i, o := range m.outputs {
+		m.outputs[i].dst = make(chan *RequestResponsePair, cap(o.dst))
+		m.outputs[i].removed = make(chan struct{})
+		m.outputs[i].stopped = m.stop
+	}
+	return nil
+}
+
+// Route returns the key of pair and the name of the output it would be sent
+// to by a mux created with NewHashMux.  Returns empty strings for other muxes
+// or when there are no outputs.
+func (m *PairMux) Route(pair *RequestResponsePair) (key, output string) {
+	if m.hashKey == nil {
+		return "", ""
+	}
+	key = m.hashKey(pair)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return key, m.hashOutput(key).name
+}
+
+// hashOutput finds the output for key.  Must be called with the lock held.
+func (m *PairMux) hashOutput(key string) output {
+	if m.ring == nil {
+		names := make([]string, 0, len(m.outputs))
+		for _, o := range m.outputs {
+			if !o.observer {
+				names = append(names, o.name)
+			}
+		}
+		m.ring = newHashRing(names)
+	}
+	name := m.ring.lookup(key)
+	for _, o := range m.outputs {
+		if o.name == name {
+			return o
+		}
+	}
+	return output{}
+}
+
+// Stats returns a snapshot of the write and drop counters for each output,
+// keyed by output name.
+func (m *PairMux) Stats() map[string]OutputStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := make(map[string]OutputStats, len(m.outputs))
+	for _, o := range m.outputs {
+		stats[o.name] = o.stats.snapshot()
+	}
+	return stats
+}
+
+// snapshot reads the counters atomically
+func (s *OutputStats) snapshot() OutputStats {
+	return OutputStats{
+		Written:        atomic.LoadUint64(&s.Written),
+		Dropped:        atomic.LoadUint64(&s.Dropped),
+		Evicted:        atomic.LoadUint64(&s.Evicted),
+		RateLimited:    atomic.LoadUint64(&s.RateLimited),
+		BreakerSkipped: atomic.LoadUint64(&s.BreakerSkipped),
+		Retries:        atomic.LoadUint64(&s.Retries),
+		Breaker:        BreakerState(atomic.LoadInt32((*int32)(&s.Breaker))),
+	}
+}
+
+// OutputDepths reports how full each output channel is, keyed by output
+// name.  An output that stays near capacity has a slow consumer.
+func (m *PairMux) OutputDepths() map[string]OutputDepth {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	depths := make(map[string]OutputDepth, len(m.outputs))
+	for _, o := range m.outputs {
+		depths[o.name] = OutputDepth{len(o.dst), cap(o.dst)}
+	}
+	return depths
+}
+
+// Pending returns the number of pairs buffered in the source channel plus
+// those buffered in the output channels, as a rough measure of the work left
+// before a drain completes.  It is a best-effort snapshot: pairs move while
+// it is counted, and a pair being written to an output is not included.
+func (m *PairMux) Pending() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pending := len(m.src)
+	for _, o := range m.outputs {
+		pending += len(o.dst)
+	}
+	return pending
+}
+
+// OutputActivity returns the time of the last successful write to each
+// output, keyed by output name, or the zero time for outputs never written
+// to.  An output that has gone quiet while its channel is not full has
+// stopped being fed, while one that is full has a stalled reader.
+func (m *PairMux) OutputActivity() map[string]time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	activity := make(map[string]time.Time, len(m.outputs))
+	for _, o := range m.outputs {
+		var last time.Time
+		if ns := atomic.LoadInt64(o.lastWrite); ns != 0 {
+			last = time.Unix(0, ns)
+		}
+		activity[o.name] = last
+	}
+	return activity
+}
+
+// Tap registers fn to be called with every pair that enters the mux, before
+// it is written to any output.  Taps are called in the order registered, on
+// the mux's goroutine, so they hold up delivery and must be fast and never
+// block; hand longer work off to another goroutine.  Taps must not modify
+// the pair.
+func (m *PairMux) Tap(fn func(*RequestResponsePair)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.taps = append(m.taps, fn)
+}
+
+// Started reports whether the mux has been started, or stopped, so that a
+// further Start would do nothing.
+func (m *PairMux) Started() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.started
+}
+
+// Policy returns what the mux does with pairs for full outputs.
+func (m *PairMux) Policy() DropPolicy {
+	return m.policy
+}
+
+// OutputCount returns the number of outputs attached to the mux.
+func (m *PairMux) OutputCount() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.outputs)
 }
 
 // Start stats the goroutine that will perform the copying.
 func (m *PairMux) Start() {
+	m.StartContext(context.Background())
+}
+
+// StartContext starts the copying goroutine, which shuts down when either
+// the source is closed or ctx is cancelled.
+func (m *PairMux) StartContext(ctx context.Context) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	if m.started {
@@ -59,8 +621,8 @@ func (m *PairMux) Start() {
 	}
 	go func() {
 		for {
-			if !m.RunStep() {
-				m.shutdown()
+			if !m.runStep(ctx) {
+				m.shutdown(m.stopReason(ctx.Err()))
 				return
 			}
 		}
@@ -68,65 +630,631 @@ func (m *PairMux) Start() {
 	m.started = true
 }
 
-func (m *PairMux) shutdown() {
-	logger.Printf("PairMux shutting down, %d channels...\n", len(m.outputs))
+// Stop signals the mux to shut down, closing all outputs and signalling
+// Finished.  Any in-progress writes are abandoned and items still buffered
+// in the source channel are not drained.  Stop may be called more than once
+// and from any goroutine.
+func (m *PairMux) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		m.lock.Lock()
+		started := m.started
+		// Prevent a later Start from running a stopped mux
+		m.started = true
+		m.lock.Unlock()
+		if !started {
+			m.shutdown(StopRequested)
+		}
+	})
+}
+
+// shutdown closes the outputs, giving reason in any sentinels
+func (m *PairMux) shutdown(reason string) {
+	if m.draining() {
+		m.waitDrained()
+	} else if m.DrainTimeout > 0 {
+		m.drainOutputs(m.DrainTimeout)
+	}
+	m.stopWorkers()
+	// Count before the sentinels, which Drain doesn't report
+	m.lock.Lock()
+	m.drainLeft, m.drainWritten = m.delivered()
+	m.lock.Unlock()
+	if m.SendSentinelOnClose {
+		m.sendSentinels(reason)
+	}
+	processed := atomic.LoadUint64(&m.seq)
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	m.finished = true
+	close(m.exited)
+	if m.deadLetters != nil {
+		close(m.deadLetters)
+		m.deadLetters = nil
+	}
+	var written, dropped uint64
 	for _, output := range m.outputs {
+		written += atomic.LoadUint64(&output.stats.Written)
+		dropped += atomic.LoadUint64(&output.stats.Dropped)
+	}
+	m.log().Infof("PairMux shutting down, %d channels, %d pairs processed, %d written, %d dropped...\n",
+		len(m.outputs), processed, written, dropped)
+	for _, output := range m.outputs {
+		if n := len(output.dst); n > 0 {
+			m.log().Infof("PairMux closing output %s with %d items buffered.\n", output.name, n)
+		}
 		close(output.dst)
 	}
 	m.Finished <- true
 }
 
+// drainOutputs waits up to timeout for consumers to empty every output
+func (m *PairMux) drainOutputs(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if m.buffered() == 0 {
+			return
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// waitDrained waits for consumers to empty every output, or for the mux to
+// be stopped
+func (m *PairMux) waitDrained() {
+	for m.buffered() > 0 {
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// buffered returns the number of pairs in the output channels
+func (m *PairMux) buffered() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	for _, output := range m.outputs {
+		n += len(output.dst)
+	}
+	return n
+}
+
+// Drain stops the mux reading from the source, lets any write in progress
+// complete, waits for consumers to empty every output channel and then
+// shuts down as Stop would, closing the outputs.  It returns the number of
+// pairs consumers read from the outputs meanwhile: those buffered when
+// Drain was called plus those written since.  Unlike Stop, no buffered pair
+// is left unread when the outputs close, but Drain blocks for as long as
+// any consumer isn't reading; calling Stop ends the wait early.  Pairs left
+// in the source are not read.  Returns zero if the mux has already
+// finished.
+func (m *PairMux) Drain() int {
+	m.lock.Lock()
+	if m.finished {
+		m.lock.Unlock()
+		return 0
+	}
+	buffered, written := m.delivered()
+	started := m.started
+	// Prevent a later Start from running a drained mux
+	m.started = true
+	exited := m.exited
+	m.lock.Unlock()
+	m.drainOnce.Do(func() {
+		close(m.drain)
+	})
+	if !started {
+		m.shutdown(StopDrained)
+	}
+	<-exited
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return buffered + int(m.drainWritten-written) - m.drainLeft
+}
+
+// delivered returns the number of pairs in the output channels and the
+// number written to them in total, leaving out observers.  The caller must
+// hold the lock.
+func (m *PairMux) delivered() (buffered int, written uint64) {
+	for _, o := range m.outputs {
+		if o.observer {
+			continue
+		}
+		buffered += len(o.dst)
+		written += atomic.LoadUint64(&o.stats.Written)
+	}
+	return buffered, written
+}
+
+// draining reports whether Drain has been called
+func (m *PairMux) draining() bool {
+	select {
+	case <-m.drain:
+		return true
+	default:
+		return false
+	}
+}
+
 // RunStep handles a single item through the mux
 func (m *PairMux) RunStep() bool {
-	item, ok := <-m.src
-	if !ok {
+	return m.runStep(context.Background())
+}
+
+// runStep handles a single item, returning false if the source is closed
+// or ctx is cancelled while waiting for an item.
+func (m *PairMux) runStep(ctx context.Context) bool {
+	if m.limitReached() || m.draining() {
 		return false
 	}
+	var item *RequestResponsePair
 	m.lock.Lock()
-	outputs := m.outputs[:]
+	src := m.src
+	if m.paused {
+		// Leave items in the source so upstream sees backpressure
+		src = nil
+	}
+	changed := m.pauseStateChan()
 	m.lock.Unlock()
+	select {
+	case i, ok := <-src:
+		if !ok {
+			return false
+		}
+		item = i
+	case <-changed:
+		return m.runStep(ctx)
+	case done := <-m.flush:
+		defer close(done)
+		// The mux is the only reader of src, so exactly this many items
+		// were available when Flush was called
+		for n := len(m.src); n > 0; n-- {
+			if !m.runStep(ctx) {
+				return false
+			}
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	case <-m.stop:
+		return false
+	case <-m.drain:
+		return false
+	}
+	if !m.waitUnpaused(ctx) {
+		return false
+	}
+	m.step(item)
+	return true
+}
 
-	for _, output := range outputs {
-		m.writer(output, item)
+// limitReached reports whether MaxPairs pairs have been processed, and if so
+// stops the mux, leaving the rest of the source unread or draining it.
+func (m *PairMux) limitReached() bool {
+	m.stepLock.Lock()
+	if m.MaxPairs <= 0 || m.seq < uint64(m.MaxPairs) {
+		m.stepLock.Unlock()
+		return false
+	}
+	first := !m.limited
+	m.limited = true
+	m.stepLock.Unlock()
+	if first {
+		m.log().Infof("PairMux processed %d pairs, stopping.\n", atomic.LoadUint64(&m.seq))
+		if m.DrainAfterMaxPairs {
+			go func(src <-chan *RequestResponsePair) {
+				for _ = range src {
+				}
+			}(m.src)
+		}
+		// Stop shuts down an unstarted mux directly, which takes stepLock
+		m.Stop()
 	}
 	return true
 }
 
+// waitUnpaused blocks while the mux is paused, returning false if it is
+// stopped or ctx is cancelled first.  This holds back an item that was read
+// as Pause was being called.  Draining the mux releases the item.
+func (m *PairMux) waitUnpaused(ctx context.Context) bool {
+	for {
+		m.lock.Lock()
+		paused := m.paused
+		changed := m.pauseStateChan()
+		m.lock.Unlock()
+		if !paused {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		case <-m.stop:
+			return false
+		case <-m.drain:
+			return true
+		}
+	}
+}
+
+// Pause stops the mux reading from the source, so pairs stay in the source
+// channel rather than being delivered or dropped.  A step already in
+// progress completes, and a pair read concurrently with Pause is held until
+// Resume.  Pausing a paused mux does nothing.
+func (m *PairMux) Pause() {
+	m.setPaused(true)
+}
+
+// Resume undoes Pause.  Resuming a mux that isn't paused does nothing.
+func (m *PairMux) Resume() {
+	m.setPaused(false)
+}
+
+func (m *PairMux) setPaused(paused bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.paused == paused {
+		return
+	}
+	m.paused = paused
+	close(m.pauseStateChan())
+	m.pauseChanged = nil
+}
+
+// pauseStateChan returns the channel closed on the next Pause or Resume.
+// The caller must hold lock.
+func (m *PairMux) pauseStateChan() chan struct{} {
+	if m.pauseChanged == nil {
+		m.pauseChanged = make(chan struct{})
+	}
+	return m.pauseChanged
+}
+
+// Flush blocks until the mux has processed every item that was buffered in
+// the source channel when Flush was called.  If the mux has not been
+// started, Flush processes them itself.  A paused mux processes nothing, so
+// Flush waits for Resume.
+func (m *PairMux) Flush() {
+	m.lock.Lock()
+	started := m.started
+	m.lock.Unlock()
+	if !started {
+		for n := len(m.src); n > 0; n-- {
+			if !m.RunStep() {
+				return
+			}
+		}
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case m.flush <- done:
+	case <-m.exited:
+		return
+	}
+	<-done
+}
+
+// step delivers a single item to the outputs
+func (m *PairMux) step(item *RequestResponsePair) {
+	m.stepLock.Lock()
+	defer m.stepLock.Unlock()
+	item.Seq = atomic.AddUint64(&m.seq, 1)
+	m.throughput.add(m.Clock.Now())
+	var key string
+	if m.hashKey != nil {
+		key = m.hashKey(item)
+	}
+	m.lock.Lock()
+	var outputs []output
+	if m.roundRobin || m.hashKey != nil {
+		var routed []output
+		for _, o := range m.outputs {
+			if o.observer {
+				outputs = append(outputs, o)
+			} else {
+				routed = append(routed, o)
+			}
+		}
+		if len(routed) > 0 {
+			if m.roundRobin {
+				outputs = append(outputs, routed[m.next%len(routed)])
+				m.next++
+			} else {
+				outputs = append(outputs, m.hashOutput(key))
+			}
+		}
+	} else {
+		outputs = make([]output, len(m.outputs))
+		copy(outputs, m.outputs)
+	}
+	taps := m.taps
+	m.lock.Unlock()
+
+	for _, tap := range taps {
+		tap(item)
+	}
+
+	accepted := outputs[:0]
+	for _, o := range outputs {
+		if o.filter == nil || o.filter(item) {
+			accepted = append(accepted, o)
+		}
+	}
+	outputs = accepted
+
+	// Make all copies before any output can see (and modify) the original
+	items := make([]*RequestResponsePair, len(outputs))
+	shared := false
+	for i, o := range outputs {
+		if o.mapper != nil || (m.CopyPerOutput && shared) {
+			items[i] = item.Clone()
+		} else {
+			items[i] = item
+			shared = true
+		}
+	}
+
+	m.fanout(outputs, items)
+}
+
+// fanout writes items[i] to outputs[i] using the worker pool, so a slow
+// output doesn't hold up the others, but finishes before returning to keep
+// per-output ordering.  The caller must hold stepLock.
+func (m *PairMux) fanout(outputs []output, items []*RequestResponsePair) {
+	if len(outputs) == 0 {
+		return
+	}
+	if len(outputs) == 1 {
+		m.deliver(outputs[0], items[0])
+		return
+	}
+	if m.FairDelivery {
+		m.fairFanout(outputs, items)
+		return
+	}
+	n := m.FanoutWorkers
+	if n <= 0 {
+		n = len(outputs)
+		if n > maxFanoutWorkers {
+			n = maxFanoutWorkers
+		}
+	}
+	if m.work == nil {
+		m.work = make(chan fanoutJob)
+	}
+	for ; m.workers < n; m.workers++ {
+		go m.fanoutWorker(m.work)
+	}
+	m.fanoutWG.Add(len(outputs))
+	for i, o := range outputs {
+		m.work <- fanoutJob{o, items[i]}
+	}
+	m.fanoutWG.Wait()
+}
+
+// fairFanout writes to the outputs from a single goroutine, waiting on all
+// of the blocking writes at once and completing whichever is ready first.
+func (m *PairMux) fairFanout(outputs []output, items []*RequestResponsePair) {
+	var waiting []fanoutJob
+	for i, o := range outputs {
+		item := items[i]
+		if o.mapper != nil {
+			if item = o.mapper(item); item == nil {
+				continue
+			}
+		}
+		if o.writer != nil || m.policy != Block {
+			// Writers that don't just block are called directly
+			m.write(o, item)
+			continue
+		}
+		waiting = append(waiting, fanoutJob{o, item})
+	}
+	for len(waiting) > 0 {
+		n := len(waiting)
+		cases := make([]reflect.SelectCase, 0, 2*n+1)
+		for _, job := range waiting {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend,
+				Chan: reflect.ValueOf(job.o.dst), Send: reflect.ValueOf(job.item)})
+		}
+		for _, job := range waiting {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv,
+				Chan: reflect.ValueOf(job.o.removed)})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv,
+			Chan: reflect.ValueOf(waiting[0].o.stopped)})
+		chosen, _, _ := reflect.Select(cases)
+		if chosen == 2*n {
+			// Stopped, so abandon the remaining writes
+			for _, job := range waiting {
+				m.record(job.o, job.item, false)
+			}
+			return
+		}
+		i := chosen % n
+		m.record(waiting[i].o, waiting[i].item, chosen < n)
+		waiting = append(waiting[:i], waiting[i+1:]...)
+	}
+}
+
+func (m *PairMux) fanoutWorker(work <-chan fanoutJob) {
+	for job := range work {
+		m.deliver(job.o, job.item)
+		m.fanoutWG.Done()
+	}
+}
+
+// stopWorkers shuts down the fan-out worker pool
+func (m *PairMux) stopWorkers() {
+	m.stepLock.Lock()
+	defer m.stepLock.Unlock()
+	if m.work != nil {
+		close(m.work)
+		m.work = nil
+		m.workers = 0
+	}
+}
+
+// deliver writes item to a single output and updates its stats
+func (m *PairMux) deliver(o output, item *RequestResponsePair) {
+	if o.mapper != nil {
+		if item = o.mapper(item); item == nil {
+			return
+		}
+	}
+	m.write(o, item)
+}
+
+// write writes item to o, unless o skips it, and records the result
+func (m *PairMux) write(o output, item *RequestResponsePair) {
+	if o.skip != nil && o.skip(m, o) {
+		return
+	}
+	m.record(o, item, m.outputWriter(o)(m, o, item))
+}
+
+// outputWriter returns the writer used for o
+func (m *PairMux) outputWriter(o output) outputWriter {
+	if o.writer != nil {
+		return o.writer
+	}
+	return m.writer
+}
+
+// record updates the stats of o for an attempt to write item
+func (m *PairMux) record(o output, item *RequestResponsePair, written bool) {
+	if written {
+		atomic.AddUint64(&o.stats.Written, 1)
+		atomic.StoreInt64(o.lastWrite, m.Clock.Now().UnixNano())
+	} else {
+		atomic.AddUint64(&o.stats.Dropped, 1)
+		m.logDrop(o, item)
+		if m.OnDrop != nil {
+			m.OnDrop(o.name, item)
+		}
+		m.deadLetter(o.name, item)
+	}
+}
+
+// DeadLetters returns a channel receiving every pair dropped or evicted by
+// the mux, along with the name of its output.  Sends never block, so pairs
+// are lost if the channel is full.  Later calls return the same channel.
+// The channel is closed when the mux shuts down.
+func (m *PairMux) DeadLetters(buf int) <-chan DroppedPair {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.deadLetters == nil && !m.finished {
+		m.deadLetters = make(chan DroppedPair, buf)
+	}
+	return m.deadLetters
+}
+
+// deadLetter offers a lost pair to the dead-letter channel, if any
+func (m *PairMux) deadLetter(name string, item *RequestResponsePair) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.deadLetters == nil {
+		return
+	}
+	select {
+	case m.deadLetters <- DroppedPair{name, item}:
+	default:
+	}
+}
+
 // WaitUntilFinished waits until finished
 func (m *PairMux) WaitUntilFinished() {
-	<-m.Finished
+	m.WaitUntilFinishedContext(context.Background())
+}
+
+// WaitUntilFinishedContext waits until finished or until ctx is done,
+// returning ctx.Err() in the latter case.
+func (m *PairMux) WaitUntilFinishedContext(ctx context.Context) error {
+	select {
+	case <-m.Finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // blockingOutputWriter writes out to a channel
-func blockingOutputWriter(o output, item *RequestResponsePair) {
-	o.dst <- item
-}
-
-// timeoutOutputWriter writes out to a channel with a timeout in ms
-func makeTimeoutOutputWriter(timeout time.Duration) func(output, *RequestResponsePair) {
-	return func(o output, item *RequestResponsePair) {
-		kill := make(chan bool)
-		go func() {
-			time.Sleep(timeout)
-			kill <- true
-		}()
-		select {
-		case o.dst <- item:
-			// Working as intended
-		case <-kill:
-			// TODO: log timeout on channel
+func blockingOutputWriter(_ *PairMux, o output, item *RequestResponsePair) bool {
+	select {
+	case o.dst <- item:
+		return true
+	case <-o.removed:
+		return false
+	case <-o.stopped:
+		return false
+	}
+}
+
+// timeoutOutputWriter writes out to a channel with a timeout, retrying up to
+// TimeoutRetries times
+func makeTimeoutOutputWriter(timeout time.Duration) outputWriter {
+	return func(m *PairMux, o output, item *RequestResponsePair) bool {
+		for attempt := 0; ; attempt++ {
+			select {
+			case o.dst <- item:
+				// Working as intended
+				return true
+			case <-m.Clock.After(timeout):
+				// Timed out, deliver records the drop if out of retries
+			case <-o.removed:
+				return false
+			case <-o.stopped:
+				return false
+			}
+			if attempt >= m.TimeoutRetries {
+				return false
+			}
+			atomic.AddUint64(&o.stats.Retries, 1)
+			if m.TimeoutBackoff > 0 {
+				select {
+				case <-m.Clock.After(m.TimeoutBackoff):
+				case <-o.removed:
+					return false
+				case <-o.stopped:
+					return false
+				}
+			}
 		}
 	}
 }
 
 // nonBlockingOutputWriter doesn't block at all
-func nonBlockingOutputWriter(o output, item *RequestResponsePair) {
+func nonBlockingOutputWriter(_ *PairMux, o output, item *RequestResponsePair) bool {
 	select {
 	case o.dst <- item:
 		// Working as planned
+		return true
 	default:
-		// TODO: log failure to write to channel
+		// Channel full, deliver records the drop
+		return false
+	}
+}
+
+// dropOldestOutputWriter evicts the oldest buffered item when the channel is
+// full.  The mux is the only writer, so once an item has been evicted there
+// is always room for the new one.
+func dropOldestOutputWriter(m *PairMux, o output, item *RequestResponsePair) bool {
+	for {
+		select {
+		case o.dst <- item:
+			return true
+		default:
+		}
+		select {
+		case old := <-o.dst:
+			atomic.AddUint64(&o.stats.Evicted, 1)
+			m.deadLetter(o.name, old)
+		default:
+			// A consumer took an item first, so there is room now
+		}
 	}
 }
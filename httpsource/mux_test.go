@@ -0,0 +1,1016 @@
+package httpsource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMuxStats(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewNonBlockingPairMux(src, 0)
+	m.AddOutput("small", 1)
+	m.AddOutput("large", 5)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	close(src)
+	for m.RunStep() {
+	}
+	stats := m.Stats()
+	if s := stats["small"]; s.Written != 1 || s.Dropped != 2 {
+		t.Errorf("small: expected 1 written, 2 dropped, got %+v\n", s)
+	}
+	if s := stats["large"]; s.Written != 3 || s.Dropped != 0 {
+		t.Errorf("large: expected 3 written, 0 dropped, got %+v\n", s)
+	}
+}
+
+func TestMuxRemoveOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	gone := m.MustAddOutput("gone", 0)
+	kept := m.MustAddOutput("kept", 1)
+	if !m.RemoveOutput("gone") {
+		t.Fatal("Expected RemoveOutput to find output.\n")
+	}
+	if m.RemoveOutput("gone") {
+		t.Error("Expected second RemoveOutput to fail.\n")
+	}
+	if _, ok := <-gone; ok {
+		t.Error("Expected removed output to be closed.\n")
+	}
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if len(kept) != 1 {
+		t.Errorf("Expected 1 item on kept output, got %d.\n", len(kept))
+	}
+	if _, ok := m.Stats()["gone"]; ok {
+		t.Error("Removed output still present in stats.\n")
+	}
+}
+
+func TestMuxStartContext(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	out := m.MustAddOutput("out", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.StartContext(ctx)
+	cancel()
+	m.WaitUntilFinished()
+	if _, ok := <-out; ok {
+		t.Error("Expected output to be closed after cancel.\n")
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestMuxLogsDrops(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewNonBlockingPairMux(src, 0)
+	l := &recordingLogger{}
+	m.Logger = l
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if len(l.lines) != 1 || !strings.Contains(l.lines[0], "full") {
+		t.Errorf("Expected one drop line naming output, got %v\n", l.lines)
+	}
+}
+
+func TestMuxParallelFanout(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	slow := m.MustAddOutput("slow", 0)
+	fast := m.MustAddOutput("fast", 0)
+	src <- &RequestResponsePair{}
+	done := make(chan bool)
+	go func() {
+		done <- m.RunStep()
+	}()
+	// fast must be deliverable while slow is still unread
+	<-fast
+	<-slow
+	if !<-done {
+		t.Error("Expected RunStep to return true.\n")
+	}
+}
+
+func TestMuxStop(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	out := m.MustAddOutput("out", 0)
+	m.Start()
+	m.Stop()
+	m.Stop()
+	m.WaitUntilFinished()
+	if _, ok := <-out; ok {
+		t.Error("Expected output to be closed after Stop.\n")
+	}
+
+	// Stopping before starting still closes outputs
+	unstarted := NewBlockingPairMux(src)
+	out = unstarted.MustAddOutput("out", 0)
+	unstarted.Stop()
+	unstarted.Start()
+	unstarted.WaitUntilFinished()
+	if _, ok := <-out; ok {
+		t.Error("Expected output to be closed after Stop.\n")
+	}
+}
+
+func TestMuxOnDrop(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, time.Millisecond)
+	m.Logger = &recordingLogger{}
+	var dropped []string
+	m.OnDrop = func(name string, _ *RequestResponsePair) {
+		dropped = append(dropped, name)
+	}
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if len(dropped) != 1 || dropped[0] != "full" {
+		t.Errorf("Expected OnDrop for full, got %v\n", dropped)
+	}
+}
+
+func TestMuxCopyPerOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.CopyPerOutput = true
+	a := m.MustAddOutput("a", 1)
+	b := m.MustAddOutput("b", 1)
+	pair := &RequestResponsePair{RequestBody: []byte("body")}
+	src <- pair
+	m.RunStep()
+	pa, pb := <-a, <-b
+	if pa != pair {
+		t.Error("Expected first output to receive the original pair.\n")
+	}
+	if pb == pair || string(pb.RequestBody) != "body" {
+		t.Errorf("Expected second output to receive a copy, got %v\n", pb)
+	}
+}
+
+func TestMuxWaitUntilFinishedContext(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	m.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := m.WaitUntilFinishedContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v\n", err)
+	}
+	close(src)
+	if err := m.WaitUntilFinishedContext(context.Background()); err != nil {
+		t.Errorf("Expected nil error, got %v\n", err)
+	}
+}
+
+func TestRoundRobinMux(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewRoundRobinMux(src)
+	a := m.MustAddOutput("a", 3)
+	b := m.MustAddOutput("b", 3)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	if len(a) != 2 || len(b) != 1 {
+		t.Errorf("Expected 2/1 distribution, got %d/%d.\n", len(a), len(b))
+	}
+}
+
+func TestMuxFilteredOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewBlockingPairMux(src)
+	all := m.MustAddOutput("all", 2)
+	posts, err := m.AddFilteredOutput("posts", 2, func(p *RequestResponsePair) bool {
+		return p.Request.Method == "POST"
+	})
+	fatalIfErr(t, err)
+	src <- &RequestResponsePair{Request: &http.Request{Method: "GET"}}
+	src <- &RequestResponsePair{Request: &http.Request{Method: "POST"}}
+	m.RunStep()
+	m.RunStep()
+	if len(all) != 2 || len(posts) != 1 {
+		t.Errorf("Expected 2 and 1 pairs, got %d and %d.\n", len(all), len(posts))
+	}
+	if s := m.Stats()["posts"]; s.Written != 1 || s.Dropped != 0 {
+		t.Errorf("Filtered pairs should not count as drops: %+v\n", s)
+	}
+}
+
+func TestMuxMappedOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	full := m.MustAddOutput("full", 1)
+	stripped, err := m.AddMappedOutput("stripped", 1, func(p *RequestResponsePair) *RequestResponsePair {
+		p.ResponseBody = nil
+		return p
+	})
+	fatalIfErr(t, err)
+	src <- &RequestResponsePair{ResponseBody: []byte("body")}
+	m.RunStep()
+	if p := <-full; string(p.ResponseBody) != "body" {
+		t.Errorf("Mapping leaked into full output: %q\n", p.ResponseBody)
+	}
+	if p := <-stripped; p.ResponseBody != nil {
+		t.Errorf("Expected mapped body to be stripped, got %q\n", p.ResponseBody)
+	}
+}
+
+func TestMuxSampledOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 7)
+	m := NewBlockingPairMux(src)
+	sampled, err := m.AddSampledOutput("sampled", 7, 3)
+	fatalIfErr(t, err)
+	for i := 0; i < 7; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	if len(sampled) != 3 {
+		t.Errorf("Expected 3 of 7 pairs sampled, got %d.\n", len(sampled))
+	}
+}
+
+func TestMuxDrainTimeout(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.DrainTimeout = time.Second
+	out := m.MustAddOutput("out", 1)
+	src <- &RequestResponsePair{}
+	close(src)
+	m.Start()
+	time.Sleep(5 * drainPollInterval)
+	// Output must still be open with the item buffered
+	if _, ok := <-out; !ok {
+		t.Fatal("Output closed before being drained.\n")
+	}
+	m.WaitUntilFinished()
+	if _, ok := <-out; ok {
+		t.Error("Expected output to be closed after draining.\n")
+	}
+}
+
+func TestMuxReset(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	m.AddOutput("out", 1)
+	m.Start()
+	if err := m.Reset(src); err == nil {
+		t.Error("Expected error resetting a running mux.\n")
+	}
+	close(src)
+	m.WaitUntilFinished()
+	if _, ok := <-m.Output("out"); ok {
+		t.Fatal("Expected output to be closed.\n")
+	}
+
+	src = make(chan *RequestResponsePair, 1)
+	if err := m.Reset(src); err != nil {
+		t.Fatalf("Unexpected error from Reset: %v\n", err)
+	}
+	out := m.Output("out")
+	m.Start()
+	src <- &RequestResponsePair{}
+	if _, ok := <-out; !ok {
+		t.Error("Expected a pair on the reset output.\n")
+	}
+	close(src)
+	m.WaitUntilFinished()
+}
+
+func TestDropOldestPairMux(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewDropOldestPairMux(src, 2)
+	out := m.MustAddOutput("out", 0)
+	pairs := []*RequestResponsePair{{}, {}, {}}
+	for _, p := range pairs {
+		src <- p
+		m.RunStep()
+	}
+	if cap(out) != 2 {
+		t.Fatalf("Expected buffer raised to 2, got %d.\n", cap(out))
+	}
+	if p := <-out; p != pairs[1] {
+		t.Error("Expected oldest pair to be evicted.\n")
+	}
+	if p := <-out; p != pairs[2] {
+		t.Error("Expected newest pair to be kept.\n")
+	}
+	if s := m.Stats()["out"]; s.Written != 3 || s.Evicted != 1 {
+		t.Errorf("Expected 3 written, 1 evicted, got %+v\n", s)
+	}
+}
+
+func TestMuxOutputWithTimeout(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.Logger = &recordingLogger{}
+	m.AddOutputWithTimeout("slow", 0, time.Millisecond)
+	src <- &RequestResponsePair{}
+	// Would block forever without the per-output timeout
+	m.RunStep()
+	if s := m.Stats()["slow"]; s.Dropped != 1 {
+		t.Errorf("Expected 1 dropped, got %+v\n", s)
+	}
+}
+
+func TestMuxRateLimitedOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewNonBlockingPairMux(src, 0)
+	m.Logger = &recordingLogger{}
+	out, err := m.AddRateLimitedOutput("limited", 3, 0.001)
+	fatalIfErr(t, err)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	if len(out) != 1 {
+		t.Errorf("Expected 1 pair through the limiter, got %d.\n", len(out))
+	}
+	if s := m.Stats()["limited"]; s.RateLimited != 2 || s.Dropped != 2 {
+		t.Errorf("Expected 2 rate-limited drops, got %+v\n", s)
+	}
+}
+
+func TestMuxRateLimitedOutputBadRate(t *testing.T) {
+	m := NewBlockingPairMux(make(chan *RequestResponsePair))
+	for _, rate := range []float64{0, -1} {
+		if _, err := m.AddRateLimitedOutput("limited", 1, rate); err == nil {
+			t.Errorf("Expected error for rate %v\n", rate)
+		}
+	}
+	if m.Output("limited") != nil {
+		t.Error("Expected no output to be added.\n")
+	}
+}
+
+func TestMuxTimeoutFakeClock(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, time.Minute)
+	m.Logger = &recordingLogger{}
+	clock := NewFakeClock(time.Unix(0, 0))
+	m.Clock = clock
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	done := make(chan bool)
+	go func() {
+		done <- m.RunStep()
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	<-done
+	if s := m.Stats()["full"]; s.Dropped != 1 {
+		t.Errorf("Expected timeout drop, got %+v\n", s)
+	}
+}
+
+func TestMuxTimeoutRetries(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, time.Minute)
+	m.Logger = &recordingLogger{}
+	m.TimeoutRetries = 2
+	m.TimeoutBackoff = time.Second
+	clock := NewFakeClock(time.Unix(0, 0))
+	m.Clock = clock
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	done := make(chan bool)
+	go func() {
+		done <- m.RunStep()
+	}()
+	// Three timeouts with a backoff after each of the first two
+	for _, d := range []time.Duration{time.Minute, time.Second, time.Minute, time.Second, time.Minute} {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(d)
+	}
+	<-done
+	if s := m.Stats()["full"]; s.Dropped != 1 || s.Retries != 2 {
+		t.Errorf("Expected 2 retries and a drop, got %+v\n", s)
+	}
+}
+
+func TestHashMux(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewHashMux(src, func(p *RequestResponsePair) string {
+		return p.Request.Host
+	})
+	names := []string{"a", "b", "c", "d"}
+	for _, name := range names {
+		m.AddOutput(name, 200)
+	}
+	hosts := make([]string, 100)
+	before := make(map[string]string)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d.example.com", i)
+		pair := &RequestResponsePair{Request: &http.Request{Host: hosts[i]}}
+		_, before[hosts[i]] = m.Route(pair)
+		src <- pair
+		m.RunStep()
+		src <- pair
+		m.RunStep()
+	}
+	total := 0
+	for _, name := range names {
+		if s := m.Stats()[name]; s.Written%2 != 0 {
+			t.Errorf("Same key routed to different outputs: %s %+v\n", name, s)
+		} else {
+			total += int(s.Written)
+		}
+	}
+	if total != 200 {
+		t.Errorf("Expected 200 pairs delivered, got %d.\n", total)
+	}
+
+	m.RemoveOutput("d")
+	moved := 0
+	for _, host := range hosts {
+		_, now := m.Route(&RequestResponsePair{Request: &http.Request{Host: host}})
+		if before[host] != "d" && now != before[host] {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("Removing an output remapped %d keys it didn't own.\n", moved)
+	}
+}
+
+func TestMuxOutputDepths(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.AddOutput("out", 4)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	if d := m.OutputDepths()["out"]; d.Len != 1 || d.Cap != 4 {
+		t.Errorf("Expected depth 1/4, got %+v\n", d)
+	}
+}
+
+func TestMuxFanoutWorkers(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.FanoutWorkers = 2
+	outs := make([]<-chan *RequestResponsePair, 5)
+	for i := range outs {
+		outs[i] = m.MustAddOutput(fmt.Sprintf("out%d", i), 1)
+	}
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+		if m.workers != 2 {
+			t.Errorf("Expected 2 workers, got %d\n", m.workers)
+		}
+		for _, out := range outs {
+			<-out
+		}
+	}
+	m.Stop()
+	if m.work != nil || m.workers != 0 {
+		t.Error("Expected Stop to shut down the worker pool.\n")
+	}
+}
+
+func benchmarkFanout(b *testing.B, fanout func(m *PairMux, outputs []output, items []*RequestResponsePair)) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	for i := 0; i < 100; i++ {
+		out := m.MustAddOutput(fmt.Sprintf("out%d", i), 1)
+		go func() {
+			for _ = range out {
+			}
+		}()
+	}
+	items := make([]*RequestResponsePair, len(m.outputs))
+	for i := range items {
+		items[i] = &RequestResponsePair{}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fanout(&m, m.outputs, items)
+	}
+	b.StopTimer()
+	m.Stop()
+}
+
+func BenchmarkFanoutPool(b *testing.B) {
+	benchmarkFanout(b, (*PairMux).fanout)
+}
+
+// BenchmarkFanoutSpawn is the goroutine-per-output approach, for comparison
+func BenchmarkFanoutSpawn(b *testing.B) {
+	benchmarkFanout(b, func(m *PairMux, outputs []output, items []*RequestResponsePair) {
+		var wg sync.WaitGroup
+		for i, o := range outputs {
+			wg.Add(1)
+			go func(o output, item *RequestResponsePair) {
+				defer wg.Done()
+				m.deliver(o, item)
+			}(o, items[i])
+		}
+		wg.Wait()
+	})
+}
+
+func TestMuxDeadLetters(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, 0)
+	m.Logger = &recordingLogger{}
+	m.AddOutput("full", 0)
+	dead := m.DeadLetters(1)
+	pair := &RequestResponsePair{}
+	src <- pair
+	m.RunStep()
+	if d := <-dead; d.Output != "full" || d.Pair != pair {
+		t.Errorf("Unexpected dead letter: %+v\n", d)
+	}
+	m.Stop()
+	if _, ok := <-dead; ok {
+		t.Error("Expected dead letters to be closed on shutdown.\n")
+	}
+}
+
+func TestDropOldestDeadLetters(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewDropOldestPairMux(src, 1)
+	m.AddOutput("out", 1)
+	dead := m.DeadLetters(1)
+	first := &RequestResponsePair{}
+	src <- first
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	m.RunStep()
+	if d := <-dead; d.Pair != first {
+		t.Errorf("Expected the evicted pair as a dead letter, got %+v\n", d)
+	}
+}
+
+func TestMuxFlush(t *testing.T) {
+	src := make(chan *RequestResponsePair, 10)
+	m := NewBlockingPairMux(src)
+	m.AddOutput("out", 10)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	// Not started, so Flush runs the steps itself
+	m.Flush()
+	if d := m.OutputDepths()["out"]; d.Len != 3 {
+		t.Errorf("Expected 3 items after unstarted Flush, got %d\n", d.Len)
+	}
+
+	m.Start()
+	for i := 0; i < 5; i++ {
+		src <- &RequestResponsePair{}
+	}
+	m.Flush()
+	if d := m.OutputDepths()["out"]; d.Len != 8 {
+		t.Errorf("Expected 8 items after Flush, got %d\n", d.Len)
+	}
+	m.Stop()
+	// Flush on a stopped mux returns immediately
+	m.Flush()
+}
+
+func TestMuxPause(t *testing.T) {
+	src := make(chan *RequestResponsePair, 10)
+	m := NewNonBlockingPairMux(src, 0)
+	out := m.MustAddOutput("out", 10)
+	m.Start()
+	m.Pause()
+	m.Pause()
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(out) != 0 || len(src) < 2 {
+		t.Errorf("Expected paused mux to leave items in source, got %d delivered, %d queued\n",
+			len(out), len(src))
+	}
+	m.Resume()
+	m.Resume()
+	m.Flush()
+	if len(out) != 3 {
+		t.Errorf("Expected 3 items after Resume, got %d\n", len(out))
+	}
+	if s := m.Stats()["out"]; s.Dropped != 0 {
+		t.Errorf("Expected no drops, got %d\n", s.Dropped)
+	}
+	m.Stop()
+}
+
+func TestMuxStartedOutputCount(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	if m.Started() || m.OutputCount() != 0 {
+		t.Error("Expected a new mux to be unstarted with no outputs.\n")
+	}
+	m.AddOutput("a", 0)
+	m.AddOutput("b", 0)
+	if n := m.OutputCount(); n != 2 {
+		t.Errorf("Expected 2 outputs, got %d\n", n)
+	}
+	m.RemoveOutput("a")
+	if n := m.OutputCount(); n != 1 {
+		t.Errorf("Expected 1 output after removal, got %d\n", n)
+	}
+	m.Start()
+	if !m.Started() {
+		t.Error("Expected mux to be started.\n")
+	}
+	m.Stop()
+}
+
+func TestMuxDuplicateOutput(t *testing.T) {
+	m := NewBlockingPairMux(make(chan *RequestResponsePair))
+	m.MustAddOutput("out", 0)
+	if _, err := m.AddOutput("out", 0); err == nil {
+		t.Error("Expected error adding a duplicate output.\n")
+	}
+	if _, err := m.AddSampledOutput("out", 0, 2); err == nil {
+		t.Error("Expected error adding a duplicate sampled output.\n")
+	}
+	if n := m.OutputCount(); n != 1 {
+		t.Errorf("Expected 1 output, got %d\n", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustAddOutput to panic on a duplicate.\n")
+		}
+	}()
+	m.MustAddOutput("out", 0)
+}
+
+func TestMuxFairDelivery(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.FairDelivery = true
+	slow := m.MustAddOutput("slow", 0)
+	fast := m.MustAddOutput("fast", 0)
+	m.MustAddOutput("removed", 0)
+	src <- &RequestResponsePair{}
+	done := make(chan bool)
+	go func() {
+		done <- m.RunStep()
+	}()
+	// fast must be deliverable while slow is still unread
+	<-fast
+	<-slow
+	m.RemoveOutput("removed")
+	if !<-done {
+		t.Error("Expected RunStep to return true.\n")
+	}
+	if s := m.Stats(); s["slow"].Written != 1 || s["fast"].Written != 1 {
+		t.Errorf("Unexpected stats: %+v\n", s)
+	}
+}
+
+func TestMuxSeq(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewNonBlockingPairMux(src, 0)
+	m.Logger = &recordingLogger{}
+	out := m.MustAddOutput("out", 1)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	m.RunStep()
+	// The second pair is dropped, leaving a gap
+	m.RunStep()
+	first := <-out
+	m.RunStep()
+	third := <-out
+	if first.Seq != 1 || third.Seq != 3 {
+		t.Errorf("Expected seqs 1 and 3, got %d and %d\n", first.Seq, third.Seq)
+	}
+}
+
+func TestMuxMaxPairs(t *testing.T) {
+	src := make(chan *RequestResponsePair, 5)
+	m := NewBlockingPairMux(src)
+	m.MaxPairs = 3
+	out := m.MustAddOutput("out", 5)
+	for i := 0; i < 5; i++ {
+		src <- &RequestResponsePair{}
+	}
+	m.Start()
+	m.WaitUntilFinished()
+	n := 0
+	for _ = range out {
+		n++
+	}
+	if n != 3 || len(src) != 2 {
+		t.Errorf("Expected 3 pairs delivered and 2 unread, got %d and %d\n", n, len(src))
+	}
+}
+
+func TestMuxMaxPairsDrain(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	m.MaxPairs = 1
+	m.DrainAfterMaxPairs = true
+	m.MustAddOutput("out", 1)
+	m.Start()
+	// Sends beyond the limit must not block
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	close(src)
+	m.WaitUntilFinished()
+	if s := m.Stats()["out"]; s.Written != 1 {
+		t.Errorf("Expected 1 pair written, got %d\n", s.Written)
+	}
+}
+
+func TestMuxResetMaxPairs(t *testing.T) {
+	m := NewBlockingPairMux(nil)
+	m.MaxPairs = 2
+	m.MustAddOutput("out", 5)
+	for run := 0; run < 2; run++ {
+		src := make(chan *RequestResponsePair, 3)
+		for i := 0; i < 3; i++ {
+			src <- &RequestResponsePair{}
+		}
+		fatalIfErr(t, m.Reset(src))
+		out := m.Output("out")
+		m.Start()
+		m.WaitUntilFinished()
+		var seqs []uint64
+		for p := range out {
+			seqs = append(seqs, p.Seq)
+		}
+		if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+			t.Errorf("Run %d: expected pairs 1 and 2, got %v\n", run, seqs)
+		}
+	}
+}
+
+func TestMuxMaxPairsUnstarted(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		src := make(chan *RequestResponsePair, 3)
+		m := NewBlockingPairMux(src)
+		m.Logger = &recordingLogger{}
+		m.MaxPairs = 1
+		m.SendSentinelOnClose = true
+		out := m.MustAddOutput("out", 5)
+		for i := 0; i < 3; i++ {
+			src <- &RequestResponsePair{}
+		}
+		done := make(chan bool)
+		go func() {
+			if flush {
+				m.Flush()
+			} else {
+				for m.RunStep() {
+				}
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Mux hung reaching MaxPairs (flush %v)\n", flush)
+		}
+		n := 0
+		for p := range out {
+			if !p.IsSentinel() {
+				n++
+			}
+		}
+		if n != 1 || len(src) != 2 {
+			t.Errorf("Expected 1 pair delivered and 2 unread, got %d and %d\n", n, len(src))
+		}
+	}
+}
+
+func TestMuxTap(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewBlockingPairMux(src)
+	var tapped []uint64
+	m.Tap(func(p *RequestResponsePair) { tapped = append(tapped, p.Seq) })
+	m.Tap(func(p *RequestResponsePair) { tapped = append(tapped, p.Seq*10) })
+	src <- &RequestResponsePair{}
+	src <- &RequestResponsePair{}
+	// Taps see pairs even with no outputs
+	m.RunStep()
+	m.RunStep()
+	if len(tapped) != 4 || tapped[0] != 1 || tapped[1] != 10 || tapped[3] != 20 {
+		t.Errorf("Unexpected taps: %v\n", tapped)
+	}
+}
+
+func TestMuxCounter(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewBlockingPairMux(src)
+	all := m.MustAddOutput("all", 3)
+	posts, err := m.AddCounter("posts", func(p *RequestResponsePair) bool {
+		return p.Request.Method == "POST"
+	})
+	fatalIfErr(t, err)
+	if _, err := m.AddCounter("posts", nil); err == nil {
+		t.Error("Expected error for a duplicate counter name.\n")
+	}
+	for _, method := range []string{"POST", "GET", "POST"} {
+		src <- &RequestResponsePair{Request: &http.Request{Method: method}}
+		m.RunStep()
+	}
+	if n := atomic.LoadInt64(posts); n != 2 {
+		t.Errorf("Expected 2 POSTs counted, got %d\n", n)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected all pairs delivered, got %d\n", len(all))
+	}
+	if !m.RemoveOutput("posts") {
+		t.Error("Expected counter to be removable.\n")
+	}
+}
+
+func TestRoundRobinMuxCounter(t *testing.T) {
+	src := make(chan *RequestResponsePair, 4)
+	m := NewRoundRobinMux(src)
+	a := m.MustAddOutput("a", 4)
+	count, err := m.AddCounter("count", nil)
+	fatalIfErr(t, err)
+	b := m.MustAddOutput("b", 4)
+	for i := 0; i < 4; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	if n := atomic.LoadInt64(count); n != 4 {
+		t.Errorf("Expected counter to see all 4 pairs, got %d\n", n)
+	}
+	if len(a) != 2 || len(b) != 2 {
+		t.Errorf("Expected counter to take no turn, got %d/%d\n", len(a), len(b))
+	}
+}
+
+func TestMuxCloseOutput(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	done := m.MustAddOutput("done", 0)
+	kept := m.MustAddOutput("kept", 1)
+	m.Start()
+	fatalIfErr(t, m.CloseOutput("done"))
+	if _, ok := <-done; ok {
+		t.Error("Expected closed output's channel to be closed.\n")
+	}
+	if err := m.CloseOutput("done"); err == nil {
+		t.Error("Expected error closing an output twice.\n")
+	}
+	src <- &RequestResponsePair{}
+	if _, ok := <-kept; !ok {
+		t.Error("Expected other outputs to keep running.\n")
+	}
+	close(src)
+	m.WaitUntilFinished()
+	if err := m.CloseOutput("kept"); err == nil {
+		t.Error("Expected error closing an output of a finished mux.\n")
+	}
+}
+
+func TestMuxPending(t *testing.T) {
+	src := make(chan *RequestResponsePair, 4)
+	m := NewBlockingPairMux(src)
+	a := m.MustAddOutput("a", 4)
+	m.MustAddOutput("b", 4)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	m.RunStep()
+	// Two in the source, and one in each output
+	if n := m.Pending(); n != 4 {
+		t.Errorf("Expected 4 pending, got %d\n", n)
+	}
+	<-a
+	if n := m.Pending(); n != 3 {
+		t.Errorf("Expected 3 pending, got %d\n", n)
+	}
+}
+
+func TestMuxOutputActivity(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	src := make(chan *RequestResponsePair, 2)
+	m := NewPairMux(src, WithNonBlocking(), WithClock(clock))
+	live := m.MustAddOutput("live", 2)
+	m.MustAddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	clock.Advance(time.Minute)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	activity := m.OutputActivity()
+	if want := time.Unix(1060, 0); !activity["live"].Equal(want) {
+		t.Errorf("Expected last write at %v, got %v\n", want, activity["live"])
+	}
+	if last, ok := activity["full"]; !ok || !last.IsZero() {
+		t.Errorf("Expected zero time for an output never written to, got %v\n", last)
+	}
+	if len(live) != 2 {
+		t.Errorf("Expected 2 pairs on live output, got %d\n", len(live))
+	}
+}
+
+func TestMuxDrain(t *testing.T) {
+	src := make(chan *RequestResponsePair, 5)
+	m := NewBlockingPairMux(src)
+	m.Logger = &recordingLogger{}
+	out := m.MustAddOutput("out", 2)
+	for i := 0; i < 5; i++ {
+		src <- &RequestResponsePair{}
+	}
+	m.Start()
+	// Wait for the mux to fill the output and block writing the third pair
+	for deadline := time.Now().Add(time.Second); len(src) > 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Mux didn't fill the output\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	flushed := make(chan int)
+	go func() {
+		flushed <- m.Drain()
+	}()
+	for !m.draining() {
+		time.Sleep(time.Millisecond)
+	}
+	received := 0
+	for _ = range out {
+		received++
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := <-flushed; n != 3 || received != 3 {
+		t.Errorf("Expected 3 pairs flushed and received, got %d and %d\n", n, received)
+	}
+	if len(src) != 2 {
+		t.Errorf("Expected the rest of the source left unread, %d left\n", len(src))
+	}
+	if m.Drain() != 0 {
+		t.Errorf("Expected draining a finished mux to flush nothing\n")
+	}
+}
+
+func TestMuxDrainSentinel(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	m := NewBlockingPairMux(src)
+	m.Logger = &recordingLogger{}
+	m.SendSentinelOnClose = true
+	out := m.MustAddOutput("out", 5)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	received := make(chan int)
+	go func() {
+		n := 0
+		for p := range out {
+			if !p.IsSentinel() {
+				n++
+			}
+		}
+		received <- n
+	}()
+	if n := m.Drain(); n != 3 {
+		t.Errorf("Expected 3 pairs flushed, got %d\n", n)
+	}
+	if n := <-received; n != 3 {
+		t.Errorf("Expected 3 pairs received, got %d\n", n)
+	}
+}
+
+func TestMuxDrainUnstarted(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewBlockingPairMux(src)
+	m.Logger = &recordingLogger{}
+	out := m.MustAddOutput("out", 2)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	go func() {
+		for _ = range out {
+		}
+	}()
+	if n := m.Drain(); n != 1 {
+		t.Errorf("Expected 1 pair flushed, got %d\n", n)
+	}
+	m.Start()
+	select {
+	case <-m.Finished:
+	case <-time.After(time.Second):
+		t.Errorf("Expected drained mux to finish\n")
+	}
+}
@@ -0,0 +1,58 @@
+package httpsource
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NormalizeOptions selects how aggressively NormalizedURL normalizes.
+type NormalizeOptions struct {
+	// StripQuery removes the query string
+	StripQuery bool
+	// SortQuery orders the query parameters by name, if they are kept
+	SortQuery bool
+	// LowercaseHost lowercases the host name
+	LowercaseHost bool
+	// TemplateIDs replaces path segments that look like IDs (numbers, UUIDs
+	// and long hex strings) with {id}
+	TemplateIDs bool
+}
+
+var idSegmentRe = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// NormalizedURL returns the absolute URL of the request, normalized so that
+// requests for similar resources can be grouped, such as /users/123 and
+// /users/456 becoming /users/{id}.  Returns an empty string if the pair has
+// no request.
+func (p *RequestResponsePair) NormalizedURL(opts NormalizeOptions) string {
+	if p.Request == nil || p.Request.URL == nil {
+		return ""
+	}
+	u := absoluteURL(p.Request)
+	if opts.LowercaseHost {
+		u.Host = strings.ToLower(u.Host)
+	}
+	if opts.StripQuery {
+		u.RawQuery = ""
+	} else if opts.SortQuery {
+		// Encode sorts by key
+		u.RawQuery = u.Query().Encode()
+	}
+	u.Fragment = ""
+	if opts.TemplateIDs {
+		segments := strings.Split(u.EscapedPath(), "/")
+		for i, seg := range segments {
+			if idSegmentRe.MatchString(seg) {
+				segments[i] = "{id}"
+			}
+		}
+		// Set the escaped form directly so the braces aren't escaped
+		u.RawPath = ""
+		u.Path = ""
+		u.Opaque = strings.Join(segments, "/")
+		if u.Host != "" {
+			u.Opaque = "//" + u.Host + u.Opaque
+		}
+	}
+	return u.String()
+}
@@ -0,0 +1,37 @@
+package httpsource
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalizedURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		opts     NormalizeOptions
+		expected string
+	}{
+		{"http://Example.COM/users/123?b=2&a=1", NormalizeOptions{}, "http://Example.COM/users/123?b=2&a=1"},
+		{"http://Example.COM/users/123?b=2&a=1", NormalizeOptions{LowercaseHost: true, StripQuery: true},
+			"http://example.com/users/123"},
+		{"http://example.com/a?b=2&a=1", NormalizeOptions{SortQuery: true}, "http://example.com/a?a=1&b=2"},
+		{"http://example.com/users/456/posts/0e7a1c52-08a4-4a42-9f3c-0a2f1cf1d7b8?x=1",
+			NormalizeOptions{TemplateIDs: true, StripQuery: true}, "http://example.com/users/{id}/posts/{id}"},
+		{"http://example.com/v2/items/deadbeefdeadbeef/edit", NormalizeOptions{TemplateIDs: true},
+			"http://example.com/v2/items/{id}/edit"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		fatalIfErr(t, err)
+		pair := &RequestResponsePair{Request: req}
+		if got := pair.NormalizedURL(test.opts); got != test.expected {
+			t.Errorf("NormalizedURL(%s, %+v) = %s, expected %s\n", test.url, test.opts, got, test.expected)
+		}
+	}
+
+	// Requests read off the wire only have a path
+	pair := testPair(t, "GET /users/7 HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\n")
+	if got := pair.NormalizedURL(NormalizeOptions{TemplateIDs: true}); got != "http://example.com/users/{id}" {
+		t.Errorf("Unexpected URL: %s\n", got)
+	}
+}
@@ -0,0 +1,100 @@
+package httpsource
+
+import (
+	"time"
+)
+
+// Option configures a PairMux created by NewPairMux.  Options are applied in
+// order, so a later delivery mode replaces an earlier one.
+type Option func(*PairMux)
+
+// WithDropPolicy sets what the mux does with pairs for full outputs.
+func WithDropPolicy(p DropPolicy) Option {
+	return func(m *PairMux) {
+		m.policy = p
+	}
+}
+
+// WithBlocking makes writes to full channels block.  This is the default.
+func WithBlocking() Option {
+	return WithDropPolicy(Block)
+}
+
+// WithNonBlocking drops pairs for outputs whose channels are full.
+func WithNonBlocking() Option {
+	return WithDropPolicy(DropNewest)
+}
+
+// WithTimeout drops pairs for outputs whose channels stay full for longer than
+// d.  A zero d drops them immediately, as WithNonBlocking does.
+func WithTimeout(d time.Duration) Option {
+	return WithDropPolicy(Timeout(d))
+}
+
+// WithDropOldest discards the oldest buffered pair of a full output to make
+// room for the newest, as NewDropOldestPairMux does.  Outputs are given a
+// buffer of at least bufHint.
+func WithDropOldest(bufHint int) Option {
+	return func(m *PairMux) {
+		m.policy = DropOldest
+		m.minBuf = bufHint
+	}
+}
+
+// WithRoundRobin delivers each pair to a single output, rotating through
+// them, as NewRoundRobinMux does.
+func WithRoundRobin() Option {
+	return func(m *PairMux) {
+		m.roundRobin = true
+	}
+}
+
+// WithHashKey delivers each pair to a single output chosen by consistent
+// hashing of keyFn(pair), as NewHashMux does.
+func WithHashKey(keyFn func(*RequestResponsePair) string) Option {
+	return func(m *PairMux) {
+		m.hashKey = keyFn
+	}
+}
+
+// WithCopyPerOutput sets CopyPerOutput.
+func WithCopyPerOutput() Option {
+	return func(m *PairMux) {
+		m.CopyPerOutput = true
+	}
+}
+
+// WithSendSentinelOnClose sets SendSentinelOnClose.
+func WithSendSentinelOnClose() Option {
+	return func(m *PairMux) {
+		m.SendSentinelOnClose = true
+	}
+}
+
+// WithLogger sets the mux's Logger.
+func WithLogger(l Logger) Option {
+	return func(m *PairMux) {
+		m.Logger = l
+	}
+}
+
+// WithLevelLogger sets the mux's LevelLogger.
+func WithLevelLogger(l LevelLogger) Option {
+	return func(m *PairMux) {
+		m.LevelLogger = l
+	}
+}
+
+// WithClock sets the mux's Clock.
+func WithClock(c Clock) Option {
+	return func(m *PairMux) {
+		m.Clock = c
+	}
+}
+
+// WithFanoutWorkers sets FanoutWorkers.
+func WithFanoutWorkers(n int) Option {
+	return func(m *PairMux) {
+		m.FanoutWorkers = n
+	}
+}
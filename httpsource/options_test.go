@@ -0,0 +1,78 @@
+package httpsource
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewPairMuxDefaults(t *testing.T) {
+	m := NewPairMux(nil)
+	if m.Policy() != Block || m.writer == nil || m.throughput == nil {
+		t.Errorf("Expected a blocking mux by default\n")
+	}
+}
+
+func TestNewPairMuxOptions(t *testing.T) {
+	l := log.New(os.Stderr, "test: ", 0)
+	m := NewPairMux(nil, WithTimeout(time.Second), WithCopyPerOutput(), WithLogger(l), WithFanoutWorkers(3))
+	if m.Policy() != Timeout(time.Second) {
+		t.Errorf("Expected a mux with a timeout, got %s\n", m.Policy())
+	}
+	if !m.CopyPerOutput || m.Logger != l || m.FanoutWorkers != 3 {
+		t.Errorf("Options not applied: %v %v %v\n", m.CopyPerOutput, m.Logger, m.FanoutWorkers)
+	}
+
+	// The last delivery mode wins
+	m = NewPairMux(nil, WithDropOldest(0), WithBlocking())
+	if m.Policy() != Block {
+		t.Error("Expected WithBlocking to replace WithDropOldest.\n")
+	}
+	m = NewPairMux(nil, WithBlocking(), WithNonBlocking())
+	if m.Policy() != DropNewest {
+		t.Error("Expected WithNonBlocking to replace WithBlocking.\n")
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	if Timeout(0) != DropNewest {
+		t.Error("Expected a zero timeout to drop immediately.\n")
+	}
+	if Timeout(time.Second) == Timeout(time.Minute) {
+		t.Error("Expected timeouts to compare by duration.\n")
+	}
+	if s := Timeout(time.Second).String(); s != "timeout(1s)" {
+		t.Errorf("Unexpected policy name %q\n", s)
+	}
+	m := NewPairMux(nil, WithDropPolicy(DropOldest))
+	if m.minBuf != 1 {
+		t.Errorf("Expected drop-oldest outputs to be buffered, got minBuf %d\n", m.minBuf)
+	}
+	src := make(chan *RequestResponsePair, 2)
+	m = NewPairMux(src, WithDropPolicy(DropOldest))
+	out := m.MustAddOutput("out", 0)
+	src <- &RequestResponsePair{Seq: 1}
+	src <- &RequestResponsePair{Seq: 2}
+	m.RunStep()
+	m.RunStep()
+	if p := <-out; p.Seq != 2 || m.Stats()["out"].Evicted != 1 {
+		t.Errorf("Expected oldest pair evicted, got seq %d and %+v\n", p.Seq, m.Stats()["out"])
+	}
+}
+
+func TestNewPairMuxNonBlockingDrops(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewPairMux(src, WithNonBlocking())
+	out := m.MustAddOutput("out", 0)
+	src <- &RequestResponsePair{}
+	close(src)
+	m.Start()
+	<-m.Finished
+	if _, ok := <-out; ok {
+		t.Error("Expected pair to be dropped.\n")
+	}
+	if stats := m.Stats()["out"]; stats.Dropped != 1 {
+		t.Errorf("Expected 1 drop, got %+v\n", stats)
+	}
+}
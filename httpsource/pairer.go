@@ -0,0 +1,166 @@
+package httpsource
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DefaultOrphanTimeout is how long NewPairer waits for the other half of a
+// request or response.
+const DefaultOrphanTimeout = 30 * time.Second
+
+// Request is an HTTP request seen on the connection identified by ConnID.
+// StreamID is the HTTP/2 stream carrying it, or zero for earlier versions.
+type Request struct {
+	ConnID    string
+	StreamID  uint32
+	Request   *http.Request
+	Body      []byte
+	Timestamp time.Time
+}
+
+// Response is an HTTP response seen on the connection identified by ConnID.
+// Timestamp is when the end of the response was seen.
+type Response struct {
+	ConnID    string
+	StreamID  uint32
+	Response  *http.Response
+	Body      []byte
+	Timestamp time.Time
+}
+
+// pairerHalf is a request or response waiting for its other half
+type pairerHalf struct {
+	req     *Request
+	resp    *Response
+	arrived time.Time
+}
+
+// pairerConn holds the unmatched halves of a single connection.  At most one
+// of the queues is non-empty at a time.
+type pairerConn struct {
+	reqs  []pairerHalf
+	resps []pairerHalf
+}
+
+// NewPairer matches requests and responses into pairs, using NewPairerTimeout
+// with DefaultOrphanTimeout.
+func NewPairer(reqs <-chan *Request, resps <-chan *Response) <-chan *RequestResponsePair {
+	return NewPairerTimeout(reqs, resps, DefaultOrphanTimeout)
+}
+
+// NewPairerTimeout matches requests and responses with the same ConnID in
+// FIFO order, emitting a pair once both halves have arrived.  HTTP/2 halves
+// are matched by StreamID instead, as streams on a connection interleave.
+// A half left unmatched for longer than window is emitted on its own, with
+// the other half nil; a window <= 0 waits forever.  Once both inputs are
+// closed, any unmatched halves are emitted and the returned channel is
+// closed.
+func NewPairerTimeout(reqs <-chan *Request, resps <-chan *Response, window time.Duration) <-chan *RequestResponsePair {
+	out := make(chan *RequestResponsePair, 10)
+	go func() {
+		defer close(out)
+		conns := make(map[string]*pairerConn)
+		getConn := func(id string, stream uint32) (string, *pairerConn) {
+			if stream != 0 {
+				id = fmt.Sprintf("%s/%d", id, stream)
+			}
+			c, ok := conns[id]
+			if !ok {
+				c = &pairerConn{}
+				conns[id] = c
+			}
+			return id, c
+		}
+		var tick <-chan time.Time
+		if window > 0 {
+			ticker := time.NewTicker(sweepInterval(window))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for reqs != nil || resps != nil {
+			select {
+			case req, ok := <-reqs:
+				if !ok {
+					reqs = nil
+					continue
+				}
+				id, c := getConn(req.ConnID, req.StreamID)
+				if len(c.resps) > 0 {
+					out <- makePair(req, c.resps[0].resp)
+					c.resps = c.resps[1:]
+					// Don't wait for expiry to forget it, there may be none
+					if len(c.resps) == 0 {
+						delete(conns, id)
+					}
+				} else {
+					c.reqs = append(c.reqs, pairerHalf{req: req, arrived: time.Now()})
+				}
+			case resp, ok := <-resps:
+				if !ok {
+					resps = nil
+					continue
+				}
+				id, c := getConn(resp.ConnID, resp.StreamID)
+				if len(c.reqs) > 0 {
+					out <- makePair(c.reqs[0].req, resp)
+					c.reqs = c.reqs[1:]
+					if len(c.reqs) == 0 {
+						delete(conns, id)
+					}
+				} else {
+					c.resps = append(c.resps, pairerHalf{resp: resp, arrived: time.Now()})
+				}
+			case now := <-tick:
+				expireOrphans(conns, now.Add(-window), out)
+			}
+		}
+		expireOrphans(conns, time.Now().Add(time.Hour), out)
+	}()
+	return out
+}
+
+// expireOrphans emits, oldest first, every half that arrived before cutoff
+// and forgets connections with nothing left pending.
+func expireOrphans(conns map[string]*pairerConn, cutoff time.Time, out chan<- *RequestResponsePair) {
+	var expired []pairerHalf
+	for id, c := range conns {
+		for len(c.reqs) > 0 && c.reqs[0].arrived.Before(cutoff) {
+			expired = append(expired, c.reqs[0])
+			c.reqs = c.reqs[1:]
+		}
+		for len(c.resps) > 0 && c.resps[0].arrived.Before(cutoff) {
+			expired = append(expired, c.resps[0])
+			c.resps = c.resps[1:]
+		}
+		if len(c.reqs) == 0 && len(c.resps) == 0 {
+			delete(conns, id)
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[i].arrived.Before(expired[j].arrived)
+	})
+	for _, h := range expired {
+		out <- makePair(h.req, h.resp)
+	}
+}
+
+// makePair builds a pair from the halves, either of which may be nil
+func makePair(req *Request, resp *Response) *RequestResponsePair {
+	pair := &RequestResponsePair{}
+	if req != nil {
+		pair.Request = req.Request
+		pair.RequestBody = req.Body
+		pair.Timestamp = req.Timestamp
+		pair.StreamID = req.StreamID
+	}
+	if resp != nil {
+		pair.Response = resp.Response
+		pair.ResponseBody = resp.Body
+		pair.ResponseEnd = resp.Timestamp
+		pair.StreamID = resp.StreamID
+	}
+	return pair
+}
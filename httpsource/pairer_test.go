@@ -0,0 +1,107 @@
+package httpsource
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPairer(t *testing.T) {
+	reqs := make(chan *Request, 4)
+	resps := make(chan *Response, 4)
+	pairs := NewPairerTimeout(reqs, resps, 0)
+
+	reqA1 := &http.Request{Method: "GET"}
+	reqA2 := &http.Request{Method: "POST"}
+	reqB := &http.Request{Method: "PUT"}
+	respA1 := &http.Response{StatusCode: 200}
+	respA2 := &http.Response{StatusCode: 201}
+	respB := &http.Response{StatusCode: 204}
+	reqs <- &Request{ConnID: "a", Request: reqA1}
+	reqs <- &Request{ConnID: "b", Request: reqB}
+	reqs <- &Request{ConnID: "a", Request: reqA2}
+	resps <- &Response{ConnID: "b", Response: respB}
+	if p := <-pairs; p.Request != reqB || p.Response != respB {
+		t.Errorf("Expected pair for connection b, got %v/%v\n", p.Request, p.Response)
+	}
+	resps <- &Response{ConnID: "a", Response: respA1, Body: []byte("one")}
+	if p := <-pairs; p.Request != reqA1 || p.Response != respA1 || string(p.ResponseBody) != "one" {
+		t.Errorf("Expected first pair for connection a, got %v/%v\n", p.Request, p.Response)
+	}
+	resps <- &Response{ConnID: "a", Response: respA2}
+	if p := <-pairs; p.Request != reqA2 || p.Response != respA2 {
+		t.Errorf("Expected second pair for connection a, got %v/%v\n", p.Request, p.Response)
+	}
+
+	// Unmatched halves are flushed on close
+	resps <- &Response{ConnID: "c", Response: respA1}
+	close(reqs)
+	close(resps)
+	if p := <-pairs; p.Request != nil || p.Response != respA1 {
+		t.Errorf("Expected orphan response, got %v/%v\n", p.Request, p.Response)
+	}
+	if _, ok := <-pairs; ok {
+		t.Error("Expected pairs to be closed.\n")
+	}
+}
+
+func TestPairerOrphanTimeout(t *testing.T) {
+	reqs := make(chan *Request)
+	resps := make(chan *Response)
+	pairs := NewPairerTimeout(reqs, resps, 20*time.Millisecond)
+	req := &http.Request{Method: "GET"}
+	reqs <- &Request{ConnID: "a", Request: req}
+	select {
+	case p := <-pairs:
+		if p.Request != req || p.Response != nil {
+			t.Errorf("Expected orphan request, got %v/%v\n", p.Request, p.Response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for orphan request.\n")
+	}
+	close(reqs)
+	close(resps)
+}
+
+func TestPairerHTTP2Streams(t *testing.T) {
+	reqs := make(chan *Request, 2)
+	resps := make(chan *Response, 2)
+	pairs := NewPairerTimeout(reqs, resps, 0)
+
+	req1 := &http.Request{Method: "GET"}
+	req3 := &http.Request{Method: "POST"}
+	resp1 := &http.Response{StatusCode: 200}
+	resp3 := &http.Response{StatusCode: 201}
+	reqs <- &Request{ConnID: "a", StreamID: 1, Request: req1}
+	reqs <- &Request{ConnID: "a", StreamID: 3, Request: req3}
+	// Stream 3 is answered first
+	resps <- &Response{ConnID: "a", StreamID: 3, Response: resp3}
+	if p := <-pairs; p.Request != req3 || p.Response != resp3 || p.StreamID != 3 {
+		t.Errorf("Expected stream 3 pair, got %v/%v\n", p.Request, p.Response)
+	}
+	resps <- &Response{ConnID: "a", StreamID: 1, Response: resp1}
+	if p := <-pairs; p.Request != req1 || p.Response != resp1 || p.StreamID != 1 {
+		t.Errorf("Expected stream 1 pair, got %v/%v\n", p.Request, p.Response)
+	}
+	close(reqs)
+	close(resps)
+	if _, ok := <-pairs; ok {
+		t.Error("Expected no leftover halves.\n")
+	}
+}
+
+func TestPairerTinyWindow(t *testing.T) {
+	reqs := make(chan *Request, 1)
+	resps := make(chan *Response, 1)
+	pairs := NewPairerTimeout(reqs, resps, time.Nanosecond)
+	req := &http.Request{Method: "GET"}
+	reqs <- &Request{ConnID: "a", Request: req}
+	close(reqs)
+	close(resps)
+	if p := <-pairs; p.Request != req {
+		t.Errorf("Expected the orphaned request, got %v\n", p.Request)
+	}
+	if _, ok := <-pairs; ok {
+		t.Errorf("Expected output to be closed\n")
+	}
+}
@@ -0,0 +1,55 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ParsePair reads a raw HTTP request and its response, such as from a log
+// of HTTP exchanges.  Chunked bodies are decoded into the pair's buffers,
+// but the Transfer-Encoding header is kept so the headers are as they were
+// sent.  If the request was read but the rest of the exchange is broken,
+// the error is returned along with what was parsed, flagged Malformed.
+func ParsePair(req io.Reader, resp io.Reader) (*RequestResponsePair, error) {
+	r, err := http.ReadRequest(bufio.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	reqbuf, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = &bodyBuffer{bytes.NewReader(reqbuf)}
+	restoreTransferEncoding(r.Header, r.TransferEncoding)
+	pair := &RequestResponsePair{Request: r, RequestBody: reqbuf,
+		RequestTrailers: sentTrailers(r.Trailer), ID: requestID(r)}
+	if err != nil {
+		return pair.markMalformed("Incomplete request body: %v", err), err
+	}
+
+	rs, err := http.ReadResponse(bufio.NewReader(resp), r)
+	if err != nil {
+		return pair.markMalformed("Bad response: %v", err), err
+	}
+	respbuf, err := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	rs.Body = &bodyBuffer{bytes.NewReader(respbuf)}
+	restoreTransferEncoding(rs.Header, rs.TransferEncoding)
+	pair.Response = rs
+	pair.ResponseBody = respbuf
+	pair.ResponseTrailers = sentTrailers(rs.Trailer)
+	if err != nil {
+		return pair.markMalformed("Incomplete response body: %v", err), err
+	}
+	return pair, nil
+}
+
+// restoreTransferEncoding puts back the header net/http removes when it
+// decodes the body
+func restoreTransferEncoding(h http.Header, te []string) {
+	if len(te) > 0 && h.Get("Transfer-Encoding") == "" {
+		h.Set("Transfer-Encoding", strings.Join(te, ", "))
+	}
+}
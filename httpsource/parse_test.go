@@ -0,0 +1,76 @@
+package httpsource
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePair(t *testing.T) {
+	pair, err := ParsePair(
+		strings.NewReader("POST /upload HTTP/1.1\r\nHost: example.com\r\nX-Custom: yes\r\n"+
+			"Transfer-Encoding: chunked\r\n\r\n4\r\ndata\r\n0\r\n\r\n"),
+		strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"))
+	fatalIfErr(t, err)
+	if string(pair.RequestBody) != "data" || string(pair.ResponseBody) != "hello world" {
+		t.Errorf("Unexpected bodies: %q, %q\n", pair.RequestBody, pair.ResponseBody)
+	}
+	if pair.Request.Header.Get("X-Custom") != "yes" {
+		t.Error("Expected request headers to be kept.\n")
+	}
+	if te := pair.Response.Header.Get("Transfer-Encoding"); te != "chunked" {
+		t.Errorf("Expected Transfer-Encoding to be kept, got %q\n", te)
+	}
+	if pair.Response.StatusCode != 200 || pair.Response.Request != pair.Request {
+		t.Errorf("Unexpected response: %+v\n", pair.Response)
+	}
+
+	if _, err := ParsePair(strings.NewReader("garbage"), strings.NewReader("")); err == nil {
+		t.Error("Expected error for an invalid request.\n")
+	}
+	_, err = ParsePair(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"), strings.NewReader(""))
+	if err == nil {
+		t.Error("Expected error for a missing response.\n")
+	}
+}
+
+func TestPairTrailers(t *testing.T) {
+	pair, err := ParsePair(
+		strings.NewReader("POST /svc HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"4\r\ndata\r\n0\r\nX-Checksum: abc\r\n\r\n"),
+		strings.NewReader("HTTP/1.1 200 OK\r\nTrailer: Grpc-Status, Grpc-Message\r\n"+
+			"Transfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\ngrpc-status: 0\r\n\r\n"))
+	fatalIfErr(t, err)
+	if v := pair.TrailerValue("Grpc-Status"); v != "0" {
+		t.Errorf("Expected grpc-status trailer 0, got %q\n", v)
+	}
+	if v := pair.TrailerValue("x-checksum"); v != "abc" {
+		t.Errorf("Expected request trailer abc, got %q\n", v)
+	}
+	if _, ok := pair.ResponseTrailers["Grpc-Message"]; ok {
+		t.Error("Expected announced but unsent trailer to be left out.\n")
+	}
+	if pair.Clone().ResponseTrailers.Get("Grpc-Status") != "0" {
+		t.Error("Expected trailers to be cloned.\n")
+	}
+
+	buf, err := pair.MarshalJSON()
+	fatalIfErr(t, err)
+	var decoded RequestResponsePair
+	fatalIfErr(t, decoded.UnmarshalJSON(buf))
+	if decoded.TrailerValue("Grpc-Status") != "0" || decoded.RequestTrailers.Get("X-Checksum") != "abc" {
+		t.Errorf("Trailers lost in JSON: %s\n", buf)
+	}
+
+	entry, err := pair.ToHAREntry()
+	fatalIfErr(t, err)
+	if len(entry.Response.Trailers) != 1 || entry.Response.Trailers[0].Name != "Grpc-Status" ||
+		len(entry.Request.Trailers) != 1 {
+		t.Errorf("Unexpected HAR trailers: %+v, %+v\n", entry.Request.Trailers, entry.Response.Trailers)
+	}
+
+	plain := testPair(t, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if plain.RequestTrailers != nil || plain.ResponseTrailers != nil || plain.TrailerValue("Grpc-Status") != "" {
+		t.Error("Expected no trailers on a pair without them.\n")
+	}
+}
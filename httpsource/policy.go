@@ -0,0 +1,70 @@
+package httpsource
+
+import (
+	"fmt"
+	"time"
+)
+
+type dropKind int
+
+const (
+	dropBlock dropKind = iota
+	dropNewest
+	dropOldest
+	dropTimeout
+)
+
+// DropPolicy says what a PairMux does with a pair for an output whose
+// channel is full.  Policies are comparable, so a mux's policy can be
+// checked with ==.
+type DropPolicy struct {
+	kind    dropKind
+	timeout time.Duration
+}
+
+var (
+	// Block waits for the output to have room.  This is the default.
+	Block = DropPolicy{kind: dropBlock}
+	// DropNewest drops the pair that doesn't fit.
+	DropNewest = DropPolicy{kind: dropNewest}
+	// DropOldest discards the oldest buffered pair to make room for the
+	// newest.  Outputs are given a buffer of at least 1.
+	DropOldest = DropPolicy{kind: dropOldest}
+)
+
+// Timeout waits up to d for the output to have room before dropping the
+// pair.  A zero d is the same as DropNewest.
+func Timeout(d time.Duration) DropPolicy {
+	if d == 0 {
+		return DropNewest
+	}
+	return DropPolicy{kind: dropTimeout, timeout: d}
+}
+
+// String describes the policy.
+func (p DropPolicy) String() string {
+	switch p.kind {
+	case dropBlock:
+		return "block"
+	case dropNewest:
+		return "drop-newest"
+	case dropOldest:
+		return "drop-oldest"
+	case dropTimeout:
+		return fmt.Sprintf("timeout(%s)", p.timeout)
+	}
+	return fmt.Sprintf("DropPolicy(%d)", p.kind)
+}
+
+// writer returns the output writer implementing the policy
+func (p DropPolicy) writer() outputWriter {
+	switch p.kind {
+	case dropNewest:
+		return nonBlockingOutputWriter
+	case dropOldest:
+		return dropOldestOutputWriter
+	case dropTimeout:
+		return makeTimeoutOutputWriter(p.timeout)
+	}
+	return blockingOutputWriter
+}
@@ -0,0 +1,182 @@
+package httpsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// PostmanSchema identifies the Postman collection format written by
+// PairsToPostmanCollection.
+const PostmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanCollection struct {
+	Info postmanInfo   `json:"info"`
+	Item []postmanItem `json:"item"`
+}
+
+type postmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// postmanItem is either a folder of items or a single request
+type postmanItem struct {
+	Name     string            `json:"name"`
+	Item     []postmanItem     `json:"item,omitempty"`
+	Request  *postmanRequest   `json:"request,omitempty"`
+	Response []postmanResponse `json:"response,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string          `json:"method"`
+	Header []postmanHeader `json:"header"`
+	URL    postmanURL      `json:"url"`
+	Body   *postmanBody    `json:"body,omitempty"`
+}
+
+type postmanHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanURL struct {
+	Raw      string          `json:"raw"`
+	Protocol string          `json:"protocol,omitempty"`
+	Host     []string        `json:"host,omitempty"`
+	Port     string          `json:"port,omitempty"`
+	Path     []string        `json:"path,omitempty"`
+	Query    []postmanHeader `json:"query,omitempty"`
+}
+
+type postmanBody struct {
+	Mode    string              `json:"mode"`
+	Raw     string              `json:"raw"`
+	Options *postmanBodyOptions `json:"options,omitempty"`
+}
+
+type postmanBodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+type postmanResponse struct {
+	Name            string          `json:"name"`
+	OriginalRequest *postmanRequest `json:"originalRequest"`
+	Status          string          `json:"status"`
+	Code            int             `json:"code"`
+	Header          []postmanHeader `json:"header"`
+	Body            string          `json:"body"`
+	PreviewLanguage string          `json:"_postman_previewlanguage,omitempty"`
+}
+
+// PairsToPostmanCollection builds a Postman v2.1 collection called name,
+// with a folder per host holding a request item for each pair in capture
+// order.  The captured response, if any, is saved as the item's example.
+// Folders are sorted by host.  Postman can't hold binary bodies, so bodies
+// that are not valid UTF-8 are left out.  Pairs without a request, and
+// WebSocket frames, are skipped.
+func PairsToPostmanCollection(name string, pairs []*RequestResponsePair) ([]byte, error) {
+	folders := make(map[string][]postmanItem)
+	for _, p := range pairs {
+		if p.Request == nil || p.Request.URL == nil || p.WSFrame != nil {
+			continue
+		}
+		u := absoluteURL(p.Request)
+		req := postmanRequestFor(p.Request, u, p.RequestBody)
+		path := u.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		item := postmanItem{Name: p.Request.Method + " " + path, Request: req}
+		if resp := p.Response; resp != nil {
+			lang := postmanLanguage(resp.Header)
+			item.Response = []postmanResponse{{
+				Name:            fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+				OriginalRequest: req,
+				Status:          http.StatusText(resp.StatusCode),
+				Code:            resp.StatusCode,
+				Header:          postmanHeaders(resp.Header),
+				PreviewLanguage: lang,
+			}}
+			if utf8.Valid(p.ResponseBody) {
+				item.Response[0].Body = string(p.ResponseBody)
+			}
+		}
+		folders[u.Host] = append(folders[u.Host], item)
+	}
+	hosts := make([]string, 0, len(folders))
+	for host := range folders {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	collection := postmanCollection{
+		Info: postmanInfo{Name: name, Schema: PostmanSchema},
+		Item: make([]postmanItem, 0, len(hosts)),
+	}
+	for _, host := range hosts {
+		collection.Item = append(collection.Item, postmanItem{Name: host, Item: folders[host]})
+	}
+	return json.MarshalIndent(&collection, "", "  ")
+}
+
+func postmanRequestFor(req *http.Request, u *url.URL, body []byte) *postmanRequest {
+	pr := &postmanRequest{
+		Method: req.Method,
+		Header: postmanHeaders(req.Header),
+		URL: postmanURL{
+			Raw:      u.String(),
+			Protocol: u.Scheme,
+			Host:     strings.Split(u.Hostname(), "."),
+			Port:     u.Port(),
+		},
+	}
+	if path := strings.Trim(u.EscapedPath(), "/"); path != "" {
+		pr.URL.Path = strings.Split(path, "/")
+	}
+	for _, qs := range harQueryString(u) {
+		pr.URL.Query = append(pr.URL.Query, postmanHeader{Key: qs.Name, Value: qs.Value})
+	}
+	if len(body) > 0 && utf8.Valid(body) {
+		pr.Body = &postmanBody{Mode: "raw", Raw: string(body)}
+		if lang := postmanLanguage(req.Header); lang != "" {
+			pr.Body.Options = &postmanBodyOptions{}
+			pr.Body.Options.Raw.Language = lang
+		}
+	}
+	return pr
+}
+
+func postmanHeaders(h http.Header) []postmanHeader {
+	hdrs := make([]postmanHeader, 0, len(h))
+	for _, nv := range harHeaders(h) {
+		hdrs = append(hdrs, postmanHeader{Key: nv.Name, Value: nv.Value})
+	}
+	return hdrs
+}
+
+// postmanLanguage picks the Postman editor language for a body with the
+// Content-Type in h, or an empty string for plain text
+func postmanLanguage(h http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "text/html":
+		return "html"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return "xml"
+	case mediaType == "application/javascript" || mediaType == "text/javascript":
+		return "javascript"
+	}
+	return ""
+}
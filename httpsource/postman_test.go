@@ -0,0 +1,50 @@
+package httpsource
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPairsToPostmanCollection(t *testing.T) {
+	pairs := []*RequestResponsePair{
+		testPair(t, "POST /api/items?debug=1 HTTP/1.1\r\nHost: b.example.com:8080\r\n"+
+			"Content-Type: application/json\r\nContent-Length: 9\r\n\r\n{\"id\":1}\n",
+			"HTTP/1.1 201 Created\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"),
+		testPair(t, "GET / HTTP/1.1\r\nHost: a.example.com\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n\xff\xfe"),
+		testPair(t, "GET /other HTTP/1.1\r\nHost: b.example.com:8080\r\n\r\n",
+			"HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"),
+		{},
+	}
+	buf, err := PairsToPostmanCollection("capture", pairs)
+	fatalIfErr(t, err)
+	var c postmanCollection
+	fatalIfErr(t, json.Unmarshal(buf, &c))
+	if c.Info.Name != "capture" || c.Info.Schema != PostmanSchema {
+		t.Errorf("Unexpected info: %+v\n", c.Info)
+	}
+	if len(c.Item) != 2 || c.Item[0].Name != "a.example.com" || c.Item[1].Name != "b.example.com:8080" {
+		t.Fatalf("Expected a folder per host, got %s\n", buf)
+	}
+	b := c.Item[1].Item
+	if len(b) != 2 || b[0].Name != "POST /api/items" || b[1].Name != "GET /other" {
+		t.Fatalf("Unexpected items: %s\n", buf)
+	}
+	req := b[0].Request
+	if req.Method != "POST" || req.URL.Raw != "http://b.example.com:8080/api/items?debug=1" ||
+		req.URL.Port != "8080" || len(req.URL.Host) != 3 || len(req.URL.Path) != 2 ||
+		len(req.URL.Query) != 1 || req.URL.Query[0].Key != "debug" {
+		t.Errorf("Unexpected request: %+v\n", req)
+	}
+	if req.Body == nil || req.Body.Raw != "{\"id\":1}\n" || req.Body.Options == nil ||
+		req.Body.Options.Raw.Language != "json" {
+		t.Errorf("Unexpected body: %+v\n", req.Body)
+	}
+	if len(b[0].Response) != 1 || b[0].Response[0].Code != 201 || b[0].Response[0].Body != "{}" ||
+		b[0].Response[0].OriginalRequest == nil {
+		t.Errorf("Unexpected example response: %+v\n", b[0].Response)
+	}
+	if a := c.Item[0].Item; len(a) != 1 || a[0].Name != "GET /" || a[0].Response[0].Body != "" {
+		t.Errorf("Expected binary response body to be left out: %+v\n", a)
+	}
+}
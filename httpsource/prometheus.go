@@ -0,0 +1,64 @@
+package httpsource
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync/atomic"
+)
+
+// muxCollector exports PairMux statistics as Prometheus metrics
+type muxCollector struct {
+	mux         *PairMux
+	written     *prometheus.Desc
+	dropped     *prometheus.Desc
+	evicted     *prometheus.Desc
+	rateLimited *prometheus.Desc
+	depth       *prometheus.Desc
+	capacity    *prometheus.Desc
+}
+
+// Collector returns a prometheus.Collector exposing the per-output counters
+// and channel depths of the mux, labelled by output name.
+func (m *PairMux) Collector(namespace string) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pairmux", name),
+			help, []string{"output"}, nil)
+	}
+	return &muxCollector{
+		mux:         m,
+		written:     desc("written_total", "Pairs written to the output."),
+		dropped:     desc("dropped_total", "Pairs that could not be written to the output."),
+		evicted:     desc("evicted_total", "Buffered pairs discarded to make room for newer ones."),
+		rateLimited: desc("rate_limited_total", "Pairs dropped by the output's rate limit."),
+		depth:       desc("depth", "Pairs buffered in the output channel."),
+		capacity:    desc("capacity", "Capacity of the output channel."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *muxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.written
+	ch <- c.dropped
+	ch <- c.evicted
+	ch <- c.rateLimited
+	ch <- c.depth
+	ch <- c.capacity
+}
+
+// Collect implements prometheus.Collector
+func (c *muxCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.mux
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, o := range m.outputs {
+		counter := func(d *prometheus.Desc, v *uint64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue,
+				float64(atomic.LoadUint64(v)), o.name)
+		}
+		counter(c.written, &o.stats.Written)
+		counter(c.dropped, &o.stats.Dropped)
+		counter(c.evicted, &o.stats.Evicted)
+		counter(c.rateLimited, &o.stats.RateLimited)
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(len(o.dst)), o.name)
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(cap(o.dst)), o.name)
+	}
+}
@@ -0,0 +1,35 @@
+package httpsource
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestMuxCollector(t *testing.T) {
+	src := make(chan *RequestResponsePair, 1)
+	m := NewNonBlockingPairMux(src, 0)
+	m.Logger = &recordingLogger{}
+	m.AddOutput("out", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m.Collector("httpwatch")); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP httpwatch_pairmux_dropped_total Pairs that could not be written to the output.
+# TYPE httpwatch_pairmux_dropped_total counter
+httpwatch_pairmux_dropped_total{output="out"} 1
+# HELP httpwatch_pairmux_written_total Pairs written to the output.
+# TYPE httpwatch_pairmux_written_total counter
+httpwatch_pairmux_written_total{output="out"} 0
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"httpwatch_pairmux_dropped_total", "httpwatch_pairmux_written_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,429 @@
+package httpsource
+
+import (
+	"encoding/binary"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Field numbers from pair.proto
+const (
+	protoPairRequest     = 1
+	protoPairResponse    = 2
+	protoPairTimestamp   = 3
+	protoPairResponseEnd = 4
+	protoPairSeq         = 5
+	protoPairID          = 6
+	protoPairStreamID    = 7
+	protoPairMalformed   = 8
+	protoPairRepeatCount = 9
+	protoPairResolved    = 10
+	protoPairConn        = 11
+	protoPairWSFrame     = 12
+
+	protoReqMethod     = 1
+	protoReqURL        = 2
+	protoReqHost       = 3
+	protoReqProto      = 4
+	protoReqHeaders    = 5
+	protoReqBody       = 6
+	protoReqRemoteAddr = 7
+	protoReqTrailers   = 8
+
+	protoRespStatusCode = 1
+	protoRespStatus     = 2
+	protoRespProto      = 3
+	protoRespHeaders    = 4
+	protoRespBody       = 5
+	protoRespTrailers   = 6
+
+	protoConnClientAddr  = 1
+	protoConnServerAddr  = 2
+	protoConnTLSVersion  = 3
+	protoConnCipherSuite = 4
+	protoConnALPN        = 5
+	protoConnSNI         = 6
+
+	protoWSFromClient = 1
+	protoWSOpcode     = 2
+	protoWSFin        = 3
+	protoWSPayload    = 4
+
+	protoHeaderName  = 1
+	protoHeaderValue = 2
+)
+
+// ProtoCodec reads and writes pairs as length-prefixed protobuf messages,
+// using the Pair message of pair.proto.  Records larger than 64MB are
+// skipped.
+type ProtoCodec struct{}
+
+// Encode writes p as a varint length followed by a Pair message.
+func (ProtoCodec) Encode(w io.Writer, p *RequestResponsePair) error {
+	msg := marshalProtoPair(p)
+	buf := protowire.AppendVarint(make([]byte, 0, len(msg)+binary.MaxVarintLen64), uint64(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// Decode reads the next length-prefixed Pair message.  If r is not an
+// io.ByteReader, the length is read a byte at a time.
+func (ProtoCodec) Decode(r io.Reader) (*RequestResponsePair, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if size > maxPairLine {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(size)); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, &RecordError{fmt.Errorf("Record longer than %d bytes", maxPairLine)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	p, err := unmarshalProtoPair(msg)
+	if err != nil {
+		return nil, &RecordError{err}
+	}
+	return p, nil
+}
+
+// byteReader reads single bytes from a reader without buffering
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(b.r, b.buf[:])
+	return b.buf[0], err
+}
+
+func marshalProtoPair(p *RequestResponsePair) []byte {
+	var b []byte
+	if req := p.Request; req != nil {
+		var m []byte
+		m = appendProtoString(m, protoReqMethod, req.Method)
+		if req.URL != nil {
+			m = appendProtoString(m, protoReqURL, req.URL.String())
+		}
+		m = appendProtoString(m, protoReqHost, req.Host)
+		m = appendProtoString(m, protoReqProto, req.Proto)
+		m = appendProtoHeaders(m, protoReqHeaders, req.Header)
+		m = appendProtoBytes(m, protoReqBody, p.RequestBody)
+		m = appendProtoString(m, protoReqRemoteAddr, req.RemoteAddr)
+		m = appendProtoHeaders(m, protoReqTrailers, p.RequestTrailers)
+		b = protowire.AppendTag(b, protoPairRequest, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	if resp := p.Response; resp != nil {
+		var m []byte
+		if resp.StatusCode != 0 {
+			m = protowire.AppendTag(m, protoRespStatusCode, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(int64(resp.StatusCode)))
+		}
+		m = appendProtoString(m, protoRespStatus, resp.Status)
+		m = appendProtoString(m, protoRespProto, resp.Proto)
+		m = appendProtoHeaders(m, protoRespHeaders, resp.Header)
+		m = appendProtoBytes(m, protoRespBody, p.ResponseBody)
+		m = appendProtoHeaders(m, protoRespTrailers, p.ResponseTrailers)
+		b = protowire.AppendTag(b, protoPairResponse, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	for _, t := range []struct {
+		num protowire.Number
+		t   time.Time
+	}{{protoPairTimestamp, p.Timestamp}, {protoPairResponseEnd, p.ResponseEnd}} {
+		if !t.t.IsZero() {
+			b = protowire.AppendTag(b, t.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(t.t.UnixNano()))
+		}
+	}
+	b = appendProtoVarint(b, protoPairSeq, p.Seq)
+	b = appendProtoString(b, protoPairID, p.ID)
+	b = appendProtoVarint(b, protoPairStreamID, uint64(p.StreamID))
+	if p.Malformed {
+		reason := p.MalformedReason
+		if reason == "" {
+			reason = "malformed"
+		}
+		b = appendProtoString(b, protoPairMalformed, reason)
+	}
+	b = appendProtoVarint(b, protoPairRepeatCount, uint64(int64(p.RepeatCount)))
+	b = appendProtoString(b, protoPairResolved, p.ResolvedHost)
+	if c := p.ConnInfo; !c.IsZero() {
+		var m []byte
+		m = appendProtoString(m, protoConnClientAddr, c.ClientAddr)
+		m = appendProtoString(m, protoConnServerAddr, c.ServerAddr)
+		m = appendProtoVarint(m, protoConnTLSVersion, uint64(c.TLSVersion))
+		m = appendProtoVarint(m, protoConnCipherSuite, uint64(c.CipherSuite))
+		m = appendProtoString(m, protoConnALPN, c.ALPN)
+		m = appendProtoString(m, protoConnSNI, c.SNI)
+		b = protowire.AppendTag(b, protoPairConn, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	if f := p.WSFrame; f != nil {
+		var m []byte
+		m = appendProtoVarint(m, protoWSFromClient, protowire.EncodeBool(f.FromClient))
+		m = appendProtoVarint(m, protoWSOpcode, uint64(f.Opcode))
+		m = appendProtoVarint(m, protoWSFin, protowire.EncodeBool(f.Fin))
+		m = appendProtoBytes(m, protoWSPayload, f.Payload)
+		b = protowire.AppendTag(b, protoPairWSFrame, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	return b
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, x uint64) []byte {
+	if x == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, x)
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendProtoHeaders appends a Header message per value, sorted by name
+func appendProtoHeaders(b []byte, num protowire.Number, h http.Header) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, val := range h[name] {
+			var m []byte
+			m = appendProtoString(m, protoHeaderName, name)
+			m = appendProtoString(m, protoHeaderValue, val)
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, m)
+		}
+	}
+	return b
+}
+
+// parseProtoFields calls fn with each bytes or varint field of a message,
+// skipping fields of other types
+func parseProtoFields(b []byte, fn func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType || typ == protowire.VarintType {
+			if err := fn(num, v, x); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unmarshalProtoPair(b []byte) (*RequestResponsePair, error) {
+	p := &RequestResponsePair{}
+	var reqMsg, respMsg, connMsg, frameMsg []byte
+	err := parseProtoFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case protoPairRequest:
+			reqMsg = v
+		case protoPairResponse:
+			respMsg = v
+		case protoPairTimestamp:
+			p.Timestamp = time.Unix(0, int64(x)).UTC()
+		case protoPairResponseEnd:
+			p.ResponseEnd = time.Unix(0, int64(x)).UTC()
+		case protoPairSeq:
+			p.Seq = x
+		case protoPairID:
+			p.ID = string(v)
+		case protoPairStreamID:
+			p.StreamID = uint32(x)
+		case protoPairMalformed:
+			p.markMalformed("%s", v)
+		case protoPairRepeatCount:
+			p.RepeatCount = int(int64(x))
+		case protoPairResolved:
+			p.ResolvedHost = string(v)
+		case protoPairConn:
+			connMsg = v
+		case protoPairWSFrame:
+			frameMsg = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if connMsg != nil {
+		err := parseProtoFields(connMsg, func(num protowire.Number, v []byte, x uint64) error {
+			switch num {
+			case protoConnClientAddr:
+				p.ConnInfo.ClientAddr = string(v)
+			case protoConnServerAddr:
+				p.ConnInfo.ServerAddr = string(v)
+			case protoConnTLSVersion:
+				p.ConnInfo.TLSVersion = uint16(x)
+			case protoConnCipherSuite:
+				p.ConnInfo.CipherSuite = uint16(x)
+			case protoConnALPN:
+				p.ConnInfo.ALPN = string(v)
+			case protoConnSNI:
+				p.ConnInfo.SNI = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if frameMsg != nil {
+		p.WSFrame = &WSFrame{}
+		err := parseProtoFields(frameMsg, func(num protowire.Number, v []byte, x uint64) error {
+			switch num {
+			case protoWSFromClient:
+				p.WSFrame.FromClient = protowire.DecodeBool(x)
+			case protoWSOpcode:
+				p.WSFrame.Opcode = byte(x)
+			case protoWSFin:
+				p.WSFrame.Fin = protowire.DecodeBool(x)
+			case protoWSPayload:
+				p.WSFrame.Payload = cloneBytes(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reqMsg != nil {
+		var method, rawURL, host, proto, remoteAddr string
+		header := make(http.Header)
+		err := parseProtoFields(reqMsg, func(num protowire.Number, v []byte, x uint64) error {
+			switch num {
+			case protoReqMethod:
+				method = string(v)
+			case protoReqURL:
+				rawURL = string(v)
+			case protoReqHost:
+				host = string(v)
+			case protoReqProto:
+				proto = string(v)
+			case protoReqHeaders:
+				return parseProtoHeader(v, header)
+			case protoReqBody:
+				p.RequestBody = cloneBytes(v)
+			case protoReqRemoteAddr:
+				remoteAddr = string(v)
+			case protoReqTrailers:
+				if p.RequestTrailers == nil {
+					p.RequestTrailers = make(http.Header)
+				}
+				return parseProtoHeader(v, p.RequestTrailers)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.Request, err = buildRequest(method, rawURL, host, remoteAddr, proto, header, p.RequestBody)
+		if err != nil {
+			return nil, err
+		}
+		p.Request.Trailer = p.RequestTrailers
+	}
+	if respMsg != nil {
+		var status, proto string
+		var code int
+		header := make(http.Header)
+		err := parseProtoFields(respMsg, func(num protowire.Number, v []byte, x uint64) error {
+			switch num {
+			case protoRespStatusCode:
+				code = int(int32(x))
+			case protoRespStatus:
+				status = string(v)
+			case protoRespProto:
+				proto = string(v)
+			case protoRespHeaders:
+				return parseProtoHeader(v, header)
+			case protoRespBody:
+				p.ResponseBody = cloneBytes(v)
+			case protoRespTrailers:
+				if p.ResponseTrailers == nil {
+					p.ResponseTrailers = make(http.Header)
+				}
+				return parseProtoHeader(v, p.ResponseTrailers)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.Response = buildResponse(status, code, proto, header, p.ResponseBody, p.Request)
+		p.Response.Trailer = p.ResponseTrailers
+	}
+	return p, nil
+}
+
+// parseProtoHeader adds the value of a Header message to h
+func parseProtoHeader(b []byte, h http.Header) error {
+	var name, value string
+	err := parseProtoFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case protoHeaderName:
+			name = string(v)
+		case protoHeaderValue:
+			value = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Keep the name as sent rather than canonicalizing it
+	h[name] = append(h[name], value)
+	return nil
+}
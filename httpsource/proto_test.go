@@ -0,0 +1,112 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProtoCodecParity(t *testing.T) {
+	pair := testPair(t,
+		"POST /submit?a=1 HTTP/1.1\r\nHost: example.com\r\nX-Test: a\r\nX-Test: b\r\n"+
+			"Content-Length: 4\r\n\r\ndata",
+		"HTTP/1.1 201 Created\r\nContent-Type: application/octet-stream\r\n"+
+			"Content-Length: 2\r\n\r\n\xff\xfe")
+	pair.Request.RemoteAddr = "10.0.0.1:51234"
+	pair.Timestamp = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	pair.ResponseEnd = pair.Timestamp.Add(time.Millisecond)
+	pair.Seq = 7
+	pair.ID = "req-7"
+	pair.StreamID = 3
+	pair.RepeatCount = 2
+	pair.ResolvedHost = "web.example.com"
+	pair.ConnInfo = ConnInfo{ClientAddr: "10.0.0.1:51234", ServerAddr: "10.0.0.2:443",
+		TLSVersion: 0x0304, CipherSuite: 0x1301, ALPN: "h2", SNI: "example.com"}
+	pair.RequestTrailers = http.Header{"X-Checksum": {"abc"}}
+	pair.ResponseTrailers = http.Header{"Grpc-Status": {"0"}}
+	pair.markMalformed("Bad response: %s", "test")
+
+	decode := func(codec Codec, pair *RequestResponsePair) *RequestResponsePair {
+		var buf bytes.Buffer
+		fatalIfErr(t, codec.Encode(&buf, pair))
+		decoded, err := codec.Decode(bufio.NewReader(&buf))
+		fatalIfErr(t, err)
+		return decoded
+	}
+	j, p := decode(JSONCodec{}, pair), decode(ProtoCodec{}, pair)
+
+	if p.Request.Method != j.Request.Method || p.Request.URL.String() != j.Request.URL.String() ||
+		p.Request.Host != j.Request.Host || p.Request.Proto != j.Request.Proto ||
+		p.Request.ProtoMinor != j.Request.ProtoMinor || p.Request.RemoteAddr != j.Request.RemoteAddr {
+		t.Errorf("Request mismatch: %+v vs %+v\n", p.Request, j.Request)
+	}
+	if !reflect.DeepEqual(p.Request.Header, j.Request.Header) ||
+		!reflect.DeepEqual(p.Response.Header, j.Response.Header) {
+		t.Errorf("Header mismatch: %v %v vs %v %v\n", p.Request.Header, p.Response.Header,
+			j.Request.Header, j.Response.Header)
+	}
+	if p.Response.StatusCode != j.Response.StatusCode || p.Response.Status != j.Response.Status ||
+		p.Response.Proto != j.Response.Proto {
+		t.Errorf("Response mismatch: %+v vs %+v\n", p.Response, j.Response)
+	}
+	if !bytes.Equal(p.RequestBody, j.RequestBody) || !bytes.Equal(p.ResponseBody, j.ResponseBody) {
+		t.Errorf("Body mismatch: %q %q vs %q %q\n", p.RequestBody, p.ResponseBody, j.RequestBody, j.ResponseBody)
+	}
+	if !p.Timestamp.Equal(j.Timestamp) || !p.ResponseEnd.Equal(j.ResponseEnd) || p.Seq != j.Seq {
+		t.Errorf("Metadata mismatch: %v %v %d vs %v %v %d\n", p.Timestamp, p.ResponseEnd, p.Seq,
+			j.Timestamp, j.ResponseEnd, j.Seq)
+	}
+	if p.ID != "req-7" || p.ID != j.ID || p.StreamID != j.StreamID || p.RepeatCount != j.RepeatCount ||
+		p.ResolvedHost != j.ResolvedHost || p.ConnInfo != j.ConnInfo || p.ConnInfo != pair.ConnInfo {
+		t.Errorf("Pair field mismatch: %+v vs %+v\n", p, j)
+	}
+	if !p.Malformed || p.MalformedReason != j.MalformedReason || p.MalformedReason != "Bad response: test" {
+		t.Errorf("Malformed mismatch: %q vs %q\n", p.MalformedReason, j.MalformedReason)
+	}
+	if !reflect.DeepEqual(p.RequestTrailers, j.RequestTrailers) || !reflect.DeepEqual(p.ResponseTrailers, j.ResponseTrailers) ||
+		!reflect.DeepEqual(p.ResponseTrailers, pair.ResponseTrailers) || !reflect.DeepEqual(p.Response.Trailer, j.Response.Trailer) {
+		t.Errorf("Trailer mismatch: %v %v vs %v %v\n", p.RequestTrailers, p.ResponseTrailers,
+			j.RequestTrailers, j.ResponseTrailers)
+	}
+
+	frame := &RequestResponsePair{Request: pair.Request, ConnInfo: pair.ConnInfo, ID: "req-7",
+		WSFrame: &WSFrame{FromClient: true, Opcode: 1, Fin: true, Payload: []byte("hi")}}
+	j, p = decode(JSONCodec{}, frame), decode(ProtoCodec{}, frame)
+	if p.WSFrame == nil || !reflect.DeepEqual(p.WSFrame, j.WSFrame) || !reflect.DeepEqual(p.WSFrame, frame.WSFrame) ||
+		p.Response != nil || j.Response != nil {
+		t.Errorf("WebSocket frame mismatch: %+v vs %+v\n", p.WSFrame, j.WSFrame)
+	}
+}
+
+func TestProtoCodecStream(t *testing.T) {
+	var buf bytes.Buffer
+	codec := ProtoCodec{}
+	for _, seq := range []uint64{1, 2} {
+		fatalIfErr(t, codec.Encode(&buf, &RequestResponsePair{Seq: seq}))
+	}
+	// A record that can't be parsed is skipped
+	buf.Write([]byte{2, 0xff, 0xff})
+	fatalIfErr(t, codec.Encode(&buf, &RequestResponsePair{Seq: 3}))
+
+	pairs, err := NewFileSourceWithCodec(&buf, codec)
+	fatalIfErr(t, err)
+	var seqs []uint64
+	for p := range pairs {
+		seqs = append(seqs, p.Seq)
+	}
+	if !reflect.DeepEqual(seqs, []uint64{1, 2, 3}) {
+		t.Errorf("Expected seqs 1, 2, 3, got %v\n", seqs)
+	}
+
+	// A truncated record is an error rather than a skipped record
+	var short bytes.Buffer
+	fatalIfErr(t, codec.Encode(&short, &RequestResponsePair{Seq: 1}))
+	short.Truncate(short.Len() - 1)
+	if _, err := codec.Decode(&short); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v\n", err)
+	}
+}
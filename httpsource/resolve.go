@@ -0,0 +1,110 @@
+package httpsource
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultResolveEntries is how many addresses ResolveHosts remembers.
+const DefaultResolveEntries = 1024
+
+// DefaultResolveTimeout bounds each reverse lookup made by ResolveHosts.
+const DefaultResolveTimeout = 2 * time.Second
+
+// Resolver does reverse DNS lookups.  *net.Resolver satisfies it.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+type resolveEntry struct {
+	ip   string
+	host string
+}
+
+// ResolveHosts reverse resolves the server IP of each pair from in,
+// forwarding a Clone of the pair with ResolvedHost set to the first name
+// found.  The IP is taken from ConnInfo.ServerAddr, or the request's Host if
+// that is an IP address.  Lookups time out after DefaultResolveTimeout, and
+// the results for the last DefaultResolveEntries addresses are remembered,
+// failures included, so an unresolvable address only stalls the pipeline
+// once.  Pairs with no server IP, or whose lookup failed, are forwarded
+// unchanged.  A nil resolver uses net.DefaultResolver.  The returned channel
+// is closed once in is closed.
+func ResolveHosts(in <-chan *RequestResponsePair, resolver Resolver) <-chan *RequestResponsePair {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	out := make(chan *RequestResponsePair, cap(in))
+	go func() {
+		defer close(out)
+		lru := list.New()
+		seen := make(map[string]*list.Element)
+		for pair := range in {
+			ip := serverIP(pair)
+			if ip == "" {
+				out <- pair
+				continue
+			}
+			var host string
+			if el, ok := seen[ip]; ok {
+				host = el.Value.(*resolveEntry).host
+				lru.MoveToFront(el)
+			} else {
+				host = reverseLookup(resolver, ip)
+				seen[ip] = lru.PushFront(&resolveEntry{ip: ip, host: host})
+				if lru.Len() > DefaultResolveEntries {
+					oldest := lru.Back()
+					lru.Remove(oldest)
+					delete(seen, oldest.Value.(*resolveEntry).ip)
+				}
+			}
+			if host == "" {
+				out <- pair
+				continue
+			}
+			c := pair.Clone()
+			c.ResolvedHost = host
+			out <- c
+		}
+	}()
+	return out
+}
+
+// reverseLookup returns the first name for ip, or an empty string if there
+// is none
+func reverseLookup(resolver Resolver, ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultResolveTimeout)
+	defer cancel()
+	names, err := resolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		if err != nil {
+			logger.Printf("Unable to resolve %s: %s\n", ip, err)
+		}
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// serverIP returns the IP address a pair was sent to, if known
+func serverIP(p *RequestResponsePair) string {
+	if p.ConnInfo.ServerAddr != "" {
+		if host, _, err := net.SplitHostPort(p.ConnInfo.ServerAddr); err == nil && net.ParseIP(host) != nil {
+			return host
+		}
+	}
+	if p.Request == nil {
+		return ""
+	}
+	host := p.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package httpsource
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeResolver struct {
+	names map[string][]string
+	calls map[string]int
+}
+
+func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	f.calls[addr]++
+	if names, ok := f.names[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestResolveHosts(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{"10.0.0.1": {"web.example.com.", "alias.example.com."}},
+		calls: make(map[string]int),
+	}
+	known := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	known.ConnInfo.ServerAddr = "10.0.0.1:80"
+	literal := testPair(t, "GET / HTTP/1.1\r\nHost: 10.0.0.1:8080\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	unknown := testPair(t, "GET / HTTP/1.1\r\nHost: [::1]:80\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	named := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	in := make(chan *RequestResponsePair, 5)
+	for _, p := range []*RequestResponsePair{known, literal, unknown, unknown, named} {
+		in <- p
+	}
+	close(in)
+	var got []*RequestResponsePair
+	for p := range ResolveHosts(in, resolver) {
+		got = append(got, p)
+	}
+	if len(got) != 5 {
+		t.Fatalf("Expected 5 pairs, got %d\n", len(got))
+	}
+	for i, want := range []string{"web.example.com", "web.example.com", "", "", ""} {
+		if got[i].ResolvedHost != want {
+			t.Errorf("Pair %d: expected %q, got %q\n", i, want, got[i].ResolvedHost)
+		}
+	}
+	if known.ResolvedHost != "" {
+		t.Errorf("Input pair was modified\n")
+	}
+	if got[4] != named {
+		t.Errorf("Expected pair without an IP to pass through\n")
+	}
+	if resolver.calls["10.0.0.1"] != 1 {
+		t.Errorf("Expected 1 lookup of 10.0.0.1, got %d\n", resolver.calls["10.0.0.1"])
+	}
+	if resolver.calls["::1"] != 1 {
+		t.Errorf("Expected failed lookup to be cached, got %d lookups\n", resolver.calls["::1"])
+	}
+}
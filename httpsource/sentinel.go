@@ -0,0 +1,96 @@
+package httpsource
+
+// The reasons a mux gives for shutting down in a Sentinel
+const (
+	StopSourceClosed = "source closed"
+	StopRequested    = "stopped"
+	StopCancelled    = "context cancelled"
+	StopMaxPairs     = "max pairs reached"
+	StopDrained      = "drained"
+)
+
+// Sentinel summarizes a mux's run for one output.  It is carried by the
+// final pair sent to each output of a mux with SendSentinelOnClose set.
+type Sentinel struct {
+	// Output is the name of the output the sentinel was sent to
+	Output string
+	// Processed is the number of pairs that entered the mux
+	Processed uint64
+	// Reason is why the mux shut down, one of the Stop constants
+	Reason string
+	// Stats are the output's final counters
+	Stats OutputStats
+}
+
+// IsSentinel reports whether the pair is the end-of-stream marker sent by
+// a mux with SendSentinelOnClose set, rather than a captured exchange.
+func (p *RequestResponsePair) IsSentinel() bool {
+	return p.Sentinel != nil
+}
+
+// stopReason works out why the mux's run loop ended
+func (m *PairMux) stopReason(err error) string {
+	m.stepLock.Lock()
+	limited := m.limited
+	m.stepLock.Unlock()
+	if limited {
+		return StopMaxPairs
+	}
+	select {
+	case <-m.stop:
+		return StopRequested
+	default:
+	}
+	if m.draining() {
+		return StopDrained
+	}
+	if err != nil {
+		return StopCancelled
+	}
+	return StopSourceClosed
+}
+
+// sendSentinels writes a Sentinel pair to each output about to be closed.
+// Sentinels are written under the mux's DropPolicy, so only a blocking mux
+// waits for the consumer, and then not once the mux is being stopped or the
+// output removed; in that case, as when the policy drops it, the sentinel
+// is only sent if there is room.
+// Counters get none, as nothing reads from them.  Holding stepLock keeps
+// RemoveOutput from closing a channel while it is written to.
+func (m *PairMux) sendSentinels(reason string) {
+	m.stepLock.Lock()
+	defer m.stepLock.Unlock()
+	m.lock.Lock()
+	outputs := make([]output, len(m.outputs))
+	copy(outputs, m.outputs)
+	m.lock.Unlock()
+	for _, o := range outputs {
+		if o.observer {
+			continue
+		}
+		sentinel := &RequestResponsePair{Sentinel: &Sentinel{
+			Output:    o.name,
+			Processed: m.seq,
+			Reason:    reason,
+			Stats:     o.stats.snapshot(),
+		}}
+		if m.policy.kind != dropBlock {
+			if m.writer(m, o, sentinel) {
+				continue
+			}
+		} else {
+			select {
+			case o.dst <- sentinel:
+				continue
+			case <-o.removed:
+				continue
+			case <-o.stopped:
+			}
+		}
+		select {
+		case o.dst <- sentinel:
+		default:
+			m.log().Warnf("PairMux dropped sentinel for output %s.\n", o.name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMuxSentinel(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewPairMux(src, WithSendSentinelOnClose())
+	a := m.MustAddOutput("a", 0)
+	if _, err := m.AddCounter("count", nil); err != nil {
+		t.Fatal(err)
+	}
+	src <- &RequestResponsePair{}
+	src <- &RequestResponsePair{}
+	close(src)
+	m.Start()
+	var pairs []*RequestResponsePair
+	for pair := range a {
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) != 3 {
+		t.Fatalf("Expected 2 pairs and a sentinel, got %d\n", len(pairs))
+	}
+	if pairs[0].IsSentinel() || !pairs[2].IsSentinel() {
+		t.Fatal("Expected only the last pair to be a sentinel.\n")
+	}
+	s := pairs[2].Sentinel
+	if s.Output != "a" || s.Processed != 2 || s.Reason != StopSourceClosed || s.Stats.Written != 2 {
+		t.Errorf("Unexpected sentinel: %+v\n", s)
+	}
+}
+
+func TestMuxSentinelOnStop(t *testing.T) {
+	m := NewPairMux(make(chan *RequestResponsePair), WithSendSentinelOnClose())
+	roomy := m.MustAddOutput("roomy", 1)
+	full := m.MustAddOutput("full", 0)
+	m.Stop()
+	if p := <-roomy; p == nil || !p.IsSentinel() || p.Sentinel.Reason != StopRequested {
+		t.Errorf("Expected a stop sentinel, got %+v\n", p)
+	}
+	// Stopping doesn't wait for consumers, so a full output gets none
+	if p, ok := <-full; ok {
+		t.Errorf("Expected no sentinel on a full output, got %+v\n", p)
+	}
+}
+
+func TestMuxSentinelStalledConsumer(t *testing.T) {
+	src := make(chan *RequestResponsePair, 2)
+	m := NewPairMux(src, WithTimeout(10*time.Millisecond), WithSendSentinelOnClose())
+	m.MustAddOutput("stalled", 1)
+	src <- &RequestResponsePair{}
+	src <- &RequestResponsePair{}
+	close(src)
+	m.Start()
+	// Nobody reads the output, so a non-blocking mux must not wait to send
+	// the sentinel
+	select {
+	case <-m.Finished:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown hung sending a sentinel to a stalled output.\n")
+	}
+}
+
+func TestMuxNoSentinelByDefault(t *testing.T) {
+	src := make(chan *RequestResponsePair)
+	m := NewBlockingPairMux(src)
+	out := m.MustAddOutput("out", 1)
+	close(src)
+	m.Start()
+	if p, ok := <-out; ok {
+		t.Errorf("Expected no sentinel, got %+v\n", p)
+	}
+}
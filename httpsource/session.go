@@ -0,0 +1,112 @@
+package httpsource
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultSessionIdleTimeout is how long GroupBySession waits for another pair
+// before a session is considered finished.
+const DefaultSessionIdleTimeout = 5 * time.Minute
+
+// Session is the ordered list of pairs sharing a session key.
+type Session struct {
+	Key   string
+	Pairs []*RequestResponsePair
+	// lastSeen is when the latest pair arrived
+	lastSeen time.Time
+}
+
+// GroupBySession groups pairs into sessions using GroupBySessionTimeout with
+// DefaultSessionIdleTimeout.
+func GroupBySession(in <-chan *RequestResponsePair, keyFn func(*RequestResponsePair) string) <-chan Session {
+	return GroupBySessionTimeout(in, keyFn, DefaultSessionIdleTimeout)
+}
+
+// GroupBySessionTimeout groups pairs from in by keyFn(pair), emitting each
+// session once no pair has arrived for it within idle.  Pairs with an empty
+// key belong to no session and are discarded.  An idle <= 0 never waits,
+// so each pair is emitted as a session of its own.  When in is closed, the
+// remaining sessions are emitted, oldest first, and the returned channel is
+// closed.
+func GroupBySessionTimeout(in <-chan *RequestResponsePair, keyFn func(*RequestResponsePair) string, idle time.Duration) <-chan Session {
+	out := make(chan Session, 10)
+	if idle <= 0 {
+		go func() {
+			defer close(out)
+			for pair := range in {
+				if key := keyFn(pair); key != "" {
+					out <- Session{Key: key, Pairs: []*RequestResponsePair{pair}}
+				}
+			}
+		}()
+		return out
+	}
+	go func() {
+		defer close(out)
+		sessions := make(map[string]*Session)
+		ticker := time.NewTicker(sweepInterval(idle))
+		defer ticker.Stop()
+		for {
+			select {
+			case pair, ok := <-in:
+				if !ok {
+					emitSessions(sessions, time.Now().Add(time.Hour), out)
+					return
+				}
+				key := keyFn(pair)
+				if key == "" {
+					continue
+				}
+				s, ok := sessions[key]
+				if !ok {
+					s = &Session{Key: key}
+					sessions[key] = s
+				}
+				s.Pairs = append(s.Pairs, pair)
+				s.lastSeen = time.Now()
+			case now := <-ticker.C:
+				emitSessions(sessions, now.Add(-idle), out)
+			}
+		}
+	}()
+	return out
+}
+
+// emitSessions sends, oldest first, every session last seen before cutoff
+func emitSessions(sessions map[string]*Session, cutoff time.Time, out chan<- Session) {
+	var idle []*Session
+	for key, s := range sessions {
+		if s.lastSeen.Before(cutoff) {
+			idle = append(idle, s)
+			delete(sessions, key)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].lastSeen.Before(idle[j].lastSeen)
+	})
+	for _, s := range idle {
+		out <- *s
+	}
+}
+
+// CookieSessionKey returns a key function for GroupBySession using the value
+// of the named cookie.  If the request doesn't carry the cookie, one set by
+// the response is used, so the request that starts a session is part of it.
+func CookieSessionKey(name string) func(*RequestResponsePair) string {
+	return func(p *RequestResponsePair) string {
+		if p.Request != nil {
+			if c, err := p.Request.Cookie(name); err == nil {
+				return c.Value
+			}
+		}
+		if p.Response != nil {
+			for _, c := range p.Response.Cookies() {
+				if c.Name == name {
+					return c.Value
+				}
+			}
+		}
+		return ""
+	}
+}
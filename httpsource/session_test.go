@@ -0,0 +1,84 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupBySession(t *testing.T) {
+	login := testPair(t, "POST /login HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nSet-Cookie: sid=abc; Path=/\r\nContent-Length: 0\r\n\r\n")
+	page := testPair(t, "GET /home HTTP/1.1\r\nHost: x\r\nCookie: sid=abc\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	other := testPair(t, "GET /home HTTP/1.1\r\nHost: x\r\nCookie: sid=def\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	anon := testPair(t, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+
+	in := make(chan *RequestResponsePair, 4)
+	sessions := GroupBySessionTimeout(in, CookieSessionKey("sid"), time.Hour)
+	in <- login
+	in <- other
+	in <- page
+	in <- anon
+	close(in)
+	first, second := <-sessions, <-sessions
+	if first.Key != "def" || len(first.Pairs) != 1 {
+		t.Errorf("Unexpected first session: %s with %d pairs\n", first.Key, len(first.Pairs))
+	}
+	if second.Key != "abc" || len(second.Pairs) != 2 || second.Pairs[0] != login || second.Pairs[1] != page {
+		t.Errorf("Unexpected second session: %s with %d pairs\n", second.Key, len(second.Pairs))
+	}
+	if _, ok := <-sessions; ok {
+		t.Error("Expected sessions to be closed.\n")
+	}
+}
+
+func TestGroupBySessionIdle(t *testing.T) {
+	in := make(chan *RequestResponsePair)
+	sessions := GroupBySessionTimeout(in, func(_ *RequestResponsePair) string { return "k" },
+		20*time.Millisecond)
+	in <- &RequestResponsePair{}
+	select {
+	case s := <-sessions:
+		if s.Key != "k" || len(s.Pairs) != 1 {
+			t.Errorf("Unexpected session: %+v\n", s)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for idle session.\n")
+	}
+	close(in)
+}
+
+func TestGroupBySessionShortIdle(t *testing.T) {
+	for _, idle := range []time.Duration{0, time.Nanosecond} {
+		in := make(chan *RequestResponsePair, 3)
+		sessions := GroupBySessionTimeout(in, CookieSessionKey("sid"), idle)
+		for i := 0; i < 2; i++ {
+			in <- testPair(t, "GET / HTTP/1.1\r\nHost: x\r\nCookie: sid=abc\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		}
+		in <- testPair(t, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		close(in)
+		pairs := 0
+		for s := range sessions {
+			if s.Key != "abc" {
+				t.Errorf("Idle %v: unexpected session %q\n", idle, s.Key)
+			}
+			pairs += len(s.Pairs)
+		}
+		if pairs != 2 {
+			t.Errorf("Idle %v: expected 2 pairs in sessions, got %d\n", idle, pairs)
+		}
+	}
+	in := make(chan *RequestResponsePair, 2)
+	sessions := GroupBySessionTimeout(in, CookieSessionKey("sid"), 0)
+	in <- testPair(t, "GET / HTTP/1.1\r\nHost: x\r\nCookie: sid=abc\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	in <- testPair(t, "GET / HTTP/1.1\r\nHost: x\r\nCookie: sid=abc\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	close(in)
+	n := 0
+	for _ = range sessions {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Expected a session per pair with no idle timeout, got %d\n", n)
+	}
+}
@@ -0,0 +1,62 @@
+package httpsource
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Replaced in tests
+var (
+	notifySignals = signal.Notify
+	stopSignals   = signal.Stop
+)
+
+// RunUntilSignal starts the mux and waits until either the source is closed
+// or one of sigs is received, defaulting to SIGINT and SIGTERM.  On a signal
+// the mux is resumed if paused, the pairs then in the source are processed,
+// and the mux is stopped, draining outputs for up to DrainTimeout.  Flushing
+// the source is also bounded by DrainTimeout, if set, and a second signal
+// stops the mux at once.  RunUntilSignal returns once the mux has shut down.
+// It returns an error if the mux had already been started.
+func (m *PairMux) RunUntilSignal(sigs ...os.Signal) error {
+	if m.Started() {
+		return errors.New("PairMux already started")
+	}
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	notifySignals(c, sigs...)
+	defer stopSignals(c)
+	m.Start()
+	select {
+	case <-m.exited:
+	case sig := <-c:
+		m.log().Infof("PairMux received %s, stopping.\n", sig)
+		m.Resume()
+		flushed := make(chan struct{})
+		go func() {
+			m.Flush()
+			close(flushed)
+		}()
+		var timeout <-chan time.Time
+		if m.DrainTimeout > 0 {
+			timer := time.NewTimer(m.DrainTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-flushed:
+		case sig := <-c:
+			m.log().Warnf("PairMux received %s while flushing, stopping now.\n", sig)
+		case <-timeout:
+			m.log().Warnf("PairMux timed out flushing the source, stopping.\n")
+		}
+		m.Stop()
+		<-m.exited
+	}
+	return nil
+}
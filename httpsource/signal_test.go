@@ -0,0 +1,101 @@
+package httpsource
+
+import (
+	"os"
+	"os/signal"
+	"testing"
+	"time"
+)
+
+// fakeSignals replaces signal delivery, returning a channel that receives
+// the channel RunUntilSignal registers
+func fakeSignals(t *testing.T) <-chan chan<- os.Signal {
+	registered := make(chan chan<- os.Signal, 1)
+	notifySignals = func(c chan<- os.Signal, sigs ...os.Signal) {
+		if len(sigs) != 2 {
+			t.Errorf("Expected the default signals, got %v\n", sigs)
+		}
+		registered <- c
+	}
+	stopSignals = func(chan<- os.Signal) {}
+	return registered
+}
+
+func restoreSignals() {
+	notifySignals = signal.Notify
+	stopSignals = signal.Stop
+}
+
+func TestRunUntilSignal(t *testing.T) {
+	defer restoreSignals()
+	registered := fakeSignals(t)
+	src := make(chan *RequestResponsePair, 5)
+	m := NewBlockingPairMux(src)
+	out := m.MustAddOutput("out", 5)
+	done := make(chan error)
+	go func() {
+		done <- m.RunUntilSignal()
+	}()
+	c := <-registered
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+	}
+	c <- os.Interrupt
+	select {
+	case err := <-done:
+		fatalIfErr(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal did not return.\n")
+	}
+	count := 0
+	for _ = range out {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Expected queued pairs to be delivered, got %d\n", count)
+	}
+	if m.RunUntilSignal() == nil {
+		t.Error("Expected error running a finished mux.\n")
+	}
+}
+
+func TestRunUntilSignalSourceClosed(t *testing.T) {
+	defer restoreSignals()
+	fakeSignals(t)
+	src := make(chan *RequestResponsePair)
+	close(src)
+	m := NewBlockingPairMux(src)
+	fatalIfErr(t, m.RunUntilSignal())
+}
+
+func TestRunUntilSignalStalledFlush(t *testing.T) {
+	defer restoreSignals()
+	for _, second := range []bool{true, false} {
+		registered := fakeSignals(t)
+		src := make(chan *RequestResponsePair, 3)
+		m := NewBlockingPairMux(src)
+		if !second {
+			m.DrainTimeout = 50 * time.Millisecond
+		}
+		// Nobody reads the output, so flushing the source can't finish
+		m.MustAddOutput("stalled", 0)
+		done := make(chan error)
+		go func() {
+			done <- m.RunUntilSignal()
+		}()
+		c := <-registered
+		for i := 0; i < 3; i++ {
+			src <- &RequestResponsePair{}
+		}
+		c <- os.Interrupt
+		if second {
+			c <- os.Interrupt
+		}
+		select {
+		case err := <-done:
+			fatalIfErr(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("RunUntilSignal did not return (second signal: %v).\n", second)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package httpsource
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// slogLogger logs through a slog.Logger at the matching levels
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// SlogLogger adapts l for use as a mux's LevelLogger.  Messages are logged
+// at the matching slog level.  The adapter is a DropLogger, logging drops as
+// "PairMux dropped pair" warnings with output, depth, capacity and seq
+// attributes rather than as formatted text.
+func SlogLogger(l *slog.Logger) LevelLogger {
+	return slogLogger{l}
+}
+
+func (s slogLogger) logf(level slog.Level, format string, v ...interface{}) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+	s.l.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+}
+
+func (s slogLogger) Debugf(format string, v ...interface{}) { s.logf(slog.LevelDebug, format, v...) }
+func (s slogLogger) Infof(format string, v ...interface{})  { s.logf(slog.LevelInfo, format, v...) }
+func (s slogLogger) Warnf(format string, v ...interface{})  { s.logf(slog.LevelWarn, format, v...) }
+func (s slogLogger) Errorf(format string, v ...interface{}) { s.logf(slog.LevelError, format, v...) }
+
+// LogDrop logs a drop as a warning with its details as attributes.
+func (s slogLogger) LogDrop(output string, depth, capacity int, seq uint64) {
+	s.l.Warn("PairMux dropped pair", "output", output, "depth", depth, "capacity", capacity, "seq", seq)
+}
@@ -0,0 +1,39 @@
+package httpsource
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	src := make(chan *RequestResponsePair, 1)
+	m := NewPairMux(src, WithNonBlocking(), WithLevelLogger(SlogLogger(l)))
+	m.AddOutput("full", 0)
+	src <- &RequestResponsePair{}
+	m.RunStep()
+	m.log().Debugf("Not logged at info.\n")
+	m.log().Infof("PairMux received %s, stopping.\n", "interrupt")
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]interface{}
+		fatalIfErr(t, dec.Decode(&rec))
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %v\n", records)
+	}
+	drop := records[0]
+	if drop["level"] != "WARN" || drop["msg"] != "PairMux dropped pair" || drop["output"] != "full" ||
+		drop["depth"] != float64(0) || drop["capacity"] != float64(0) || drop["seq"] != float64(1) {
+		t.Errorf("Unexpected drop record: %v\n", drop)
+	}
+	if info := records[1]; info["level"] != "INFO" || info["msg"] != "PairMux received interrupt, stopping." {
+		t.Errorf("Unexpected info record: %v\n", info)
+	}
+}
@@ -0,0 +1,60 @@
+package httpsource
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SnapshotWindow is the window Snapshot reports the throughput over.
+const SnapshotWindow = time.Minute
+
+// MuxSnapshot is the state of a mux at one moment, as returned by Snapshot.
+// It encodes as JSON for health and status endpoints.
+type MuxSnapshot struct {
+	// Processed is the number of pairs that have entered the mux
+	Processed uint64
+	// Throughput is the pairs per second processed over SnapshotWindow
+	Throughput float64
+	Started    bool
+	// OutputCount is the number of outputs, which are keyed by name in
+	// Outputs
+	OutputCount int
+	Outputs     map[string]OutputSnapshot
+}
+
+// OutputSnapshot is the state of a single output in a MuxSnapshot.
+type OutputSnapshot struct {
+	OutputStats
+	// Len and Cap are the depth and capacity of the output channel
+	Len int
+	Cap int
+	// LastWrite is the time of the last successful write, or zero
+	LastWrite time.Time
+}
+
+// Snapshot returns the stats, depths and activity of every output along with
+// the totals for the mux in one call, saving a series of calls to Stats,
+// OutputDepths and the rest.  The set of outputs is read under the mux's
+// lock, so it agrees with OutputCount, but the counters and depths are read
+// one by one while pairs keep moving, so they may be a pair or so apart:
+// waiting for the step in progress would stall callers behind a blocked
+// write.
+func (m *PairMux) Snapshot() MuxSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	snap := MuxSnapshot{
+		Processed:   atomic.LoadUint64(&m.seq),
+		Throughput:  m.Throughput(SnapshotWindow),
+		Started:     m.started,
+		OutputCount: len(m.outputs),
+		Outputs:     make(map[string]OutputSnapshot, len(m.outputs)),
+	}
+	for _, o := range m.outputs {
+		out := OutputSnapshot{OutputStats: o.stats.snapshot(), Len: len(o.dst), Cap: cap(o.dst)}
+		if ns := atomic.LoadInt64(o.lastWrite); ns != 0 {
+			out.LastWrite = time.Unix(0, ns)
+		}
+		snap.Outputs[o.name] = out
+	}
+	return snap
+}
@@ -0,0 +1,38 @@
+package httpsource
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMuxSnapshot(t *testing.T) {
+	src := make(chan *RequestResponsePair, 3)
+	clock := NewFakeClock(time.Unix(1000, 0))
+	m := NewPairMux(src, WithNonBlocking(), WithClock(clock), WithLogger(&recordingLogger{}))
+	m.AddOutput("roomy", 5)
+	m.AddOutput("full", 1)
+	for i := 0; i < 3; i++ {
+		src <- &RequestResponsePair{}
+		m.RunStep()
+	}
+	snap := m.Snapshot()
+	if snap.Processed != 3 || snap.Started || snap.OutputCount != 2 || snap.Throughput <= 0 {
+		t.Errorf("Unexpected totals: %+v\n", snap)
+	}
+	roomy, full := snap.Outputs["roomy"], snap.Outputs["full"]
+	if roomy.Written != 3 || roomy.Len != 3 || roomy.Cap != 5 || !roomy.LastWrite.Equal(time.Unix(1000, 0)) {
+		t.Errorf("Unexpected roomy snapshot: %+v\n", roomy)
+	}
+	if full.Written != 1 || full.Dropped != 2 || full.Len != 1 || full.Cap != 1 {
+		t.Errorf("Unexpected full snapshot: %+v\n", full)
+	}
+
+	data, err := json.Marshal(snap)
+	fatalIfErr(t, err)
+	var decoded MuxSnapshot
+	fatalIfErr(t, json.Unmarshal(data, &decoded))
+	if decoded.Processed != 3 || decoded.Outputs["full"].Dropped != 2 {
+		t.Errorf("Snapshot didn't survive JSON: %s\n", data)
+	}
+}
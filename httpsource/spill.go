@@ -0,0 +1,225 @@
+package httpsource
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spillSegmentSize is the size at which SpillBuffer starts a new segment
+// file, so replayed segments can be removed while spilling continues.
+const spillSegmentSize = 4 << 20
+
+// SpillStats counts what a SpillBuffer has done with its pairs.
+type SpillStats struct {
+	// Spilled pairs were written to disk
+	Spilled uint64
+	// Replayed pairs were read back from disk and delivered
+	Replayed uint64
+	// Dropped pairs were lost, because the disk limit was reached or a
+	// segment couldn't be written or read
+	Dropped uint64
+	// DiskBytes is the current size of the segment files
+	DiskBytes int64
+}
+
+type spillSegment struct {
+	path    string
+	w       *os.File
+	r       *os.File
+	br      *bufio.Reader
+	size    int64
+	full    bool
+	written int
+	read    int
+}
+
+// SpillBuffer sits between a channel, such as a PairMux output, and a slow
+// consumer.  Pairs are passed through a buffered channel while there is
+// room, and otherwise written to segment files on disk, then replayed in
+// order once the consumer catches up.  Since it always reads its input
+// promptly, the output feeding it never blocks or drops.
+type SpillBuffer struct {
+	out      chan *RequestResponsePair
+	dir      string
+	maxDisk  int64
+	lock     sync.Mutex
+	cond     *sync.Cond
+	segments []*spillSegment
+	nextSeg  int
+	// pending is the number of pairs on disk not yet delivered
+	pending int
+	done    bool
+	stats   SpillStats
+}
+
+// NewSpillBuffer starts a SpillBuffer reading from in, holding up to buf
+// pairs in memory and maxDisk bytes of segment files in a new temporary
+// directory under dir.  If dir is empty, the system temporary directory is
+// used.  Once in is closed and every pair has been delivered, the output is
+// closed and the segment directory is removed.
+func NewSpillBuffer(in <-chan *RequestResponsePair, buf int, dir string, maxDisk int64) (*SpillBuffer, error) {
+	spillDir, err := ioutil.TempDir(dir, "httpwatch-spill-")
+	if err != nil {
+		return nil, err
+	}
+	b := &SpillBuffer{
+		out:     make(chan *RequestResponsePair, buf),
+		dir:     spillDir,
+		maxDisk: maxDisk,
+	}
+	b.cond = sync.NewCond(&b.lock)
+	go b.fill(in)
+	go b.replay()
+	return b, nil
+}
+
+// Out returns the channel to consume pairs from.
+func (b *SpillBuffer) Out() <-chan *RequestResponsePair {
+	return b.out
+}
+
+// Stats returns a snapshot of the buffer's counters.
+func (b *SpillBuffer) Stats() SpillStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.stats
+}
+
+func (b *SpillBuffer) fill(in <-chan *RequestResponsePair) {
+	for pair := range in {
+		b.lock.Lock()
+		// Once anything is on disk, new pairs go after it to keep order
+		if b.pending == 0 {
+			select {
+			case b.out <- pair:
+				b.lock.Unlock()
+				continue
+			default:
+			}
+		}
+		b.spill(pair)
+		b.lock.Unlock()
+	}
+	b.lock.Lock()
+	b.done = true
+	b.cond.Signal()
+	b.lock.Unlock()
+}
+
+// spill writes a pair to the newest segment.  Called with lock held.
+func (b *SpillBuffer) spill(pair *RequestResponsePair) {
+	var buf bytes.Buffer
+	if err := (JSONCodec{}).Encode(&buf, pair); err != nil {
+		logger.Printf("Error encoding pair to spill: %s\n", err)
+		b.stats.Dropped++
+		return
+	}
+	if b.stats.DiskBytes+int64(buf.Len()) > b.maxDisk {
+		b.stats.Dropped++
+		return
+	}
+	var seg *spillSegment
+	if n := len(b.segments); n > 0 && !b.segments[n-1].full && b.segments[n-1].size < spillSegmentSize {
+		seg = b.segments[n-1]
+	} else {
+		var err error
+		if seg, err = b.newSegment(); err != nil {
+			logger.Printf("Error creating spill segment: %s\n", err)
+			b.stats.Dropped++
+			return
+		}
+	}
+	n, err := seg.w.Write(buf.Bytes())
+	seg.size += int64(n)
+	b.stats.DiskBytes += int64(n)
+	if err != nil {
+		logger.Printf("Error writing spill segment %s: %s\n", seg.path, err)
+		// Write no more after a partial record
+		seg.full = true
+		b.stats.Dropped++
+		return
+	}
+	seg.written++
+	b.pending++
+	b.stats.Spilled++
+	b.cond.Signal()
+}
+
+func (b *SpillBuffer) newSegment() (*spillSegment, error) {
+	path := filepath.Join(b.dir, fmt.Sprintf("segment-%d.json", b.nextSeg))
+	w, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	b.nextSeg++
+	seg := &spillSegment{path: path, w: w, r: r, br: bufio.NewReader(r)}
+	b.segments = append(b.segments, seg)
+	return seg, nil
+}
+
+// replay delivers pairs from disk, oldest first, until the input is closed
+// and everything has been delivered.
+func (b *SpillBuffer) replay() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for {
+		for b.pending == 0 && !b.done {
+			b.cond.Wait()
+		}
+		if b.pending == 0 {
+			close(b.out)
+			b.removeSegments()
+			return
+		}
+		// Skip segments that failed before anything was written
+		for b.segments[0].read == b.segments[0].written {
+			b.removeSegment(b.segments[0])
+		}
+		seg := b.segments[0]
+		pair, err := (JSONCodec{}).Decode(seg.br)
+		if err != nil {
+			logger.Printf("Error reading spill segment %s: %s\n", seg.path, err)
+			b.stats.Dropped++
+		} else {
+			// Don't block fill while waiting on the consumer; pending stays
+			// non-zero so new pairs still queue up behind this one.
+			b.lock.Unlock()
+			b.out <- pair
+			b.lock.Lock()
+			b.stats.Replayed++
+		}
+		b.pending--
+		seg.read++
+		if seg.read == seg.written && (len(b.segments) > 1 || b.pending == 0) {
+			b.removeSegment(seg)
+		}
+	}
+}
+
+// removeSegment closes and deletes the oldest segment.  Called with lock
+// held.
+func (b *SpillBuffer) removeSegment(seg *spillSegment) {
+	seg.w.Close()
+	seg.r.Close()
+	os.Remove(seg.path)
+	b.stats.DiskBytes -= seg.size
+	b.segments = b.segments[1:]
+}
+
+func (b *SpillBuffer) removeSegments() {
+	for len(b.segments) > 0 {
+		b.removeSegment(b.segments[0])
+	}
+	os.RemoveAll(b.dir)
+}
@@ -0,0 +1,71 @@
+package httpsource
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	fatalIfErr(t, err)
+	defer os.RemoveAll(dir)
+	in := make(chan *RequestResponsePair)
+	b, err := NewSpillBuffer(in, 2, dir, 1<<20)
+	fatalIfErr(t, err)
+	// Nothing is consuming, so all but the first two go to disk
+	for i := 0; i < 10; i++ {
+		in <- &RequestResponsePair{Seq: uint64(i)}
+	}
+	close(in)
+	// The last pair may still be on its way to disk
+	for deadline := time.Now().Add(5 * time.Second); b.Stats().Spilled < 8 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case pair := <-b.Out():
+			if pair.Seq != uint64(i) {
+				t.Errorf("Expected pair %d, got %d\n", i, pair.Seq)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for pair %d\n", i)
+		}
+	}
+	if _, ok := <-b.Out(); ok {
+		t.Error("Expected output to be closed.\n")
+	}
+	stats := b.Stats()
+	if stats.Spilled != 8 || stats.Replayed != 8 || stats.Dropped != 0 || stats.DiskBytes != 0 {
+		t.Errorf("Unexpected stats: %+v\n", stats)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	fatalIfErr(t, err)
+	if len(entries) != 0 {
+		t.Errorf("Expected spill directory to be removed, found %d entries\n", len(entries))
+	}
+}
+
+func TestSpillBufferDiskLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	fatalIfErr(t, err)
+	defer os.RemoveAll(dir)
+	in := make(chan *RequestResponsePair)
+	b, err := NewSpillBuffer(in, 1, dir, 1)
+	fatalIfErr(t, err)
+	for i := 0; i < 3; i++ {
+		in <- &RequestResponsePair{}
+	}
+	close(in)
+	count := 0
+	for _ = range b.Out() {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("Expected only the buffered pair, got %d\n", count)
+	}
+	if stats := b.Stats(); stats.Dropped != 2 || stats.Spilled != 0 {
+		t.Errorf("Unexpected stats: %+v\n", stats)
+	}
+}
@@ -0,0 +1,239 @@
+package httpsource
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Size of the chunks FanOutReader copies to its consumers
+const fanOutChunk = 32 * 1024
+
+// DefaultTeeBuffer is how many bytes a TeeBody consumer may fall behind the
+// fastest one before it is detached.
+const DefaultTeeBuffer = 4 * 1024 * 1024
+
+// ErrTeeDetached is returned to a TeeBody consumer that fell too far behind.
+var ErrTeeDetached = errors.New("Body consumer fell too far behind and was detached")
+
+// BodyReader returns a new reader over the response body.  Readers share the
+// pair's buffer rather than copying it, so any number may be used at once,
+// each from its own goroutine.  Closing the reader is a no-op.
+func (p *RequestResponsePair) BodyReader() io.ReadCloser {
+	return newBodyBuffer(p.ResponseBody)
+}
+
+// RequestBodyReader is like BodyReader, for the request body.
+func (p *RequestResponsePair) RequestBodyReader() io.ReadCloser {
+	return newBodyBuffer(p.RequestBody)
+}
+
+// FanOutReader streams r to n consumers without buffering it, reading it
+// once and copying each chunk to every consumer.  Each consumer should read
+// its reader to EOF or Close it.  A closed consumer is detached and the
+// others carry on, but a consumer that neither reads nor closes stalls all
+// of them, as the slowest consumer sets the pace.  A read error from r is
+// returned to every consumer.  Once every consumer is done, r is closed if
+// it is an io.Closer.
+func FanOutReader(r io.Reader, n int) []io.ReadCloser {
+	readers := make([]io.ReadCloser, n)
+	writers := make([]*io.PipeWriter, n)
+	for i := range readers {
+		readers[i], writers[i] = io.Pipe()
+	}
+	go func() {
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+		live := writers
+		buf := make([]byte, fanOutChunk)
+		for len(live) > 0 {
+			nr, err := r.Read(buf)
+			if nr > 0 {
+				live = writeChunk(live, buf[:nr])
+			}
+			if err == io.EOF {
+				for _, w := range live {
+					w.Close()
+				}
+				return
+			}
+			if err != nil {
+				for _, w := range live {
+					w.CloseWithError(err)
+				}
+				return
+			}
+		}
+	}()
+	return readers
+}
+
+// writeChunk writes chunk to every writer in parallel and returns those
+// whose readers are still open
+func writeChunk(writers []*io.PipeWriter, chunk []byte) []*io.PipeWriter {
+	failed := make([]bool, len(writers))
+	var wg sync.WaitGroup
+	wg.Add(len(writers))
+	for i, w := range writers {
+		go func(i int, w *io.PipeWriter) {
+			defer wg.Done()
+			if _, err := w.Write(chunk); err != nil {
+				failed[i] = true
+			}
+		}(i, w)
+	}
+	wg.Wait()
+	live := writers[:0]
+	for i, w := range writers {
+		if !failed[i] {
+			live = append(live, w)
+		}
+	}
+	return live
+}
+
+// TeeBody returns n copies of the pair sharing one stream of its response
+// body, as an alternative to Cloning large bodies once per consumer.  The
+// copies are Clones except for the response body: their ResponseBody is nil
+// and their Response.Body readers all stream the pair's Response.Body,
+// which is read only once.  Reads are driven by the fastest consumer, and
+// the bytes are held for the others until they catch up; a consumer more
+// than DefaultTeeBuffer bytes behind is detached, its reads then failing
+// with ErrTeeDetached.  Each consumer should read its body to EOF or Close
+// it, and once every one has, the original body is closed.  A pair without
+// a response body is just Cloned.
+func TeeBody(p *RequestResponsePair, n int) []*RequestResponsePair {
+	return TeeBodySize(p, n, DefaultTeeBuffer)
+}
+
+// TeeBodySize is like TeeBody, detaching consumers more than max bytes
+// behind.
+func TeeBodySize(p *RequestResponsePair, n int, max int) []*RequestResponsePair {
+	copies := make([]*RequestResponsePair, n)
+	if p.Response == nil || p.Response.Body == nil {
+		for i := range copies {
+			copies[i] = p.Clone()
+		}
+		return copies
+	}
+	shallow := *p
+	shallow.ResponseBody = nil
+	// Read no more than a consumer may buffer, so the one reading can't be
+	// detached
+	size := fanOutChunk
+	if max < size {
+		size = max
+	}
+	if size < 1 {
+		size = 1
+	}
+	tee := &bodyTee{src: p.Response.Body, max: max, chunk: make([]byte, size), open: n}
+	for i := range copies {
+		r := &teeReader{tee: tee}
+		tee.readers = append(tee.readers, r)
+		copies[i] = shallow.Clone()
+		copies[i].Response.Body = r
+	}
+	return copies
+}
+
+// bodyTee reads src on behalf of its teeReaders, holding each chunk until
+// every live reader has had it
+type bodyTee struct {
+	lock  sync.Mutex
+	src   io.Reader
+	max   int
+	chunk []byte
+	// readers holds the live readers, which have been given every chunk
+	readers []*teeReader
+	// open counts the readers not yet closed or at the end of the body
+	open int
+	err  error
+}
+
+// teeReader is one consumer of a bodyTee
+type teeReader struct {
+	tee  *bodyTee
+	buf  []byte
+	done bool
+	err  error
+}
+
+func (r *teeReader) Read(p []byte) (int, error) {
+	t := r.tee
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for len(r.buf) == 0 && r.err == nil && t.err == nil {
+		t.fill()
+	}
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		r.buf = r.buf[n:]
+		return n, nil
+	}
+	err := r.err
+	if err == nil {
+		err = t.err
+	}
+	r.finish()
+	return 0, err
+}
+
+// Close detaches the reader from the tee.
+func (r *teeReader) Close() error {
+	r.tee.lock.Lock()
+	defer r.tee.lock.Unlock()
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+	r.buf = nil
+	r.finish()
+	return nil
+}
+
+// finish marks the reader done, closing the source once every reader is
+func (r *teeReader) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	t := r.tee
+	t.detach(r)
+	t.open--
+	if t.open == 0 {
+		if c, ok := t.src.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// fill reads the next chunk and hands it to every live reader, detaching
+// those that have fallen too far behind
+func (t *bodyTee) fill() {
+	n, err := t.src.Read(t.chunk)
+	if n > 0 {
+		for _, r := range append([]*teeReader(nil), t.readers...) {
+			if len(r.buf)+n > t.max {
+				r.buf = nil
+				r.err = ErrTeeDetached
+				t.detach(r)
+				continue
+			}
+			r.buf = append(r.buf, t.chunk[:n]...)
+		}
+	}
+	if err != nil {
+		t.err = err
+	}
+}
+
+// detach stops handing chunks to r
+func (t *bodyTee) detach(r *teeReader) {
+	for i, live := range t.readers {
+		if live == r {
+			t.readers = append(t.readers[:i], t.readers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package httpsource
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBodyReader(t *testing.T) {
+	p := &RequestResponsePair{RequestBody: []byte("req"), ResponseBody: []byte("resp")}
+	a, b := p.BodyReader(), p.BodyReader()
+	first, _ := ioutil.ReadAll(a)
+	second, _ := ioutil.ReadAll(b)
+	req, _ := ioutil.ReadAll(p.RequestBodyReader())
+	if string(first) != "resp" || string(second) != "resp" || string(req) != "req" {
+		t.Errorf("Unexpected bodies: %q %q %q\n", first, second, req)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed chan bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed <- true
+	return nil
+}
+
+func TestFanOutReader(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	src := &closeRecorder{Reader: bytes.NewReader(body), closed: make(chan bool, 1)}
+	readers := FanOutReader(src, 3)
+	// One consumer gives up early without stalling the others
+	readers[2].Close()
+
+	results := make([][]byte, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = ioutil.ReadAll(readers[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range results {
+		if !bytes.Equal(r, body) {
+			t.Errorf("Consumer %d got %d bytes, expected %d\n", i, len(r), len(body))
+		}
+	}
+	select {
+	case <-src.closed:
+	case <-time.After(time.Second):
+		t.Error("Expected source to be closed.\n")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(_ []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestFanOutReaderError(t *testing.T) {
+	for _, r := range FanOutReader(failingReader{}, 2) {
+		if _, err := ioutil.ReadAll(r); err == nil || err.Error() != "read failed" {
+			t.Errorf("Expected the read error, got %v\n", err)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestTeeBody(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	counter := &countingReader{Reader: bytes.NewReader(body)}
+	src := &closeRecorder{Reader: counter, closed: make(chan bool, 1)}
+	p := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	p.Response.Body = src
+	copies := TeeBodySize(p, 3, 40000)
+	if len(copies) != 3 || copies[0] == p || copies[0].Response == p.Response || copies[0].ResponseBody != nil {
+		t.Fatalf("Expected 3 copies without response bodies\n")
+	}
+
+	// The first two read in step, leaving the third behind until it is
+	// detached
+	results := make([][]byte, 2)
+	buf := make([]byte, 1000)
+	for done := 0; done < 2; {
+		done = 0
+		for i := range results {
+			n, err := copies[i].Response.Body.Read(buf)
+			results[i] = append(results[i], buf[:n]...)
+			if err == io.EOF {
+				done++
+			} else if err != nil {
+				t.Fatalf("Consumer %d failed: %v\n", i, err)
+			}
+		}
+	}
+	for i, r := range results {
+		if !bytes.Equal(r, body) {
+			t.Errorf("Consumer %d got %d bytes, expected %d\n", i, len(r), len(body))
+		}
+	}
+	if _, err := ioutil.ReadAll(copies[2].Response.Body); err != ErrTeeDetached {
+		t.Errorf("Expected slow consumer to be detached, got %v\n", err)
+	}
+	if counter.n != len(body) {
+		t.Errorf("Expected body to be read once, read %d bytes\n", counter.n)
+	}
+	select {
+	case <-src.closed:
+	case <-time.After(time.Second):
+		t.Error("Expected source to be closed.\n")
+	}
+}
+
+func TestTeeBodyClose(t *testing.T) {
+	p := testPair(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+	copies := TeeBody(p, 2)
+	copies[1].Response.Body.Close()
+	got, err := ioutil.ReadAll(copies[0].Response.Body)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("Expected hello, got %q %v\n", got, err)
+	}
+	if _, err := copies[1].Response.Body.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected a closed consumer to fail\n")
+	}
+}
@@ -0,0 +1,29 @@
+package httpsource
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+)
+
+// Throttle forwards pairs from src no faster than perSecond, using a token
+// bucket with a burst of one.  The forwarding goroutine blocks while waiting,
+// so a fast source sees backpressure rather than drops.  A non-positive rate
+// forwards pairs unthrottled.  The returned channel is closed once src has
+// been closed and drained.
+func Throttle(src <-chan *RequestResponsePair, perSecond float64) <-chan *RequestResponsePair {
+	limit := rate.Limit(perSecond)
+	if perSecond <= 0 {
+		limit = rate.Inf
+	}
+	limiter := rate.NewLimiter(limit, 1)
+	out := make(chan *RequestResponsePair)
+	go func() {
+		defer close(out)
+		for pair := range src {
+			// Only fails if the context is done, which never happens
+			limiter.Wait(context.Background())
+			out <- pair
+		}
+	}()
+	return out
+}
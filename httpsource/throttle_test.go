@@ -0,0 +1,40 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	src := make(chan *RequestResponsePair, 5)
+	for i := 0; i < 5; i++ {
+		src <- &RequestResponsePair{}
+	}
+	close(src)
+	start := time.Now()
+	count := 0
+	for _ = range Throttle(src, 20) {
+		count++
+	}
+	if count != 5 {
+		t.Errorf("Expected 5 pairs, got %d\n", count)
+	}
+	// The first pair is immediate, the rest are 50ms apart
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected throttling, finished in %v\n", elapsed)
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	src := make(chan *RequestResponsePair, 100)
+	for i := 0; i < 100; i++ {
+		src <- &RequestResponsePair{}
+	}
+	close(src)
+	start := time.Now()
+	for _ = range Throttle(src, 0) {
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected no throttling, took %v\n", elapsed)
+	}
+}
@@ -0,0 +1,69 @@
+package httpsource
+
+import (
+	"sync"
+	"time"
+)
+
+// Throughput is counted in buckets of throughputBucket, keeping enough of
+// them to cover throughputSpan.
+const (
+	throughputBucket = 250 * time.Millisecond
+	throughputSpan   = 5 * time.Minute
+	throughputSlots  = int(throughputSpan / throughputBucket)
+)
+
+// throughputCounter is a ring of per-bucket pair counts.  counts[i] holds the
+// count for the bucket numbered bucket[i], so stale slots are recognized
+// without having to clear them.
+type throughputCounter struct {
+	lock   sync.Mutex
+	counts [throughputSlots]uint64
+	bucket [throughputSlots]int64
+}
+
+func newThroughputCounter() *throughputCounter {
+	return &throughputCounter{}
+}
+
+func (c *throughputCounter) add(now time.Time) {
+	b := now.UnixNano() / int64(throughputBucket)
+	i := int(b % int64(throughputSlots))
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.bucket[i] != b {
+		c.bucket[i] = b
+		c.counts[i] = 0
+	}
+	c.counts[i]++
+}
+
+// rate returns the pairs per second over the window ending at now
+func (c *throughputCounter) rate(now time.Time, window time.Duration) float64 {
+	if window > throughputSpan {
+		window = throughputSpan
+	}
+	n := int64(window / throughputBucket)
+	if n < 1 {
+		n = 1
+	}
+	last := now.UnixNano() / int64(throughputBucket)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var total uint64
+	for b := last - n + 1; b <= last; b++ {
+		i := int(b % int64(throughputSlots))
+		if c.bucket[i] == b {
+			total += c.counts[i]
+		}
+	}
+	return float64(total) / (time.Duration(n) * throughputBucket).Seconds()
+}
+
+// Throughput returns the number of pairs per second the mux has processed
+// over the trailing window.  Counts are kept in 250ms buckets for the last
+// five minutes, so window is rounded down to a multiple of 250ms and capped
+// at five minutes.
+func (m *PairMux) Throughput(window time.Duration) float64 {
+	return m.throughput.rate(m.Clock.Now(), window)
+}
@@ -0,0 +1,37 @@
+package httpsource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughput(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	m := NewBlockingPairMux(nil)
+	m.Clock = clock
+	for i := 0; i < 10; i++ {
+		m.step(&RequestResponsePair{})
+	}
+	clock.Advance(time.Second)
+	for i := 0; i < 20; i++ {
+		m.step(&RequestResponsePair{})
+	}
+	if r := m.Throughput(time.Second); r != 20 {
+		t.Errorf("Expected 20/s over the last second, got %v\n", r)
+	}
+	if r := m.Throughput(2 * time.Second); r != 15 {
+		t.Errorf("Expected 15/s over two seconds, got %v\n", r)
+	}
+	clock.Advance(10 * time.Second)
+	if r := m.Throughput(time.Second); r != 0 {
+		t.Errorf("Expected 0/s after idling, got %v\n", r)
+	}
+	if r := m.Throughput(time.Hour); r != 30/throughputSpan.Seconds() {
+		t.Errorf("Expected window to be capped, got %v\n", r)
+	}
+	// Slots are reused once the ring wraps around
+	clock.Advance(throughputSpan)
+	if r := m.Throughput(throughputSpan); r != 0 {
+		t.Errorf("Expected stale buckets to be ignored, got %v\n", r)
+	}
+}
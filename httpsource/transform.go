@@ -0,0 +1,85 @@
+package httpsource
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// DefaultRedactedHeaders are the headers Redact replaces when no headers are
+// named.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Replacement value for redacted headers
+const redactedValue = "***"
+
+// Redact returns a Clone of the pair with the values of the named request and
+// response headers replaced by "***".  Header names are matched case
+// insensitively.  With no names, DefaultRedactedHeaders are redacted.
+func (p *RequestResponsePair) Redact(headerNames ...string) *RequestResponsePair {
+	if len(headerNames) == 0 {
+		headerNames = DefaultRedactedHeaders
+	}
+	c := p.Clone()
+	for _, name := range headerNames {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if c.Request != nil {
+			redactHeader(c.Request.Header, name)
+		}
+		if c.Response != nil {
+			redactHeader(c.Response.Header, name)
+		}
+	}
+	return c
+}
+
+// TruncatedHeader is added by TruncateBodies to a truncated request or
+// response, holding the original body length.
+const TruncatedHeader = "X-Httpwatch-Truncated"
+
+// TruncateBodies returns a Clone of the pair with the request and response
+// bodies cut to at most maxBytes.  Each truncated request or response has a
+// TruncatedHeader giving the original length of its body.
+func (p *RequestResponsePair) TruncateBodies(maxBytes int) *RequestResponsePair {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	c := p.Clone()
+	if len(c.RequestBody) > maxBytes {
+		if c.Request != nil {
+			markTruncated(c.Request.Header, len(c.RequestBody))
+		}
+		c.RequestBody = c.RequestBody[:maxBytes]
+		if c.Request != nil && c.Request.Body != nil {
+			c.Request.Body = newBodyBuffer(c.RequestBody)
+		}
+	}
+	if len(c.ResponseBody) > maxBytes {
+		if c.Response != nil {
+			markTruncated(c.Response.Header, len(c.ResponseBody))
+		}
+		c.ResponseBody = c.ResponseBody[:maxBytes]
+		if c.Response != nil && c.Response.Body != nil {
+			c.Response.Body = newBodyBuffer(c.ResponseBody)
+		}
+	}
+	return c
+}
+
+func markTruncated(h http.Header, length int) {
+	if h != nil {
+		h.Set(TruncatedHeader, strconv.Itoa(length))
+	}
+}
+
+func redactHeader(h http.Header, name string) {
+	vals, ok := h[name]
+	if !ok {
+		return
+	}
+	redacted := make([]string, len(vals))
+	for i := range redacted {
+		redacted[i] = redactedValue
+	}
+	h[name] = redacted
+}
@@ -0,0 +1,53 @@
+package httpsource
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	pair := testPair(t,
+		"GET / HTTP/1.1\r\nHost: example.com\r\nAuthorization: Basic Zm9vOmJhcg==\r\n"+
+			"X-Api-Key: secret\r\nUser-Agent: test\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\nContent-Length: 0\r\n\r\n")
+
+	redacted := pair.Redact()
+	if v := redacted.Request.Header.Get("Authorization"); v != "***" {
+		t.Errorf("Authorization not redacted: %s\n", v)
+	}
+	if v := redacted.Response.Header["Set-Cookie"]; len(v) != 2 || v[0] != "***" || v[1] != "***" {
+		t.Errorf("Set-Cookie not redacted: %v\n", v)
+	}
+	if v := pair.Request.Header.Get("Authorization"); v == "***" {
+		t.Error("Redact modified the original pair.\n")
+	}
+
+	custom := pair.Redact("x-api-key")
+	if v := custom.Request.Header.Get("X-Api-Key"); v != "***" {
+		t.Errorf("X-Api-Key not redacted: %s\n", v)
+	}
+	if v := custom.Request.Header.Get("Authorization"); v == "***" {
+		t.Error("Expected only named headers to be redacted.\n")
+	}
+	if v := custom.Request.Header.Get("User-Agent"); v != "test" {
+		t.Errorf("User-Agent modified: %s\n", v)
+	}
+}
+
+func TestTruncateBodies(t *testing.T) {
+	pair := testPair(t,
+		"POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nabc",
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n0123456789")
+	truncated := pair.TruncateBodies(4)
+	if string(truncated.RequestBody) != "abc" || truncated.Request.Header.Get(TruncatedHeader) != "" {
+		t.Errorf("Short request body changed: %q %v\n", truncated.RequestBody, truncated.Request.Header)
+	}
+	if string(truncated.ResponseBody) != "0123" {
+		t.Errorf("Expected truncated response body, got %q\n", truncated.ResponseBody)
+	}
+	if v := truncated.Response.Header.Get(TruncatedHeader); v != "10" {
+		t.Errorf("Expected original length 10, got %q\n", v)
+	}
+	if len(pair.ResponseBody) != 10 || pair.Response.Header.Get(TruncatedHeader) != "" {
+		t.Error("TruncateBodies modified the original pair.\n")
+	}
+}
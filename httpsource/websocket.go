@@ -0,0 +1,144 @@
+package httpsource
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WebSocket opcodes, from RFC 6455
+const (
+	WSContinuation = 0x0
+	WSText         = 0x1
+	WSBinary       = 0x2
+	WSClose        = 0x8
+	WSPing         = 0x9
+	WSPong         = 0xA
+)
+
+// Frames with larger payloads end the WebSocket capture
+const maxWSPayload = 64 << 20
+
+// WSFrame is a single WebSocket frame sent after a connection was upgraded.
+// Frames are emitted as pairs whose Request is the upgrade request, with no
+// Response, in the order they were captured.
+type WSFrame struct {
+	// FromClient is set for frames sent by the client
+	FromClient bool
+	Opcode     byte
+	// Fin is set on the last frame of a message
+	Fin bool
+	// Payload has been unmasked
+	Payload []byte
+}
+
+// IsWebSocket accepts WebSocket frames.
+func IsWebSocket() FilterFunc {
+	return func(p *RequestResponsePair) bool {
+		return p.WSFrame != nil
+	}
+}
+
+// isWebSocketUpgrade reports whether resp switched the connection to the
+// WebSocket protocol
+func isWebSocketUpgrade(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// timedFrame is a frame and when its first byte was captured
+type timedFrame struct {
+	frame *WSFrame
+	seen  time.Time
+}
+
+// readWebSocket reads the frames sent in both directions after upgrade,
+// returning them as pairs ordered by capture time
+func (conn *HTTPConnection) readWebSocket(upgrade *RequestResponsePair, request, response *bufio.Reader) []*RequestResponsePair {
+	frames := append(readWSFrames(request, conn.reqClock, true),
+		readWSFrames(response, conn.respClock, false)...)
+	// Client frames come first, so ties keep them ahead of the replies
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].seen.Before(frames[j].seen)
+	})
+	pairs := make([]*RequestResponsePair, len(frames))
+	for i, f := range frames {
+		pairs[i] = &RequestResponsePair{
+			Request:   upgrade.Request,
+			Timestamp: f.seen,
+			ConnInfo:  upgrade.ConnInfo,
+			ID:        upgrade.ID,
+			WSFrame:   f.frame,
+		}
+	}
+	return pairs
+}
+
+func readWSFrames(r *bufio.Reader, clock *streamClock, fromClient bool) []timedFrame {
+	var frames []timedFrame
+	for {
+		seen := clock.next()
+		frame, err := readWSFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Printf("Error reading WebSocket frame: %v\n", err)
+			}
+			return frames
+		}
+		frame.FromClient = fromClient
+		frames = append(frames, timedFrame{frame, seen})
+	}
+}
+
+// readWSFrame reads a single frame, returning io.EOF if there is no more
+// data
+func readWSFrame(r *bufio.Reader) (*WSFrame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("Truncated WebSocket frame header")
+		}
+		return nil, err
+	}
+	frame := &WSFrame{Fin: hdr[0]&0x80 != 0, Opcode: hdr[0] & 0x0f}
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, fmt.Errorf("Truncated WebSocket frame length: %v", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, fmt.Errorf("Truncated WebSocket frame length: %v", err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWSPayload {
+		return nil, fmt.Errorf("WebSocket frame of %d bytes is too large", length)
+	}
+	var mask [4]byte
+	masked := hdr[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return nil, fmt.Errorf("Truncated WebSocket frame mask: %v", err)
+		}
+	}
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return nil, fmt.Errorf("Truncated WebSocket frame payload: %v", err)
+	}
+	if masked {
+		for i := range frame.Payload {
+			frame.Payload[i] ^= mask[i%4]
+		}
+	}
+	return frame, nil
+}
@@ -0,0 +1,88 @@
+package httpsource
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// wsFrame builds a single frame, masking it if mask is set
+func wsFrame(opcode byte, payload string, mask bool) string {
+	b := []byte{0x80 | opcode}
+	if mask {
+		b = append(b, 0x80|byte(len(payload)))
+		key := []byte{1, 2, 3, 4}
+		b = append(b, key...)
+		for i := 0; i < len(payload); i++ {
+			b = append(b, payload[i]^key[i%4])
+		}
+	} else {
+		b = append(b, byte(len(payload)))
+		b = append(b, payload...)
+	}
+	return string(b)
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	rawReq := "GET /chat HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n\r\n" + wsFrame(WSText, "hello", true) + wsFrame(WSClose, "", true)
+	rawResp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n\r\n" + wsFrame(WSText, "hi there", false)
+	conn := HTTPConnection{}
+	conn.readConnection(bufio.NewReader(strings.NewReader(rawReq)),
+		bufio.NewReader(strings.NewReader(rawResp)))
+	if len(conn.Pairs) != 4 {
+		t.Fatalf("Expected upgrade and 3 frames, got %d pairs: %v\n", len(conn.Pairs), conn.err)
+	}
+	if IsWebSocket()(conn.Pairs[0]) || conn.Pairs[0].Response.StatusCode != 101 {
+		t.Errorf("Expected the upgrade exchange first: %+v\n", conn.Pairs[0])
+	}
+	frames := conn.Pairs[1:]
+	expected := []struct {
+		fromClient bool
+		opcode     byte
+		payload    string
+	}{{true, WSText, "hello"}, {true, WSClose, ""}, {false, WSText, "hi there"}}
+	for i, e := range expected {
+		p := frames[i]
+		if !IsWebSocket()(p) || p.Request != conn.Pairs[0].Request {
+			t.Errorf("Frame %d not tied to the upgrade request: %+v\n", i, p)
+			continue
+		}
+		f := p.WSFrame
+		if f.FromClient != e.fromClient || f.Opcode != e.opcode || string(f.Payload) != e.payload || !f.Fin {
+			t.Errorf("Frame %d unexpected: %+v\n", i, f)
+		}
+	}
+	if frames[0].Hash() == frames[2].Hash() {
+		t.Error("Expected frames with different payloads to hash differently.\n")
+	}
+}
+
+func TestWebSocketFrameLengths(t *testing.T) {
+	payload := strings.Repeat("x", 300)
+	raw := string([]byte{0x82, 126, 0x01, 0x2c}) + payload
+	frame, err := readWSFrame(bufio.NewReader(strings.NewReader(raw)))
+	fatalIfErr(t, err)
+	if frame.Opcode != WSBinary || len(frame.Payload) != 300 {
+		t.Errorf("Unexpected extended frame: opcode %d, %d bytes\n", frame.Opcode, len(frame.Payload))
+	}
+	if _, err := readWSFrame(bufio.NewReader(strings.NewReader("\x81\x05ab"))); err == nil {
+		t.Error("Expected error for truncated frame.\n")
+	}
+}
+
+func TestWebSocketFrameJSON(t *testing.T) {
+	pair := &RequestResponsePair{WSFrame: &WSFrame{FromClient: true, Opcode: WSBinary, Fin: true, Payload: []byte{0xff, 0}}}
+	buf, err := json.Marshal(pair)
+	fatalIfErr(t, err)
+	decoded := &RequestResponsePair{}
+	fatalIfErr(t, json.Unmarshal(buf, decoded))
+	if !decoded.Equal(pair) {
+		t.Errorf("Frame not round tripped: %s\n", buf)
+	}
+	if c := pair.Clone(); c.WSFrame == pair.WSFrame || !c.Equal(pair) {
+		t.Error("Expected Clone to copy the frame.\n")
+	}
+}
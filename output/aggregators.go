@@ -0,0 +1,178 @@
+package output
+
+import (
+	"github.com/Matir/httpwatch/httpsource"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of latencies NewLatencyStats keeps for estimating quantiles
+const latencyReservoirSize = 10000
+
+// NewStatusCodeAggregator counts responses by status code.  Pairs are read
+// from dst until it is closed; snapshot returns a copy of the counts so far.
+func NewStatusCodeAggregator() (dst chan<- *httpsource.RequestResponsePair, snapshot func() map[int]int64) {
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	var lock sync.Mutex
+	counts := make(map[int]int64)
+	go func() {
+		for pair := range input {
+			if pair.Response == nil {
+				continue
+			}
+			lock.Lock()
+			counts[pair.Response.StatusCode]++
+			lock.Unlock()
+		}
+	}()
+	return input, func() map[int]int64 {
+		lock.Lock()
+		defer lock.Unlock()
+		c := make(map[int]int64, len(counts))
+		for code, n := range counts {
+			c[code] = n
+		}
+		return c
+	}
+}
+
+// NewLatencyStats tracks the distribution of pair latencies.  Pairs are read
+// from dst until it is closed, ignoring those of unknown latency.  quantiles
+// estimates the latency at each of qs (0.5 for the median, 0.99 for p99)
+// from a uniform sample of the latencies seen; it returns zeros until a
+// latency has been recorded.
+func NewLatencyStats() (dst chan<- *httpsource.RequestResponsePair, quantiles func(qs ...float64) []time.Duration) {
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	var lock sync.Mutex
+	sample := make([]time.Duration, 0, latencyReservoirSize)
+	seen := 0
+	go func() {
+		for pair := range input {
+			latency := pair.Latency()
+			if latency < 0 {
+				continue
+			}
+			lock.Lock()
+			seen++
+			if len(sample) < latencyReservoirSize {
+				sample = append(sample, latency)
+			} else if i := rand.Intn(seen); i < latencyReservoirSize {
+				sample[i] = latency
+			}
+			lock.Unlock()
+		}
+	}()
+	return input, func(qs ...float64) []time.Duration {
+		lock.Lock()
+		sorted := append([]time.Duration(nil), sample...)
+		lock.Unlock()
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		results := make([]time.Duration, len(qs))
+		if len(sorted) == 0 {
+			return results
+		}
+		for i, q := range qs {
+			// Nearest-rank method
+			rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+			if rank < 0 {
+				rank = 0
+			} else if rank >= len(sorted) {
+				rank = len(sorted) - 1
+			}
+			results[i] = sorted[rank]
+		}
+		return results
+	}
+}
+
+// SizeOverflow is the NewSizeHistogram bucket counting bodies larger than
+// the top boundary.
+const SizeOverflow int64 = math.MaxInt64
+
+// NewSizeHistogram counts response body sizes into buckets.  Each boundary
+// in buckets is the inclusive upper limit of its bucket, so a body is
+// counted under the smallest boundary at least as large as its length, or
+// under SizeOverflow if it is larger than all of them.  Pairs without a
+// response are ignored.  Pairs are read from dst until it is closed;
+// snapshot returns a copy of the counts so far, with every bucket present.
+func NewSizeHistogram(buckets []int64) (dst chan<- *httpsource.RequestResponsePair, snapshot func() map[int64]int64) {
+	bounds := append([]int64(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	bounds = append(bounds, SizeOverflow)
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	var lock sync.Mutex
+	counts := make([]int64, len(bounds))
+	go func() {
+		for pair := range input {
+			if pair.Response == nil {
+				continue
+			}
+			size := int64(len(pair.ResponseBody))
+			i := sort.Search(len(bounds), func(i int) bool { return bounds[i] >= size })
+			lock.Lock()
+			counts[i]++
+			lock.Unlock()
+		}
+	}()
+	return input, func() map[int64]int64 {
+		lock.Lock()
+		defer lock.Unlock()
+		c := make(map[int64]int64, len(bounds))
+		for i, bound := range bounds {
+			c[bound] += counts[i]
+		}
+		return c
+	}
+}
+
+// reservoir is a uniform random sample of the pairs added to it
+type reservoir struct {
+	lock   sync.Mutex
+	sample []*httpsource.RequestResponsePair
+	seen   int
+}
+
+func newReservoir(k int) *reservoir {
+	return &reservoir{sample: make([]*httpsource.RequestResponsePair, 0, k)}
+}
+
+// add offers a pair to the sample, using Algorithm R
+func (r *reservoir) add(pair *httpsource.RequestResponsePair) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seen++
+	if len(r.sample) < cap(r.sample) {
+		r.sample = append(r.sample, pair)
+	} else if i := rand.Intn(r.seen); i < len(r.sample) {
+		r.sample[i] = pair
+	}
+}
+
+func (r *reservoir) snapshot() []*httpsource.RequestResponsePair {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*httpsource.RequestResponsePair(nil), r.sample...)
+}
+
+// NewReservoirSink keeps a uniform random sample of up to k of the pairs
+// read from dst, using Algorithm R, so that at any time every pair seen is
+// equally likely to be in the sample.  Pairs are read from dst until it is
+// closed; snapshot returns a copy of the sample so far, which holds every
+// pair until more than k have been seen.  The pairs themselves are shared,
+// not cloned.  Panics if k < 1.
+func NewReservoirSink(k int) (dst chan<- *httpsource.RequestResponsePair, snapshot func() []*httpsource.RequestResponsePair) {
+	if k < 1 {
+		panic("NewReservoirSink requires k >= 1")
+	}
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	r := newReservoir(k)
+	go func() {
+		for pair := range input {
+			r.add(pair)
+		}
+	}()
+	return input, r.snapshot
+}
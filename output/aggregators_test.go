@@ -0,0 +1,117 @@
+package output
+
+import (
+	"github.com/Matir/httpwatch/httpsource"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it is true or a second passes
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition.\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatusCodeAggregator(t *testing.T) {
+	dst, snapshot := NewStatusCodeAggregator()
+	for _, code := range []int{200, 404, 200} {
+		dst <- &httpsource.RequestResponsePair{Response: &http.Response{StatusCode: code}}
+	}
+	dst <- &httpsource.RequestResponsePair{}
+	close(dst)
+	waitFor(t, func() bool { return snapshot()[200] == 2 })
+	counts := snapshot()
+	if counts[404] != 1 || len(counts) != 2 {
+		t.Errorf("Unexpected counts: %v\n", counts)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	dst, quantiles := NewLatencyStats()
+	if q := quantiles(0.5); q[0] != 0 {
+		t.Errorf("Expected zero with no data, got %v\n", q)
+	}
+	start := time.Unix(1000, 0)
+	for i := 1; i <= 100; i++ {
+		dst <- &httpsource.RequestResponsePair{
+			Timestamp:   start,
+			ResponseEnd: start.Add(time.Duration(i) * time.Millisecond),
+		}
+	}
+	// Unknown latency is ignored
+	dst <- &httpsource.RequestResponsePair{Timestamp: start}
+	close(dst)
+	waitFor(t, func() bool { return quantiles(1)[0] == 100*time.Millisecond })
+	q := quantiles(0.5, 0.9, 0.99)
+	expected := []time.Duration{50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond}
+	for i := range q {
+		if q[i] != expected[i] {
+			t.Errorf("Expected %v, got %v\n", expected, q)
+			break
+		}
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	dst, snapshot := NewSizeHistogram([]int64{1024, 100})
+	for _, size := range []int{0, 100, 101, 1024, 5000} {
+		dst <- &httpsource.RequestResponsePair{Response: &http.Response{}, ResponseBody: make([]byte, size)}
+	}
+	dst <- &httpsource.RequestResponsePair{ResponseBody: make([]byte, 10)}
+	close(dst)
+	waitFor(t, func() bool { return snapshot()[SizeOverflow] == 1 })
+	counts := snapshot()
+	if counts[100] != 2 || counts[1024] != 2 || len(counts) != 3 {
+		t.Errorf("Unexpected counts: %v\n", counts)
+	}
+}
+
+func TestReservoirSink(t *testing.T) {
+	dst, snapshot := NewReservoirSink(3)
+	pairs := make([]*httpsource.RequestResponsePair, 3)
+	for i := range pairs {
+		pairs[i] = &httpsource.RequestResponsePair{Seq: uint64(i + 1)}
+		dst <- pairs[i]
+	}
+	close(dst)
+	waitFor(t, func() bool { return len(snapshot()) == 3 })
+	sample := snapshot()
+	for i, p := range sample {
+		if p != pairs[i] {
+			t.Errorf("Expected every pair kept before the reservoir fills, got %+v\n", p)
+		}
+	}
+	sample[0] = nil
+	if snapshot()[0] == nil {
+		t.Error("Expected snapshot to return a copy.\n")
+	}
+}
+
+func TestReservoirUniform(t *testing.T) {
+	// Each of 10 pairs should land in a sample of 5 about half the time
+	hits := make([]int, 10)
+	for trial := 0; trial < 1000; trial++ {
+		r := newReservoir(5)
+		for i := range hits {
+			r.add(&httpsource.RequestResponsePair{Seq: uint64(i)})
+		}
+		sample := r.snapshot()
+		if len(sample) != 5 {
+			t.Fatalf("Expected a sample of 5, got %d\n", len(sample))
+		}
+		for _, p := range sample {
+			hits[p.Seq]++
+		}
+	}
+	for i, n := range hits {
+		if n < 400 || n > 600 {
+			t.Errorf("Pair %d sampled %d of 1000 times, expected about 500\n", i, n)
+		}
+	}
+}
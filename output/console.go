@@ -0,0 +1,172 @@
+package output
+
+import (
+	"fmt"
+	"github.com/Matir/httpwatch/httpsource"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ConsoleOptions controls the output of NewConsoleSink.
+type ConsoleOptions struct {
+	// Verbose prints the request and response headers after each line
+	Verbose bool
+	// Bodies prints the request and response bodies after each line
+	Bodies bool
+	// NoColor disables color, which is otherwise used if w is a terminal
+	NoColor bool
+	// MaxBodyLen truncates printed bodies to this many bytes, if > 0
+	MaxBodyLen int
+}
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// consoleSink prints a line per pair for a human to read
+type consoleSink struct {
+	w     io.Writer
+	opts  ConsoleOptions
+	color bool
+}
+
+func newConsoleSink(w io.Writer, opts ConsoleOptions) *consoleSink {
+	return &consoleSink{w: w, opts: opts, color: !opts.NoColor && isTerminal(w)}
+}
+
+// NewConsoleSink prints a compact line for each pair read from the returned
+// channel: method, URL, status, latency and response body size.
+func NewConsoleSink(w io.Writer, opts ConsoleOptions) chan<- *httpsource.RequestResponsePair {
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	go func() {
+		s := newConsoleSink(w, opts)
+		for pair := range input {
+			s.Write(pair)
+		}
+	}()
+	return input
+}
+
+func (s *consoleSink) Write(pair *httpsource.RequestResponsePair) {
+	method, url := "-", "-"
+	if req := pair.Request; req != nil {
+		method = req.Method
+		if req.URL != nil {
+			u := *req.URL
+			if u.Host == "" {
+				u.Host = req.Host
+			}
+			url = u.String()
+		}
+	}
+	status := "-"
+	if pair.Response != nil {
+		status = s.colorize(statusColor(pair.Response.StatusCode), strconv.Itoa(pair.Response.StatusCode))
+	}
+	latency := "-"
+	if l := pair.Latency(); l >= 0 {
+		latency = l.Round(time.Millisecond).String()
+	}
+	fmt.Fprintf(s.w, "%s %s %s %s %dB\n", method, url, status, latency, len(pair.ResponseBody))
+
+	if s.opts.Verbose {
+		if pair.Request != nil {
+			s.writeHeaders("> ", pair.Request.Header)
+		}
+		if pair.Response != nil {
+			s.writeHeaders("< ", pair.Response.Header)
+		}
+	}
+	if s.opts.Bodies {
+		s.writeBody("> ", pair.RequestBody)
+		s.writeBody("< ", pair.ResponseBody)
+	}
+}
+
+func (s *consoleSink) writeHeaders(prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, val := range h[name] {
+			fmt.Fprintf(s.w, "%s%s: %s\n", prefix, name, val)
+		}
+	}
+}
+
+func (s *consoleSink) writeBody(prefix string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	suffix := ""
+	if s.opts.MaxBodyLen > 0 && len(body) > s.opts.MaxBodyLen {
+		suffix = fmt.Sprintf("... (%d bytes)", len(body))
+		body = body[:s.opts.MaxBodyLen]
+	}
+	fmt.Fprintf(s.w, "%s%s%s\n", prefix, body, suffix)
+}
+
+func (s *consoleSink) colorize(color, text string) string {
+	if !s.color || color == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func statusColor(code int) string {
+	switch {
+	case code >= 500:
+		return colorRed
+	case code >= 400:
+		return colorYellow
+	case code >= 300:
+		return colorCyan
+	case code >= 200:
+		return colorGreen
+	}
+	return ""
+}
+
+// isTerminal reports whether w is a character device, such as a TTY
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Prints to stdout.  Options "verbose" and "bodies" enable headers and
+// bodies, "color" set to "false" disables color, and "maxbody" truncates
+// bodies.
+func makeConsoleSink(options map[string]string) OutputSink {
+	opts := ConsoleOptions{
+		Verbose: options["verbose"] == "true",
+		Bodies:  options["bodies"] == "true",
+		NoColor: options["color"] == "false",
+	}
+	if max, ok := options["maxbody"]; ok {
+		n, err := strconv.Atoi(max)
+		if err != nil {
+			logger.Printf("Invalid maxbody %s: %s\n", max, err)
+			return nil
+		}
+		opts.MaxBodyLen = n
+	}
+	return newConsoleSink(os.Stdout, opts)
+}
+
+func init() {
+	outputSinkRegistry["console"] = makeConsoleSink
+}
@@ -0,0 +1,45 @@
+package output
+
+import (
+	"bytes"
+	"github.com/Matir/httpwatch/httpsource"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConsoleSink(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/submit", nil)
+	req.Header.Set("Accept", "*/*")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pair := &httpsource.RequestResponsePair{
+		Request:      req,
+		RequestBody:  []byte("request"),
+		Response:     &http.Response{StatusCode: 404, Header: http.Header{}},
+		ResponseBody: []byte("not found here"),
+		Timestamp:    start,
+		ResponseEnd:  start.Add(15 * time.Millisecond),
+	}
+
+	var buf bytes.Buffer
+	newConsoleSink(&buf, ConsoleOptions{}).Write(pair)
+	if out := buf.String(); out != "POST http://example.com/submit 404 15ms 14B\n" {
+		t.Errorf("Unexpected line: %q\n", out)
+	}
+
+	buf.Reset()
+	newConsoleSink(&buf, ConsoleOptions{Verbose: true, Bodies: true, MaxBodyLen: 3}).Write(pair)
+	expected := "POST http://example.com/submit 404 15ms 14B\n" +
+		"> Accept: */*\n" +
+		"> req... (7 bytes)\n" +
+		"< not... (14 bytes)\n"
+	if out := buf.String(); out != expected {
+		t.Errorf("Unexpected verbose output: %q\n", out)
+	}
+
+	s := newConsoleSink(&buf, ConsoleOptions{})
+	s.color = true
+	if c := s.colorize(statusColor(500), "500"); c != colorRed+"500"+colorReset {
+		t.Errorf("Unexpected colored status: %q\n", c)
+	}
+}
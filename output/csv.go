@@ -0,0 +1,154 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/Matir/httpwatch/httpsource"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns maps each column NewCSVSink knows to how its value is found
+var csvColumns = map[string]func(*httpsource.RequestResponsePair) string{
+	"timestamp": func(p *httpsource.RequestResponsePair) string {
+		if p.Timestamp.IsZero() {
+			return ""
+		}
+		return p.Timestamp.UTC().Format(time.RFC3339Nano)
+	},
+	"method": func(p *httpsource.RequestResponsePair) string {
+		if p.Request == nil {
+			return ""
+		}
+		return p.Request.Method
+	},
+	"url": func(p *httpsource.RequestResponsePair) string {
+		return p.NormalizedURL(httpsource.NormalizeOptions{})
+	},
+	"status": func(p *httpsource.RequestResponsePair) string {
+		if p.Response == nil {
+			return ""
+		}
+		return strconv.Itoa(p.Response.StatusCode)
+	},
+	"latency_ms": func(p *httpsource.RequestResponsePair) string {
+		l := p.Latency()
+		if l < 0 {
+			return ""
+		}
+		return strconv.FormatFloat(float64(l)/float64(time.Millisecond), 'f', 3, 64)
+	},
+	"req_bytes": func(p *httpsource.RequestResponsePair) string {
+		return strconv.Itoa(len(p.RequestBody))
+	},
+	"resp_bytes": func(p *httpsource.RequestResponsePair) string {
+		return strconv.Itoa(len(p.ResponseBody))
+	},
+	"content_type": func(p *httpsource.RequestResponsePair) string {
+		return p.DetectContentType()
+	},
+}
+
+// DefaultCSVColumns are the columns written when none are chosen.
+var DefaultCSVColumns = []string{"timestamp", "method", "url", "status", "latency_ms", "req_bytes", "resp_bytes", "content_type"}
+
+// csvSink writes a CSV row per pair
+type csvSink struct {
+	w       *csv.Writer
+	columns []string
+}
+
+// checkCSVColumns returns an error naming the first unknown column
+func checkCSVColumns(columns []string) error {
+	for _, col := range columns {
+		if _, ok := csvColumns[col]; !ok {
+			return fmt.Errorf("Unknown CSV column %s", col)
+		}
+	}
+	return nil
+}
+
+func newCSVSink(w io.Writer, columns []string) (*csvSink, error) {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	if err := checkCSVColumns(columns); err != nil {
+		return nil, err
+	}
+	s := &csvSink{w: csv.NewWriter(w), columns: columns}
+	s.w.Write(columns)
+	s.w.Flush()
+	return s, nil
+}
+
+// Write adds a row for pair.  Rows are flushed as they are written, so the
+// file can be followed.
+func (s *csvSink) Write(pair *httpsource.RequestResponsePair) {
+	row := make([]string, len(s.columns))
+	for i, col := range s.columns {
+		row[i] = csvColumns[col](pair)
+	}
+	s.w.Write(row)
+	s.w.Flush()
+}
+
+// NewCSVSink writes a header row of columns to w, then a row for each pair
+// read from the returned channel.  The columns may be any of
+// DefaultCSVColumns, which are used if columns is empty; an unknown column
+// is an error.
+func NewCSVSink(w io.Writer, columns []string) (chan<- *httpsource.RequestResponsePair, error) {
+	s, err := newCSVSink(w, columns)
+	if err != nil {
+		return nil, err
+	}
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	go func() {
+		for pair := range input {
+			s.Write(pair)
+		}
+	}()
+	return input, nil
+}
+
+// Writes to the file in the "file" option, or stdout, with the
+// comma-separated "columns" option.
+func makeCSVSink(options map[string]string) OutputSink {
+	var columns []string
+	if cols := options["columns"]; cols != "" {
+		columns = strings.Split(cols, ",")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+	}
+	// Check the columns first, so a typo doesn't truncate the file
+	if err := checkCSVColumns(columns); err != nil {
+		logger.Printf("Unable to create CSV sink: %s\n", err)
+		return nil
+	}
+	w := io.Writer(os.Stdout)
+	var fp *os.File
+	if fname, ok := options["file"]; ok {
+		var err error
+		if fp, err = os.Create(fname); err != nil {
+			logger.Printf("Unable to open %s: %s\n", fname, err)
+			return nil
+		}
+		w = fp
+	}
+	s, err := newCSVSink(w, columns)
+	if err != nil {
+		logger.Printf("Unable to create CSV sink: %s\n", err)
+		if fp != nil {
+			fp.Close()
+		}
+		return nil
+	}
+	return s
+}
+
+func init() {
+	outputSinkRegistry["csv"] = makeCSVSink
+}
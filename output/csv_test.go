@@ -0,0 +1,76 @@
+package output
+
+import (
+	"bytes"
+	"github.com/Matir/httpwatch/httpsource"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCSVSink(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/a?b=1", nil)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pair := &httpsource.RequestResponsePair{
+		Request:      req,
+		Response:     &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/plain, x"}}},
+		ResponseBody: []byte("hello"),
+		Timestamp:    start,
+		ResponseEnd:  start.Add(1500 * time.Microsecond),
+	}
+	var buf bytes.Buffer
+	s, err := newCSVSink(&buf, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	s.Write(pair)
+	s.Write(&httpsource.RequestResponsePair{})
+	expected := "timestamp,method,url,status,latency_ms,req_bytes,resp_bytes,content_type\n" +
+		"2020-01-01T00:00:00Z,GET,http://example.com/a?b=1,200,1.500,0,5,\"text/plain, x\"\n" +
+		",,,,,0,0,\n"
+	if out := buf.String(); out != expected {
+		t.Errorf("Unexpected CSV:\n%s\n", out)
+	}
+
+	buf.Reset()
+	s, err = newCSVSink(&buf, []string{"status", "method"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	s.Write(pair)
+	if out := buf.String(); out != "status,method\n200,GET\n" {
+		t.Errorf("Unexpected CSV with chosen columns: %q\n", out)
+	}
+
+	if _, err := NewCSVSink(&buf, []string{"status", "bogus"}); err == nil {
+		t.Error("Expected error for unknown column.\n")
+	}
+}
+
+func TestMakeCSVSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "out.csv")
+	if err := ioutil.WriteFile(fname, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s := makeCSVSink(map[string]string{"file": fname, "columns": "status,bogus"}); s != nil {
+		t.Error("Expected no sink for an unknown column.\n")
+	}
+	if data, _ := ioutil.ReadFile(fname); string(data) != "keep" {
+		t.Errorf("Expected an unknown column not to truncate the file, got %q\n", data)
+	}
+
+	if s := makeCSVSink(map[string]string{"file": fname, "columns": "status, method"}); s == nil {
+		t.Fatal("Expected spaces around columns to be ignored.\n")
+	}
+	if data, _ := ioutil.ReadFile(fname); string(data) != "status,method\n" {
+		t.Errorf("Unexpected header: %q\n", data)
+	}
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"bufio"
+	"github.com/Matir/httpwatch/httpsource"
+	"io"
+	"os"
+)
+
+// codecSink writes each pair encoded with a Codec
+type codecSink struct {
+	w     io.Writer
+	codec httpsource.Codec
+	err   error
+}
+
+func newJSONSink(w io.Writer) *codecSink {
+	return &codecSink{w: w, codec: httpsource.JSONCodec{}}
+}
+
+// Write encodes a pair, doing nothing once a write has failed.
+func (s *codecSink) Write(pair *httpsource.RequestResponsePair) {
+	if s.err != nil {
+		return
+	}
+	s.err = s.codec.Encode(s.w, pair)
+}
+
+// NewFileSink writes newline-delimited JSON pairs to w.  Pairs are read from
+// dst until it is closed, at which point the output is flushed and the first
+// write error, if any, is sent on done.  w is not closed.
+func NewFileSink(w io.Writer) (dst chan<- *httpsource.RequestResponsePair, done <-chan error) {
+	return NewFileSinkWithCodec(w, httpsource.JSONCodec{})
+}
+
+// NewFileSinkWithCodec is like NewFileSink, but encodes pairs with codec.
+func NewFileSinkWithCodec(w io.Writer, codec httpsource.Codec) (dst chan<- *httpsource.RequestResponsePair, done <-chan error) {
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	finished := make(chan error, 1)
+	go func() {
+		buf := bufio.NewWriter(w)
+		s := &codecSink{w: buf, codec: codec}
+		for pair := range input {
+			s.Write(pair)
+		}
+		if err := buf.Flush(); s.err == nil {
+			s.err = err
+		}
+		finished <- s.err
+		close(finished)
+	}()
+	return input, finished
+}
+
+// Writes to the file in the "file" option, or stdout.
+func makeJSONSink(options map[string]string) OutputSink {
+	fname, ok := options["file"]
+	if !ok {
+		return newJSONSink(os.Stdout)
+	}
+	fp, err := os.Create(fname)
+	if err != nil {
+		logger.Printf("Unable to open %s: %s\n", fname, err)
+		return nil
+	}
+	return newJSONSink(fp)
+}
+
+func init() {
+	outputSinkRegistry["json"] = makeJSONSink
+}
@@ -0,0 +1,50 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Matir/httpwatch/httpsource"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	var buf bytes.Buffer
+	dst, done := NewFileSink(&buf)
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := http.NewRequest(method, "http://example.com/", nil)
+		dst <- &httpsource.RequestResponsePair{Request: req}
+	}
+	close(dst)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q\n", len(lines), buf.String())
+	}
+	var pair httpsource.RequestResponsePair
+	if err := json.Unmarshal([]byte(lines[1]), &pair); err != nil {
+		t.Fatal(err)
+	}
+	if pair.Request.Method != "POST" {
+		t.Errorf("Expected POST, got %s\n", pair.Request.Method)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestFileSinkError(t *testing.T) {
+	dst, done := NewFileSink(failingWriter{})
+	dst <- &httpsource.RequestResponsePair{}
+	close(dst)
+	if err := <-done; err == nil {
+		t.Error("Expected write error.\n")
+	}
+}
@@ -0,0 +1,111 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"github.com/Matir/httpwatch/httpsource"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+const (
+	// kafkaBatchSize is the most pairs produced in one write
+	kafkaBatchSize = 100
+	// kafkaFlushInterval is how long a partial batch waits
+	kafkaFlushInterval = 500 * time.Millisecond
+)
+
+// kafkaProducer is the part of *kafka.Writer used by NewKafkaSink
+type kafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Replaced in tests
+var newKafkaProducer = func(brokers []string, topic string) kafkaProducer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+}
+
+// NewKafkaSink produces each pair read from dst to topic, encoded with
+// codec, or JSON if codec is nil.  Messages are keyed by keyFn(pair), if
+// keyFn is set, and partitioned by a hash of the key, so pairs with the same
+// key land on the same partition.  Pairs are produced in batches, and each
+// batch is written before more are read, so a slow or unavailable cluster
+// applies backpressure to dst.  Producer errors are sent on errs, or logged
+// and dropped if errs is full.  When dst is closed, the remaining pairs are
+// produced, the producer is closed and errs is closed.
+func NewKafkaSink(brokers []string, topic string, keyFn func(*httpsource.RequestResponsePair) []byte, codec httpsource.Codec) (dst chan<- *httpsource.RequestResponsePair, errs <-chan error) {
+	if codec == nil {
+		codec = httpsource.JSONCodec{}
+	}
+	producer := newKafkaProducer(brokers, topic)
+	input := make(chan *httpsource.RequestResponsePair, kafkaBatchSize)
+	errors := make(chan error, 10)
+	report := func(err error) {
+		select {
+		case errors <- err:
+		default:
+			logger.Printf("Kafka error dropped: %s\n", err)
+		}
+	}
+	go func() {
+		defer close(errors)
+		var (
+			batch  []kafka.Message
+			timer  *time.Timer
+			flushC <-chan time.Time
+		)
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, flushC = nil, nil
+			}
+			if len(batch) == 0 {
+				return
+			}
+			if err := producer.WriteMessages(context.Background(), batch...); err != nil {
+				logger.Printf("Kafka failed to produce %d pairs: %s\n", len(batch), err)
+				report(err)
+			}
+			batch = nil
+		}
+		for {
+			select {
+			case pair, ok := <-input:
+				if !ok {
+					flush()
+					if err := producer.Close(); err != nil {
+						report(err)
+					}
+					return
+				}
+				var buf bytes.Buffer
+				if err := codec.Encode(&buf, pair); err != nil {
+					report(err)
+					continue
+				}
+				msg := kafka.Message{Value: buf.Bytes()}
+				if keyFn != nil {
+					msg.Key = keyFn(pair)
+				}
+				batch = append(batch, msg)
+				if len(batch) >= kafkaBatchSize {
+					flush()
+				} else if timer == nil {
+					timer = time.NewTimer(kafkaFlushInterval)
+					flushC = timer.C
+				}
+			case <-flushC:
+				timer, flushC = nil, nil
+				flush()
+			}
+		}
+	}()
+	return input, errors
+}
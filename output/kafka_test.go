@@ -0,0 +1,83 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"github.com/Matir/httpwatch/httpsource"
+	"github.com/segmentio/kafka-go"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type fakeProducer struct {
+	lock    sync.Mutex
+	batches [][]kafka.Message
+	err     error
+	closed  bool
+}
+
+func (p *fakeProducer) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.batches = append(p.batches, msgs)
+	return p.err
+}
+
+func (p *fakeProducer) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	return nil
+}
+
+func withFakeProducer(p *fakeProducer) func() {
+	old := newKafkaProducer
+	newKafkaProducer = func([]string, string) kafkaProducer { return p }
+	return func() { newKafkaProducer = old }
+}
+
+func TestKafkaSink(t *testing.T) {
+	p := &fakeProducer{}
+	defer withFakeProducer(p)()
+	hostKey := func(pair *httpsource.RequestResponsePair) []byte {
+		return []byte(pair.Request.Host)
+	}
+	dst, errs := NewKafkaSink([]string{"localhost:9092"}, "pairs", hostKey, nil)
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		dst <- &httpsource.RequestResponsePair{Request: &http.Request{Method: "GET", Host: host}}
+	}
+	close(dst)
+	for err := range errs {
+		t.Errorf("Unexpected error: %v\n", err)
+	}
+	if !p.closed {
+		t.Error("Expected producer to be closed.\n")
+	}
+	if len(p.batches) != 1 || len(p.batches[0]) != 2 {
+		t.Fatalf("Expected one batch of 2, got %v\n", p.batches)
+	}
+	msg := p.batches[0][1]
+	if string(msg.Key) != "b.example.com" {
+		t.Errorf("Unexpected key: %s\n", msg.Key)
+	}
+	decoded := &httpsource.RequestResponsePair{}
+	if err := decoded.UnmarshalJSON(msg.Value); err != nil || decoded.Request.Host != "b.example.com" {
+		t.Errorf("Unexpected value %s: %v\n", msg.Value, err)
+	}
+}
+
+func TestKafkaSinkErrors(t *testing.T) {
+	p := &fakeProducer{err: errors.New("broker down")}
+	defer withFakeProducer(p)()
+	dst, errs := NewKafkaSink(nil, "pairs", nil, nil)
+	dst <- &httpsource.RequestResponsePair{}
+	close(dst)
+	var got []error
+	for err := range errs {
+		got = append(got, err)
+	}
+	if len(got) != 1 || got[0] != p.err {
+		t.Errorf("Expected the producer error, got %v\n", got)
+	}
+}
@@ -0,0 +1,62 @@
+package output
+
+import (
+	"context"
+	"github.com/Matir/httpwatch/httpsource"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewOTelSink records a span with tracer for each pair read from the
+// returned channel.  Spans start at the pair's Timestamp and end at its
+// ResponseEnd, and carry the http.method, http.url, http.status_code and
+// http.request_id attributes, the last being the pair's CorrelationID.  If
+// the request has a W3C traceparent header, the span is a child of that
+// trace, so captured exchanges appear in existing traces.
+func NewOTelSink(tracer trace.Tracer) chan<- *httpsource.RequestResponsePair {
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	go func() {
+		for pair := range input {
+			recordSpan(tracer, pair)
+		}
+	}()
+	return input
+}
+
+func recordSpan(tracer trace.Tracer, pair *httpsource.RequestResponsePair) {
+	ctx := context.Background()
+	name := "HTTP"
+	var attrs []attribute.KeyValue
+	if req := pair.Request; req != nil {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(req.Header))
+		name = "HTTP " + req.Method
+		attrs = append(attrs,
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", pair.NormalizedURL(httpsource.NormalizeOptions{})))
+	}
+	if resp := pair.Response; resp != nil {
+		attrs = append(attrs, attribute.Int("http.status_code", resp.StatusCode))
+	}
+	if id := pair.CorrelationID(); id != "" {
+		attrs = append(attrs, attribute.String("http.request_id", id))
+	}
+	opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...)}
+	if !pair.Timestamp.IsZero() {
+		opts = append(opts, trace.WithTimestamp(pair.Timestamp))
+	}
+	_, span := tracer.Start(ctx, name, opts...)
+	if pair.Response != nil && pair.Response.StatusCode >= 500 {
+		span.SetStatus(codes.Error, pair.Response.Status)
+	}
+	end := pair.ResponseEnd
+	if end.IsZero() {
+		end = pair.Timestamp
+	}
+	if end.IsZero() {
+		span.End()
+	} else {
+		span.End(trace.WithTimestamp(end))
+	}
+}
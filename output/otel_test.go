@@ -0,0 +1,52 @@
+package output
+
+import (
+	"github.com/Matir/httpwatch/httpsource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOTelSpan(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+	req, _ := http.NewRequest("GET", "http://example.com/x", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pair := &httpsource.RequestResponsePair{
+		Request:     req,
+		Response:    &http.Response{StatusCode: 503, Status: "503 Service Unavailable"},
+		Timestamp:   start,
+		ResponseEnd: start.Add(20 * time.Millisecond),
+		ID:          "req-1",
+	}
+	recordSpan(tracer, pair)
+	recordSpan(tracer, &httpsource.RequestResponsePair{})
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d\n", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "HTTP GET" || !s.StartTime().Equal(start) || s.EndTime().Sub(s.StartTime()) != 20*time.Millisecond {
+		t.Errorf("Unexpected span: %s %v %v\n", s.Name(), s.StartTime(), s.EndTime())
+	}
+	if tid := s.Parent().TraceID().String(); tid != "4bf92f3577b34da6a3ce929d0e0e4736" || s.SpanContext().TraceID().String() != tid {
+		t.Errorf("Expected span in the traceparent's trace, got parent %s\n", tid)
+	}
+	attrs := make(map[string]string)
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.method"] != "GET" || attrs["http.url"] != "http://example.com/x" || attrs["http.status_code"] != "503" || attrs["http.request_id"] != "req-1" {
+		t.Errorf("Unexpected attributes: %v\n", attrs)
+	}
+	if s.Status().Description != "503 Service Unavailable" {
+		t.Errorf("Expected error status, got %+v\n", s.Status())
+	}
+	if spans[1].Parent().IsValid() {
+		t.Error("Expected a root span without traceparent.\n")
+	}
+}
@@ -47,7 +47,11 @@ func (e *OutputEngine) AddOutput(name string, options map[string]string) error {
 	if o == nil {
 		return fmt.Errorf("Invalid output type %s", name)
 	}
-	c := e.mux.AddOutput("output:"+name, 20)
+	// Several outputs may share a sink type, so number any repeats
+	c, err := e.mux.AddOutput("output:"+name, 20)
+	for i := 2; err != nil; i++ {
+		c, err = e.mux.AddOutput(fmt.Sprintf("output:%s:%d", name, i), 20)
+	}
 	e.active++
 	go func() {
 		for pair := range c {
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"github.com/Matir/httpwatch/httpsource"
+	"time"
+)
+
+// Replaced in tests
+var sleep = time.Sleep
+
+// Retrying wraps a delivery function for an unreliable consumer, retrying
+// failed deliveries with exponential backoff.
+type Retrying struct {
+	// Attempts is the most times a delivery is tried, at least once
+	Attempts int
+	// Backoff is the delay after the first failure, doubling after each
+	// further failure
+	Backoff time.Duration
+	// OnFailure, if set, is called with the last error when a pair could
+	// not be delivered in Attempts tries
+	OnFailure func(pair *httpsource.RequestResponsePair, err error)
+
+	deliver func(*httpsource.RequestResponsePair) error
+}
+
+// NewRetrying wraps deliver so that each pair is tried up to attempts times.
+func NewRetrying(deliver func(*httpsource.RequestResponsePair) error, attempts int, backoff time.Duration) *Retrying {
+	return &Retrying{Attempts: attempts, Backoff: backoff, deliver: deliver}
+}
+
+// Deliver delivers a pair, retrying on error, and returns the last error if
+// every attempt failed.
+func (r *Retrying) Deliver(pair *httpsource.RequestResponsePair) error {
+	err := r.do(func() error {
+		return r.deliver(pair)
+	})
+	if err != nil && r.OnFailure != nil {
+		r.OnFailure(pair, err)
+	}
+	return err
+}
+
+// Write implements OutputSink, so a Retrying can be used as a sink
+func (r *Retrying) Write(pair *httpsource.RequestResponsePair) {
+	r.Deliver(pair)
+}
+
+// do calls fn until it succeeds or Attempts is exhausted
+func (r *Retrying) do(fn func() error) error {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Attempts {
+			return err
+		}
+		sleep(backoff)
+		backoff *= 2
+	}
+}
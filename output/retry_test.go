@@ -0,0 +1,42 @@
+package output
+
+import (
+	"errors"
+	"github.com/Matir/httpwatch/httpsource"
+	"testing"
+	"time"
+)
+
+func TestRetrying(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	r := NewRetrying(func(_ *httpsource.RequestResponsePair) error {
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	}, 5, 10*time.Millisecond)
+	if err := r.Deliver(&httpsource.RequestResponsePair{}); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+	}
+	if calls != 3 || len(slept) != 2 || slept[0] != 10*time.Millisecond || slept[1] != 20*time.Millisecond {
+		t.Errorf("Unexpected retries: %d calls, slept %v\n", calls, slept)
+	}
+
+	var failed *httpsource.RequestResponsePair
+	pair := &httpsource.RequestResponsePair{}
+	r = NewRetrying(func(_ *httpsource.RequestResponsePair) error {
+		return errors.New("permanent")
+	}, 2, time.Millisecond)
+	r.OnFailure = func(p *httpsource.RequestResponsePair, _ error) { failed = p }
+	if err := r.Deliver(pair); err == nil {
+		t.Error("Expected error after exhausting attempts.\n")
+	}
+	if failed != pair {
+		t.Error("Expected OnFailure to be called with the pair.\n")
+	}
+}
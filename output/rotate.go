@@ -0,0 +1,220 @@
+package output
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/Matir/httpwatch/httpsource"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RotateOptions controls when a rotating file sink starts a new segment.
+type RotateOptions struct {
+	// MaxBytes is the size a segment may reach before the next pair goes to
+	// a new one.  Zero means no size limit.
+	MaxBytes int64
+	// MaxAge is how long a segment is written to before it is closed.  Zero
+	// means no age limit.
+	MaxAge time.Duration
+	// Gzip compresses each segment once it is closed, replacing it with a
+	// .gz file.
+	Gzip bool
+}
+
+// rotatingSink writes pairs to the current segment in dir, opening one
+// whenever there is a pair to write and no open segment
+type rotatingSink struct {
+	dir     string
+	codec   httpsource.Codec
+	opts    RotateOptions
+	fp      *os.File
+	buf     *bufio.Writer
+	w       io.Writer
+	size    int64
+	lock    sync.Mutex
+	err     error
+	pending sync.WaitGroup
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// NewRotatingFileSink writes pairs encoded with codec to segment files in
+// dir, named for the time they were opened.  A new segment is started once
+// the current one reaches maxBytes, or maxAge after it was opened; either
+// limit may be zero to disable it.  A nil codec means JSON.  Pairs are read
+// from dst until it is closed, at which point the active segment is flushed
+// and closed and the first error, if any, is sent on done.
+func NewRotatingFileSink(dir string, maxBytes int64, maxAge time.Duration, codec httpsource.Codec) (dst chan<- *httpsource.RequestResponsePair, done <-chan error) {
+	return NewRotatingFileSinkWithOptions(dir, codec, RotateOptions{MaxBytes: maxBytes, MaxAge: maxAge})
+}
+
+// NewRotatingFileSinkWithOptions is like NewRotatingFileSink, with the
+// rotation controlled by opts.
+func NewRotatingFileSinkWithOptions(dir string, codec httpsource.Codec, opts RotateOptions) (dst chan<- *httpsource.RequestResponsePair, done <-chan error) {
+	if codec == nil {
+		codec = httpsource.JSONCodec{}
+	}
+	input := make(chan *httpsource.RequestResponsePair, 20)
+	finished := make(chan error, 1)
+	s := &rotatingSink{dir: dir, codec: codec, opts: opts}
+	go func() {
+		var expired <-chan time.Time
+		var timer *time.Timer
+	loop:
+		for {
+			select {
+			case pair, ok := <-input:
+				if !ok {
+					break loop
+				}
+				if s.fp == nil {
+					if err := s.open(); err != nil {
+						s.setErr(err)
+						continue
+					}
+					if opts.MaxAge > 0 {
+						timer = time.NewTimer(opts.MaxAge)
+						expired = timer.C
+					}
+				}
+				s.write(pair)
+				if opts.MaxBytes > 0 && s.size >= opts.MaxBytes {
+					s.close()
+					if timer != nil {
+						timer.Stop()
+					}
+					expired = nil
+				}
+			case <-expired:
+				s.close()
+				expired = nil
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		s.close()
+		s.pending.Wait()
+		finished <- s.err
+		close(finished)
+	}()
+	return input, finished
+}
+
+// open starts a new segment
+func (s *rotatingSink) open() error {
+	now := time.Now().UTC()
+	base := filepath.Join(s.dir, "httpwatch-"+now.Format("20060102T150405.000000000Z"))
+	ext := segmentExt(s.codec)
+	path := base + ext
+	for i := 1; ; i++ {
+		fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			s.fp = fp
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		path = base + "-" + strconv.Itoa(i) + ext
+	}
+	s.buf = bufio.NewWriter(s.fp)
+	s.w = countingWriter{s.buf, &s.size}
+	s.size = 0
+	return nil
+}
+
+// write encodes a pair to the current segment
+func (s *rotatingSink) write(pair *httpsource.RequestResponsePair) {
+	if err := s.codec.Encode(s.w, pair); err != nil {
+		s.setErr(err)
+	}
+}
+
+// close flushes and closes the current segment, if there is one, and starts
+// compressing it if asked to
+func (s *rotatingSink) close() {
+	if s.fp == nil {
+		return
+	}
+	if err := s.buf.Flush(); err != nil {
+		s.setErr(err)
+	}
+	if err := s.fp.Close(); err != nil {
+		s.setErr(err)
+	}
+	if s.opts.Gzip {
+		s.pending.Add(1)
+		go func(path string) {
+			defer s.pending.Done()
+			if err := gzipFile(path); err != nil {
+				logger.Printf("Unable to compress %s: %s\n", path, err)
+				s.setErr(err)
+			}
+		}(s.fp.Name())
+	}
+	s.fp = nil
+	s.buf = nil
+	s.w = nil
+}
+
+// setErr records the first error
+func (s *rotatingSink) setErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// gzipFile replaces the file at path with a gzipped copy named path.gz
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return fmt.Errorf("Compressing segment: %s", err)
+	}
+	return os.Remove(path)
+}
+
+// segmentExt returns the file extension for a codec's output
+func segmentExt(codec httpsource.Codec) string {
+	switch codec.(type) {
+	case httpsource.JSONCodec, *httpsource.JSONCodec:
+		return ".json"
+	case httpsource.ProtoCodec, *httpsource.ProtoCodec:
+		return ".pb"
+	}
+	return ".log"
+}
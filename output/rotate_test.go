@@ -0,0 +1,117 @@
+package output
+
+import (
+	"compress/gzip"
+	"github.com/Matir/httpwatch/httpsource"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rotateTestPair(t *testing.T) *httpsource.RequestResponsePair {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &httpsource.RequestResponsePair{Request: req}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "httpwatch-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRotatingFileSinkSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Each pair is larger than the limit, so gets its own segment
+	dst, done := NewRotatingFileSink(dir, 10, 0, nil)
+	for i := 0; i < 3; i++ {
+		dst <- rotateTestPair(t)
+	}
+	close(dst)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	files := segmentFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 segments, got %v\n", files)
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json") {
+			t.Errorf("Expected .json segment, got %s\n", name)
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "\n"); n != 1 {
+			t.Errorf("Expected 1 pair in %s, got %d\n", name, n)
+		}
+	}
+}
+
+func TestRotatingFileSinkAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, done := NewRotatingFileSinkWithOptions(dir, httpsource.JSONCodec{},
+		RotateOptions{MaxAge: 20 * time.Millisecond, Gzip: true})
+	dst <- rotateTestPair(t)
+	dst <- rotateTestPair(t)
+	time.Sleep(100 * time.Millisecond)
+	dst <- rotateTestPair(t)
+	close(dst)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	files := segmentFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 segments, got %v\n", files)
+	}
+	lines := 0
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".json.gz") {
+			t.Errorf("Expected compressed segment, got %s\n", name)
+			continue
+		}
+		fp, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		fp.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines += strings.Count(string(data), "\n")
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 pairs across segments, got %d\n", lines)
+	}
+}
+
+func TestRotatingFileSinkError(t *testing.T) {
+	dst, done := NewRotatingFileSink(filepath.Join(os.TempDir(), "no-such-dir-httpwatch", "x"), 0, 0, nil)
+	dst <- rotateTestPair(t)
+	close(dst)
+	if err := <-done; err == nil {
+		t.Error("Expected error for a missing directory.\n")
+	}
+}
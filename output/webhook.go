@@ -0,0 +1,160 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Matir/httpwatch/httpsource"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebhookOptions controls the batching and retries of NewWebhookSink.  Zero
+// values select the defaults.
+type WebhookOptions struct {
+	// BatchSize is the most pairs sent in one POST, default 100
+	BatchSize int
+	// FlushInterval is how long a partial batch waits, default 1s
+	FlushInterval time.Duration
+	// Retries is how many times a failed POST is retried, default 3.  Set
+	// it negative to disable retries
+	Retries int
+	// Backoff is the delay before the first retry, doubling for each
+	// further retry, default 100ms
+	Backoff time.Duration
+	// MaxInFlight is the most POSTs outstanding at once, default 1
+	MaxInFlight int
+	// DropWhenBusy drops batches when MaxInFlight POSTs are outstanding,
+	// rather than waiting, so a slow collector never applies backpressure
+	DropWhenBusy bool
+	// Client is used to send requests, default http.DefaultClient
+	Client *http.Client
+}
+
+func (o *WebhookOptions) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	} else if o.Retries == 0 {
+		o.Retries = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+	if o.MaxInFlight <= 0 {
+		o.MaxInFlight = 1
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+}
+
+// NewWebhookSink POSTs pairs read from dst to url as JSON arrays of up to
+// BatchSize pairs.  A batch is sent when it is full or FlushInterval after
+// its first pair.  When dst is closed, the remaining pairs are sent and the
+// last error, if any, is sent on done.
+func NewWebhookSink(url string, opts WebhookOptions) (dst chan<- *httpsource.RequestResponsePair, done <-chan error) {
+	opts.setDefaults()
+	input := make(chan *httpsource.RequestResponsePair, opts.BatchSize)
+	finished := make(chan error, 1)
+	go func() {
+		var (
+			wg       sync.WaitGroup
+			errLock  sync.Mutex
+			lastErr  error
+			inFlight = make(chan struct{}, opts.MaxInFlight)
+			batch    []*httpsource.RequestResponsePair
+			timer    *time.Timer
+			flushC   <-chan time.Time
+		)
+		setErr := func(err error) {
+			errLock.Lock()
+			defer errLock.Unlock()
+			lastErr = err
+		}
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, flushC = nil, nil
+			}
+			if len(batch) == 0 {
+				return
+			}
+			pairs := batch
+			batch = nil
+			if opts.DropWhenBusy {
+				select {
+				case inFlight <- struct{}{}:
+				default:
+					logger.Printf("Webhook busy, dropping %d pairs.\n", len(pairs))
+					setErr(fmt.Errorf("Dropped %d pairs with %d requests in flight", len(pairs), opts.MaxInFlight))
+					return
+				}
+			} else {
+				inFlight <- struct{}{}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				if err := postWebhook(url, pairs, &opts); err != nil {
+					logger.Printf("Webhook failed to send %d pairs: %s\n", len(pairs), err)
+					setErr(err)
+				}
+			}()
+		}
+		for {
+			select {
+			case pair, ok := <-input:
+				if !ok {
+					flush()
+					wg.Wait()
+					finished <- lastErr
+					close(finished)
+					return
+				}
+				batch = append(batch, pair)
+				if len(batch) >= opts.BatchSize {
+					flush()
+				} else if timer == nil {
+					timer = time.NewTimer(opts.FlushInterval)
+					flushC = timer.C
+				}
+			case <-flushC:
+				timer, flushC = nil, nil
+				flush()
+			}
+		}
+	}()
+	return input, finished
+}
+
+// postWebhook sends a batch, retrying with exponential backoff
+func postWebhook(url string, pairs []*httpsource.RequestResponsePair, opts *WebhookOptions) error {
+	body, err := json.Marshal(pairs)
+	if err != nil {
+		return err
+	}
+	retry := Retrying{Attempts: opts.Retries + 1, Backoff: opts.Backoff}
+	return retry.do(func() error {
+		return postWebhookOnce(url, body, opts.Client)
+	})
+}
+
+func postWebhookOnce(url string, body []byte, client *http.Client) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package output
+
+import (
+	"encoding/json"
+	"github.com/Matir/httpwatch/httpsource"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var lock sync.Mutex
+	var batches []int
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var pairs []*httpsource.RequestResponsePair
+		if err := json.NewDecoder(r.Body).Decode(&pairs); err != nil {
+			t.Errorf("Invalid batch: %v\n", err)
+		}
+		batches = append(batches, len(pairs))
+	}))
+	defer srv.Close()
+
+	dst, done := NewWebhookSink(srv.URL, WebhookOptions{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Backoff:       time.Millisecond,
+	})
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		dst <- &httpsource.RequestResponsePair{Request: req}
+	}
+	close(dst)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(batches) != 3 || batches[0] != 2 || batches[2] != 1 {
+		t.Errorf("Unexpected batches: %v\n", batches)
+	}
+}
+
+func TestWebhookSinkFlushInterval(t *testing.T) {
+	received := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- true
+	}))
+	defer srv.Close()
+	dst, done := NewWebhookSink(srv.URL, WebhookOptions{FlushInterval: 10 * time.Millisecond})
+	dst <- &httpsource.RequestResponsePair{}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Error("Expected the partial batch to be flushed.\n")
+	}
+	close(dst)
+	<-done
+}
+
+func TestWebhookSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	dst, done := NewWebhookSink(srv.URL, WebhookOptions{Retries: 1, Backoff: time.Millisecond})
+	dst <- &httpsource.RequestResponsePair{}
+	close(dst)
+	if err := <-done; err == nil {
+		t.Error("Expected an error from a failing webhook.\n")
+	}
+}
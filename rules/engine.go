@@ -31,22 +31,29 @@ func NewRuleEngine(rules []Rule, mux httpsource.PairMux) *RuleEngine {
 	r.finished = make(chan *ruleContainer, len(rules)*2)
 	r.allDone = make(chan bool)
 	for _, rule := range rules {
-		r.AddRule(rule)
+		if err := r.AddRule(rule); err != nil {
+			logger.Printf("Skipping rule: %s\n", err)
+		}
 	}
 	return r
 }
 
 // AddRule adds a Rule to the RuleEngine, and starts it if the RuleEngine is
-// already running.
-func (r *RuleEngine) AddRule(rule Rule) {
+// already running.  Returns an error if a rule with the same name exists.
+func (r *RuleEngine) AddRule(rule Rule) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 	name := "rule:" + rule.Name
-	rc := ruleContainer{rule, r.mux.AddOutput(name, 10)}
+	input, err := r.mux.AddOutput(name, 10)
+	if err != nil {
+		return err
+	}
+	rc := ruleContainer{rule, input}
 	r.rules = append(r.rules, rc)
 	if r.running {
 		r.startRule(rc)
 	}
+	return nil
 }
 
 // Start starts each of the rules in a goroutine
@@ -80,6 +87,11 @@ func (r *RuleEngine) startRule(rule ruleContainer) {
 			if !r.Running() {
 				break
 			}
+			// Rules match HTTP exchanges, not WebSocket frames or pairs
+			// missing a side
+			if item.Request == nil || item.Response == nil {
+				continue
+			}
 			if rule.Eval(item) {
 				r.rawMatches <- item
 			}